@@ -0,0 +1,261 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package positive
+
+import (
+	"image"
+	"math"
+	"sync"
+)
+
+// Balance is an automatic white balance method, see Options.Balance.
+type Balance string
+
+const (
+	// BalanceNone disables automatic white balance.
+	BalanceNone Balance = ""
+
+	// BalanceGrayWorld assumes the average color of the image is neutral.
+	BalanceGrayWorld Balance = "grayworld"
+
+	// BalanceHighlights assumes the brightest unclipped 1% of the image is
+	// neutral.
+	BalanceHighlights Balance = "highlights"
+)
+
+// post returns the adjustments applied to the positive, which conv produces
+// from m: the color adjustments, which are applied before any noise
+// reduction, and the tone adjustments, which are applied after.
+func (o Options) post(m image.Image, conv pixelFunc) (adjust, tonal pixelFunc) {
+	var balance pixelFunc
+	switch {
+	case !o.Neutral.Empty():
+		balance = balanceFunc(neutralGains(m, o.Neutral, conv))
+	case o.Balance == BalanceGrayWorld:
+		balance = balanceFunc(neutralGains(m, o.interior(m.Bounds()), conv))
+	case o.Balance == BalanceHighlights:
+		balance = balanceFunc(highlightGains(m, o.interior(m.Bounds()), conv))
+	}
+
+	var warm pixelFunc
+	if o.Temp != 0 || o.Tint != 0 {
+		warm = balanceFunc(tempGains(o.Temp, o.Tint))
+	}
+
+	var ev pixelFunc
+	if o.EV != 0 {
+		// scaling linear light by k scales values encoded with a power law
+		// gamma by k^(1/gamma)
+		k := math.Exp2(o.EV)
+		if !o.Density {
+			k = math.Pow(k, 1/displayGamma)
+		}
+		ev = balanceFunc([3]float64{k, k, k})
+	}
+
+	var mid pixelFunc
+	if o.Midtone > 0 && o.Midtone != 1 {
+		mid = gammaFunc(1/o.Midtone, 1/o.Midtone, 1/o.Midtone)
+	}
+
+	var tone pixelFunc
+	if e := toneExponent(o.Tone, o.Contrast); e != 1 {
+		t := toneCurve(e)
+		tone = curveFunc(t, t, t)
+	}
+
+	var toning pixelFunc
+	if o.Toning != ToningNone {
+		k := tonings[o.Toning]
+		toning = gammaFunc(k[0], k[1], k[2])
+	}
+
+	recovery := recoveryFunc(o.Highlights, o.Shadows, o.Density)
+	return compose(balance, warm, ev), compose(recovery, mid, tone, lookFunc(o.Look), toning, spaceFunc(o.ColorSpace, o.Density))
+}
+
+// Toning colors a black and white positive like a chemical toner, see
+// Options.BW.
+type Toning string
+
+// Toning presets
+const (
+	ToningNone     Toning = ""
+	ToningSepia    Toning = "sepia"
+	ToningSelenium Toning = "selenium"
+)
+
+// per channel exponents of each toner, which leave black and white in place
+// and color the midtones
+var tonings = map[Toning][3]float64{
+	ToningNone:     {1, 1, 1},
+	ToningSepia:    {0.85, 1, 1.3},
+	ToningSelenium: {1, 1.1, 0.95},
+}
+
+// displayGamma is the gamma the positive is assumed to be encoded with, except
+// in density mode, which produces linear output.
+const displayGamma = 2.2
+
+// Tone is a tone curve preset, see Options.Tone.
+type Tone string
+
+// Tone curve presets, from no added contrast to strong.
+const (
+	ToneLinear Tone = "linear"
+	ToneSoft   Tone = "soft"
+	TonePunchy Tone = "punchy"
+)
+
+// S-curve exponents of the tone curve presets
+var tones = map[Tone]float64{
+	"":         1,
+	ToneLinear: 1,
+	ToneSoft:   1.3,
+	TonePunchy: 1.8,
+}
+
+// toneExponent returns the S-curve exponent of the given preset and contrast.
+func toneExponent(t Tone, contrast float64) float64 {
+	return tones[t] * math.Exp2(contrast)
+}
+
+// toneCurve precomputes an S-curve around middle gray with exponent e, which
+// keeps black, white, and middle gray in place while increasing contrast for
+// e > 1, or decreasing it for e < 1. Values beyond black and white are
+// clipped, as the curve flattens out there.
+func toneCurve(e float64) *curve {
+	return newCurve(curveSteps, func(x float64) float64 {
+		return sCurve(x, e)
+	})
+}
+
+// sCurve returns the S-curve with exponent e at x, see toneCurve.
+func sCurve(x, e float64) float64 {
+	x = math.Max(0, math.Min(1, x))
+	a := math.Pow(x, e)
+	return a / (a + math.Pow(1-x, e))
+}
+
+// tempGains returns the channel gains shifting color temperature and tint,
+// from -100 to 100, by up to half a stop along the blue/yellow and
+// green/magenta axes. Shifts are equal steps in log space, which are closer
+// to perceptually even than linear offsets, and keep luminance constant.
+func tempGains(temp, tint float64) [3]float64 {
+	t := temp / 100 * 0.5
+	n := tint / 100 * 0.5
+	k := [3]float64{
+		math.Exp2(t + n/2),
+		math.Exp2(-n),
+		math.Exp2(-t + n/2),
+	}
+
+	// Rec. 709 luminance
+	y := 0.2126*k[0] + 0.7152*k[1] + 0.0722*k[2]
+	for i := range k {
+		k[i] /= y
+	}
+	return k
+}
+
+// WhiteBalance scales the channels of m so that the region rect, such as a
+// gray card, is neutral.
+func WhiteBalance(m image.Image, rect image.Rectangle) image.Image {
+	return mapPixels(m, balanceFunc(neutralGains(m, rect, nil)), 0)
+}
+
+// neutralGains returns the channel gains that make the mean of m within rect,
+// as seen after f, neutral without changing its brightness. f may be nil.
+func neutralGains(m image.Image, rect image.Rectangle, f pixelFunc) [3]float64 {
+	var sum [3]float64
+	scanPixels(m, rect, func(r, g, b uint32) {
+		fr, fg, fb := unit(r), unit(g), unit(b)
+		if f != nil {
+			fr, fg, fb = f(fr, fg, fb)
+		}
+		sum[0] += float64(fr)
+		sum[1] += float64(fg)
+		sum[2] += float64(fb)
+	})
+
+	k := [3]float64{1, 1, 1}
+	mean := (sum[0] + sum[1] + sum[2]) / 3
+	for i, v := range sum {
+		if v > 0 {
+			k[i] = mean / v
+		}
+	}
+	return k
+}
+
+// balanceFunc scales each channel by the gains k.
+func balanceFunc(k [3]float64) pixelFunc {
+	kr, kg, kb := float32(k[0]), float32(k[1]), float32(k[2])
+	return func(r, g, b float32) (float32, float32, float32) {
+		return r * kr, g * kg, b * kb
+	}
+}
+
+// highlightGains returns the channel gains that make the brightest 1% of
+// unclipped pixels of m within rect, as seen after f, neutral.
+func highlightGains(m image.Image, rect image.Rectangle, f pixelFunc) [3]float64 {
+	// bucket pixels by brightness, keeping the sum of each bucket
+	const buckets = 4096
+	var count [buckets]int
+	var sum [buckets][3]float64
+
+	var mu sync.Mutex
+	stripes(rect, 0, func(stripe image.Rectangle) {
+		var sc [buckets]int
+		var ss [buckets][3]float64
+		scanPixels(m, stripe, func(r, g, b uint32) {
+			fr, fg, fb := f(unit(r), unit(g), unit(b))
+			if fr >= 1 || fg >= 1 || fb >= 1 {
+				return
+			}
+			i := int((fr + fg + fb) / 3 * buckets)
+			if i < 0 {
+				i = 0
+			}
+			sc[i]++
+			ss[i][0] += float64(fr)
+			ss[i][1] += float64(fg)
+			ss[i][2] += float64(fb)
+		})
+
+		mu.Lock()
+		for i := range sc {
+			count[i] += sc[i]
+			sum[i][0] += ss[i][0]
+			sum[i][1] += ss[i][1]
+			sum[i][2] += ss[i][2]
+		}
+		mu.Unlock()
+	})
+
+	var total int
+	for _, n := range count {
+		total += n
+	}
+
+	var n int
+	var c [3]float64
+	for i := buckets - 1; i >= 0 && n*100 < total; i-- {
+		n += count[i]
+		c[0] += sum[i][0]
+		c[1] += sum[i][1]
+		c[2] += sum[i][2]
+	}
+
+	k := [3]float64{1, 1, 1}
+	mean := (c[0] + c[1] + c[2]) / 3
+	for i, v := range c {
+		if v > 0 {
+			k[i] = mean / v
+		}
+	}
+	return k
+}
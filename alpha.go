@@ -0,0 +1,71 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package positive
+
+import (
+	"context"
+	"image"
+	"image/color"
+)
+
+// hasAlpha reports whether m may have pixels that aren't opaque.
+func hasAlpha(m image.Image) bool {
+	if o, ok := m.(interface{ Opaque() bool }); ok {
+		return !o.Opaque()
+	}
+	return true
+}
+
+// splitAlpha splits m into an opaque image of its unpremultiplied colors and
+// its alpha channel, both starting at the origin.
+func splitAlpha(ctx context.Context, m image.Image, threads int) (*image.RGBA64, *image.Alpha16, error) {
+	bounds := m.Bounds()
+	c := image.NewRGBA64(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	a := image.NewAlpha16(c.Rect)
+	err := stripesContext(ctx, c.Rect, threads, func(stripe image.Rectangle) {
+		for y := stripe.Min.Y; y < stripe.Max.Y; y++ {
+			for x := 0; x < bounds.Dx(); x++ {
+				p := color.NRGBA64Model.Convert(m.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA64)
+				d := c.Pix[y*c.Stride+x*8:]
+				put16(d, uint32(p.R))
+				put16(d[2:], uint32(p.G))
+				put16(d[4:], uint32(p.B))
+				put16(d[6:], 0xffff)
+				put16(a.Pix[y*a.Stride+x*2:], uint32(p.A))
+			}
+		}
+	})
+	return c, a, err
+}
+
+// transparent returns a mask of the pixels of a that are mostly transparent,
+// to exclude from finding levels.
+func transparent(a *image.Alpha16) *image.Gray {
+	ret := image.NewGray(a.Rect)
+	for i := range ret.Pix {
+		if a.Pix[i*2] < 0x80 {
+			ret.Pix[i] = 0xff
+		}
+	}
+	return ret
+}
+
+// withAlpha returns the opaque image m with the alpha channel a, which is
+// the same size.
+func withAlpha(ctx context.Context, m *image.RGBA64, a *image.Alpha16, threads int) (*image.NRGBA64, error) {
+	ret := image.NewNRGBA64(m.Rect)
+	err := stripesContext(ctx, m.Rect, threads, func(stripe image.Rectangle) {
+		for y := stripe.Min.Y; y < stripe.Max.Y; y++ {
+			j := y - m.Rect.Min.Y
+			d := ret.Pix[j*ret.Stride : j*ret.Stride+m.Rect.Dx()*8]
+			copy(d, m.Pix[j*m.Stride:])
+			for x := 0; x < m.Rect.Dx(); x++ {
+				copy(d[x*8+6:x*8+8], a.Pix[j*a.Stride+x*2:])
+			}
+		}
+	})
+	return ret, err
+}
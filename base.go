@@ -0,0 +1,129 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package positive
+
+import (
+	"image"
+	"image/color"
+)
+
+// Estimating the film base from the frame border. The border band is
+// baseBand percent of each side. Pixels brighter than baseClip (the bare
+// light source) or darker than baseDark (film holder) are ignored, and the
+// brightest basePercentile percent of the rest, the unexposed rebate, are
+// averaged.
+const (
+	baseBand       = 5
+	baseClip       = 0xfff0
+	baseDark       = 0x1000
+	basePercentile = 10
+)
+
+// EstimateBase estimates the film mask color of a negative from the unexposed
+// film (the rebate) around the frame, for when no separate mask sample is
+// available. The scan must include some of the film border. ok is false if
+// no candidate base pixels were found.
+func EstimateBase(m image.Image) (c color.Color, ok bool) {
+	b := m.Bounds()
+	bx := b.Dx() * baseBand / 100
+	by := b.Dy() * baseBand / 100
+	if bx == 0 || by == 0 {
+		return nil, false
+	}
+
+	bands := []image.Rectangle{
+		image.Rect(b.Min.X, b.Min.Y, b.Max.X, b.Min.Y+by),
+		image.Rect(b.Min.X, b.Max.Y-by, b.Max.X, b.Max.Y),
+		image.Rect(b.Min.X, b.Min.Y+by, b.Min.X+bx, b.Max.Y-by),
+		image.Rect(b.Max.X-bx, b.Min.Y+by, b.Max.X, b.Max.Y-by),
+	}
+
+	// bucket candidates by brightness, keeping per bucket sums so the
+	// brightest pixels can be averaged without storing them all
+	var count [4096]uint64
+	var sum [4096][3]uint64
+	var total uint64
+	for _, band := range bands {
+		scanPixels(m, band, func(r, g, b uint32) {
+			if r > baseClip || g > baseClip || b > baseClip {
+				return
+			}
+			if r+g+b < baseDark*3 {
+				return
+			}
+			k := (r + g + b) / 3 >> 4
+			count[k]++
+			sum[k][0] += uint64(r)
+			sum[k][1] += uint64(g)
+			sum[k][2] += uint64(b)
+			total++
+		})
+	}
+
+	want := total * basePercentile / 100
+	if want == 0 {
+		return nil, false
+	}
+
+	// the least dense pixels of the negative are the brightest
+	var n, r, g, bl uint64
+	for k := len(count) - 1; k >= 0 && n < want; k-- {
+		n += count[k]
+		r += sum[k][0]
+		g += sum[k][1]
+		bl += sum[k][2]
+	}
+	return color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: 0xffff}, true
+}
+
+// Telling positives from color negatives. Color negatives have an orange
+// mask, so the blue of their brightest brightPercent percent of pixels, the
+// clearest film, is less than maskRatio of the red. Images with fewer than
+// colorPercent percent of pixels more saturated than colorChroma have no
+// color to tell from.
+const (
+	maskRatio     = 0.8
+	brightPercent = 1
+	colorChroma   = 0x1000
+	colorPercent  = 1
+)
+
+// LooksPositive reports whether m appears to already be a positive rather
+// than a color negative, as its brightest pixels aren't tinted by an orange
+// film mask, to catch positives about to be inverted again by mistake. It
+// is a heuristic: black and white images, and negatives scanned with the mask
+// already removed, can't be told apart and are reported as negatives.
+func LooksPositive(m image.Image) bool {
+	var count [4096]uint64
+	var sum [4096][3]uint64
+	var total, colored uint64
+	scanPixels(m, m.Bounds(), func(r, g, b uint32) {
+		if max(r, g, b)-min(r, g, b) > colorChroma {
+			colored++
+		}
+		if r > baseClip || g > baseClip || b > baseClip {
+			return
+		}
+		k := (r + g + b) / 3 >> 4
+		count[k]++
+		sum[k][0] += uint64(r)
+		sum[k][1] += uint64(g)
+		sum[k][2] += uint64(b)
+		total++
+	})
+	if total == 0 || colored*100 < total*colorPercent {
+		return false
+	}
+
+	want := total * brightPercent / 100
+	var n, r, bl uint64
+	for k := len(count) - 1; k >= 0 && (n == 0 || n < want); k-- {
+		n += count[k]
+		r += sum[k][0]
+		bl += sum[k][2]
+	}
+	return float64(bl) >= maskRatio*float64(r)
+}
@@ -0,0 +1,146 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package positive
+
+import (
+	"fmt"
+	"image"
+	"sync"
+	"testing"
+)
+
+// benchSizes are representative scan sizes: a preview, a flatbed or camera
+// scan, and a high resolution dedicated film scanner scan.
+var benchSizes = []struct {
+	name string
+	w, h int
+}{
+	{"1MP", 1200, 800},
+	{"6MP", 3000, 2000},
+	{"24MP", 6000, 4000},
+}
+
+// benchImages caches the synthetic negatives benchmarked, which are slow to
+// make at the larger sizes, by scene and size.
+var benchImages sync.Map
+
+// benchImage returns the negative made by f at w by h, made once.
+func benchImage(name string, f func(w, h int) image.Image, w, h int) image.Image {
+	key := fmt.Sprintf("%v/%vx%v", name, w, h)
+	if m, ok := benchImages.Load(key); ok {
+		return m.(image.Image)
+	}
+	m := f(w, h)
+	benchImages.Store(key, m)
+	return m
+}
+
+// benchSized runs f as a sub-benchmark for each of benchSizes, with the
+// negative made by m at that size, reporting throughput in pixels, so MB/s
+// reads as megapixels per second. Sizes above 1MP are skipped with -short.
+func benchSized(b *testing.B, name string, m func(w, h int) image.Image, f func(b *testing.B, m image.Image)) {
+	for _, s := range benchSizes {
+		b.Run(s.name, func(b *testing.B) {
+			if testing.Short() && s.w*s.h > 1e6 {
+				b.Skip("large image in short mode")
+			}
+			img := benchImage(name, m, s.w, s.h)
+			b.SetBytes(int64(s.w * s.h))
+			b.ReportAllocs()
+			b.ResetTimer()
+			f(b, img)
+		})
+	}
+}
+
+// BenchmarkStage converts the synthetic negatives with each of the stages
+// of goldenCases enabled, the difference from invert, the default
+// conversion, being the cost of the stage.
+func BenchmarkStage(b *testing.B) {
+	for _, c := range goldenCases {
+		o := goldenOptions(c.o)
+		b.Run(c.name, func(b *testing.B) {
+			benchSized(b, c.name, c.m, func(b *testing.B, m image.Image) {
+				for i := 0; i < b.N; i++ {
+					if _, err := Process(m, o); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkFindLevels(b *testing.B) {
+	o := goldenOptions(func(*Options) {})
+	benchSized(b, "color", colorNegative, func(b *testing.B, m image.Image) {
+		for i := 0; i < b.N; i++ {
+			if _, err := FindLevels(m, o); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkEstimateBase(b *testing.B) {
+	benchSized(b, "color", colorNegative, func(b *testing.B, m image.Image) {
+		for i := 0; i < b.N; i++ {
+			EstimateBase(m)
+		}
+	})
+}
+
+func BenchmarkDespeckle(b *testing.B) {
+	benchSized(b, "color", colorNegative, func(b *testing.B, m image.Image) {
+		for i := 0; i < b.N; i++ {
+			Despeckle(m, 0.2, DefaultDustRadius, 0)
+		}
+	})
+}
+
+func BenchmarkDenoise(b *testing.B) {
+	benchSized(b, "color", colorNegative, func(b *testing.B, m image.Image) {
+		for i := 0; i < b.N; i++ {
+			Denoise(m.(*image.RGBA64), 0.5, 0.5, 0)
+		}
+	})
+}
+
+func BenchmarkLocalContrast(b *testing.B) {
+	benchSized(b, "color", colorNegative, func(b *testing.B, m image.Image) {
+		for i := 0; i < b.N; i++ {
+			LocalContrast(m.(*image.RGBA64), 1, DefaultLocalContrastClip, DefaultLocalContrastTiles, 0)
+		}
+	})
+}
+
+func BenchmarkSharpen(b *testing.B) {
+	benchSized(b, "color", colorNegative, func(b *testing.B, m image.Image) {
+		for i := 0; i < b.N; i++ {
+			Sharpen(m.(*image.RGBA64), 1, DefaultSharpenRadius, 0, 0)
+		}
+	})
+}
+
+func BenchmarkReverse(b *testing.B) {
+	o := goldenOptions(func(*Options) {})
+	benchSized(b, "color", colorNegative, func(b *testing.B, m image.Image) {
+		for i := 0; i < b.N; i++ {
+			if _, err := Reverse(m, o); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkResize(b *testing.B) {
+	benchSized(b, "color", colorNegative, func(b *testing.B, m image.Image) {
+		s := m.Bounds().Size()
+		for i := 0; i < b.N; i++ {
+			Resize(m, s.X/4, s.Y/4, 0)
+		}
+	})
+}
@@ -0,0 +1,159 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/djfritz/positive"
+)
+
+// fraction of pixels the suggested thresholds allow beyond the black and
+// white points, enough to ignore dust and small specular highlights
+const suggestedClip = 0.0005
+
+// An analysis describes a negative, for choosing conversion parameters.
+// Channel values are in r,g,b order.
+type analysis struct {
+	File   string `json:"file"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+
+	// estimated film mask color, if a film border was found
+	Base *baseFile `json:"base"`
+
+	// densities of the clearest and densest parts of the negative
+	DMin [3]float64 `json:"dmin"`
+	DMax [3]float64 `json:"dmax"`
+
+	// statistics of the 16-bit scan values
+	Mean   [3]float64 `json:"mean"`
+	Median [3]int     `json:"median"`
+	P1     [3]int     `json:"p1"`
+	P99    [3]int     `json:"p99"`
+
+	// suggested -tupper and -tlower, and the levels they result in
+	TUpper int             `json:"tupper"`
+	TLower int             `json:"tlower"`
+	Levels positive.Levels `json:"levels"`
+}
+
+// analyzeCmd prints an analysis of each input negative as JSON, without
+// converting them.
+func analyzeCmd(args []string) {
+	fs := flag.NewFlagSet("analyze", flag.ExitOnError)
+	fGamma := fs.String("gamma", "none", "Gamma profile used to find suggested levels")
+	fBorder := fs.Int("border", 10, "Percentage border to ignore when finding suggested levels")
+	fProfiles := fs.String("profile-file", "", "Load additional gamma profiles from the given JSON file")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: positive analyze [flags] <input>...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if err := loadProfiles(*fProfiles); err != nil {
+		fatal(inputError(err))
+	}
+	p, err := profile(*fGamma)
+	if err != nil {
+		fatal(usageError(err))
+	}
+
+	o := positive.DefaultOptions()
+	o.Gamma = p.Gamma
+	o.Border = *fBorder
+
+	for _, input := range fs.Args() {
+		a, err := analyze(input, o)
+		if err != nil {
+			fatal(inputError(err))
+		}
+
+		data, err := json.MarshalIndent(a, "", "\t")
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Println(string(data))
+	}
+}
+
+// analyze the given input, finding suggested levels with o
+func analyze(input string, o positive.Options) (*analysis, error) {
+	m, err := decode(input)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &analysis{
+		File:   filepath.Base(input),
+		Width:  m.Bounds().Dx(),
+		Height: m.Bounds().Dy(),
+	}
+
+	if base, ok := positive.EstimateBase(m); ok {
+		r, g, b, _ := base.RGBA()
+		a.Base = &baseFile{R: uint16(r), G: uint16(g), B: uint16(b)}
+		o.Base = base
+	}
+
+	h := positive.Histogram(m, 0x10000)
+	pixels := a.Width * a.Height
+	for c := range h {
+		var sum float64
+		for v, n := range h[c] {
+			sum += float64(v) * float64(n)
+		}
+		a.Mean[c] = sum / float64(pixels)
+		a.Median[c] = percentile(h[c], pixels, 0.5)
+		a.P1[c] = percentile(h[c], pixels, 0.01)
+		a.P99[c] = percentile(h[c], pixels, 0.99)
+
+		// the clearest film transmits the most light
+		a.DMin[c] = round(density(float64(percentile(h[c], pixels, 1-suggestedClip))))
+		a.DMax[c] = round(density(float64(percentile(h[c], pixels, suggestedClip))))
+	}
+
+	interior := a.Width * a.Height * (100 - 2*o.Border) * (100 - 2*o.Border) / 10000
+	a.TUpper = int(float64(interior) * suggestedClip)
+	a.TLower = a.TUpper
+	o.Upper = a.TUpper
+	o.Lower = a.TLower
+
+	a.Levels, err = positive.FindLevels(m, o)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// percentile returns the value below which fraction p of the pixels in
+// histogram h fall.
+func percentile(h []int, pixels int, p float64) int {
+	target := int(float64(pixels) * p)
+	var sum int
+	for v, n := range h {
+		sum += n
+		if sum > target {
+			return v
+		}
+	}
+	return len(h) - 1
+}
+
+// round a density to hundredths
+func round(d float64) float64 {
+	return math.Round(d*100) / 100
+}
@@ -0,0 +1,210 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/djfritz/positive"
+)
+
+// Approximate memory per pixel while converting a single image: the decoded
+// 16-bit input and the output, 8 bytes each, and two float32 r,g,b copies, 12
+// bytes each, as the stages looking at neighboring pixels read one and write
+// the other.
+const bytesPerPixel = 2*8 + 2*12
+
+// A budget limits the total estimated memory of in flight conversions. A
+// single job larger than the whole budget is still allowed to run alone.
+type budget struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	limit int64
+	used  int64
+}
+
+func newBudget(limit int64) *budget {
+	b := &budget{limit: limit}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+func (b *budget) acquire(n int64) {
+	if b.limit <= 0 {
+		return
+	}
+	b.mu.Lock()
+	for b.used != 0 && b.used+n > b.limit {
+		b.cond.Wait()
+	}
+	b.used += n
+	b.mu.Unlock()
+}
+
+func (b *budget) release(n int64) {
+	if b.limit <= 0 {
+		return
+	}
+	b.mu.Lock()
+	b.used -= n
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+// estimate the memory required to convert the given image file
+func estimate(input string) (int64, error) {
+	f, err := os.Open(input)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	if rawExt[strings.ToLower(filepath.Ext(input))] {
+		// raw files are read whole and unpacked before demosaicing
+		fi, err := f.Stat()
+		if err != nil {
+			return 0, err
+		}
+		return fi.Size()*4 + fi.Size()*bytesPerPixel, nil
+	}
+
+	c, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", positive.ErrDecode, err)
+	}
+	h := int64(c.Height)
+	if *fTiled && h > positive.DefaultTileRows {
+		h = positive.DefaultTileRows
+	}
+	return int64(c.Width) * h * bytesPerPixel, nil
+}
+
+// batch converts each of inputs to its outputPath using a pool of workers,
+// returning the number of failed conversions. With -state, inputs converted
+// by an earlier run are skipped, as are those that failed unless
+// -retry-failed is set. A configuration error, which would fail every input,
+// stops the batch and exits.
+func batch(inputs []string, o positive.Options, workers int, mem int64) int {
+	if workers < 1 {
+		workers = 1
+	}
+
+	var failed int
+	var todo []string
+	for _, input := range inputs {
+		r, ok := state.lookup(input)
+		switch {
+		case !ok:
+		case r.Status == statusDone:
+			debugf("%v: converted by an earlier run", input)
+			continue
+		case r.Status == statusFailed && !*fRetryFailed:
+			warnf("%v: failed in an earlier run: %v", input, r.Error)
+			failed++
+			continue
+		case r.Status == statusStarted:
+			// interrupted while converting, so the output is incomplete
+			if err := os.Remove(r.Output); err == nil {
+				infof("%v: removed incomplete output %v", input, r.Output)
+			}
+		}
+		todo = append(todo, input)
+	}
+
+	b := newBudget(mem)
+	jobs := make(chan string)
+
+	if showProgress() {
+		bar = newProgress(float64(len(todo)), "files")
+		defer func() {
+			bar.finish()
+			bar = nil
+		}()
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+
+	// a configuration error would fail every input, so the batch stops at
+	// the first, leaving the rest to be converted once it is fixed
+	var cfgErr error
+	stop := make(chan struct{})
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for input := range jobs {
+				output := outputPath(input)
+				if err := state.set(input, result{Status: statusStarted, Output: key(output)}); err != nil {
+					errorf("%v: %v", *fState, err)
+				}
+
+				n, err := estimate(input)
+				if err == nil && *fOut != "" {
+					err = os.MkdirAll(filepath.Dir(output), 0755)
+				}
+				if err == nil {
+					b.acquire(n)
+					err = convert(input, output, o)
+					b.release(n)
+				}
+				bar.advance(input, 1)
+
+				// every input started is marked done or failed, so
+				// the next run doesn't take its output for one left
+				// incomplete by an interruption and remove it
+				r := result{Status: statusDone, Output: key(output)}
+				if err != nil {
+					r = result{Status: statusFailed, Output: key(output), Error: err.Error()}
+				}
+				if err := state.set(input, r); err != nil {
+					errorf("%v: %v", *fState, err)
+				}
+
+				if err != nil && exitCode(err) == exitUsage {
+					mu.Lock()
+					if cfgErr == nil {
+						cfgErr = fmt.Errorf("%v: %w", input, err)
+						close(stop)
+					}
+					mu.Unlock()
+					continue
+				}
+				if err != nil {
+					errorf("%v: %v", input, err)
+					mu.Lock()
+					failed++
+					mu.Unlock()
+					continue
+				}
+				infof("%v -> %v", input, output)
+			}
+		}()
+	}
+
+dispatch:
+	for _, input := range todo {
+		select {
+		case jobs <- input:
+		case <-stop:
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if cfgErr != nil {
+		fatal(cfgErr)
+	}
+
+	return failed
+}
@@ -0,0 +1,181 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/djfritz/positive"
+)
+
+// calibrateCmd derives a gamma profile from a scan of a transmission step wedge
+// photographed on the target film, and writes it as JSON to stdout.
+func calibrateCmd(args []string) {
+	fs := flag.NewFlagSet("calibrate", flag.ExitOnError)
+	fSteps := fs.Int("steps", 21, "Number of steps in the wedge")
+	fDmin := fs.Float64("dmin", 0.05, "Density of the wedge's first (clearest) step")
+	fDstep := fs.Float64("dstep", 0.15, "Density increment between wedge steps")
+	fName := fs.String("name", "", "Profile name, defaults to the input file name")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: positive calibrate [flags] <wedge scan>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	input := fs.Arg(0)
+
+	m, err := decode(input)
+	if err != nil {
+		fatal(inputError(err))
+	}
+
+	patches, err := wedgePatches(m, *fSteps)
+	if err != nil {
+		fatal(err)
+	}
+
+	p := positive.Profile{
+		Name:   *fName,
+		Source: filepath.Base(input),
+		Fit:    &positive.Gamma{},
+		Curves: &positive.Curves{},
+	}
+	if p.Name == "" {
+		p.Name = strings.TrimSuffix(p.Source, filepath.Ext(p.Source))
+	}
+
+	// each step of the wedge holds back more light, so the film received
+	// dstep less log exposure through it than the step before
+	for i, c := range patches {
+		logE := -(*fDmin + float64(i)**fDstep)
+		p.Curves.R = append(p.Curves.R, [2]float64{logE, density(c[0])})
+		p.Curves.G = append(p.Curves.G, [2]float64{logE, density(c[1])})
+		p.Curves.B = append(p.Curves.B, [2]float64{logE, density(c[2])})
+	}
+
+	// log exposure increases along the curves
+	for _, c := range [][][2]float64{p.Curves.R, p.Curves.G, p.Curves.B} {
+		for i, j := 0, len(c)-1; i < j; i, j = i+1, j-1 {
+			c[i], c[j] = c[j], c[i]
+		}
+	}
+
+	p.R, p.Fit.R = regression(p.Curves.R)
+	p.G, p.Fit.G = regression(p.Curves.G)
+	p.B, p.Fit.B = regression(p.Curves.B)
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(p); err != nil {
+		fatal(err)
+	}
+}
+
+// wedgePatches returns the mean r,g,b values of each step of a wedge laid out
+// along the long side of m, ordered from the clearest wedge step (the densest
+// patch on the negative) to the most dense.
+func wedgePatches(m image.Image, steps int) ([][3]float64, error) {
+	if steps < 2 {
+		return nil, errors.New("wedge must have at least two steps")
+	}
+
+	b := m.Bounds()
+	horizontal := b.Dx() >= b.Dy()
+	length := b.Dy()
+	if horizontal {
+		length = b.Dx()
+	}
+	if length < steps*4 {
+		return nil, fmt.Errorf("wedge scan is too small for %v steps", steps)
+	}
+
+	var ret [][3]float64
+	for i := 0; i < steps; i++ {
+		// measure the middle half of each step, away from step edges and
+		// the edges of the wedge
+		lo := b.Min.X + length*i/steps
+		hi := b.Min.X + length*(i+1)/steps
+		r := image.Rect(lo+(hi-lo)/4, b.Min.Y+b.Dy()/4, hi-(hi-lo)/4, b.Max.Y-b.Dy()/4)
+		if !horizontal {
+			lo = b.Min.Y + length*i/steps
+			hi = b.Min.Y + length*(i+1)/steps
+			r = image.Rect(b.Min.X+b.Dx()/4, lo+(hi-lo)/4, b.Max.X-b.Dx()/4, hi-(hi-lo)/4)
+		}
+		ret = append(ret, mean(m, r))
+	}
+
+	// the clearest step of the wedge exposes the film the most, making it
+	// the darkest patch of the scanned negative
+	first := ret[0][0] + ret[0][1] + ret[0][2]
+	last := ret[steps-1][0] + ret[steps-1][1] + ret[steps-1][2]
+	if first > last {
+		for i, j := 0, len(ret)-1; i < j; i, j = i+1, j-1 {
+			ret[i], ret[j] = ret[j], ret[i]
+		}
+	}
+
+	return ret, nil
+}
+
+// mean returns the mean r,g,b values of m within r
+func mean(m image.Image, r image.Rectangle) [3]float64 {
+	var sum [3]float64
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			cr, cg, cb, _ := m.At(x, y).RGBA()
+			sum[0] += float64(cr)
+			sum[1] += float64(cg)
+			sum[2] += float64(cb)
+		}
+	}
+
+	n := float64(r.Dx() * r.Dy())
+	return [3]float64{sum[0] / n, sum[1] / n, sum[2] / n}
+}
+
+// density converts a 16-bit scanned transmission value to optical density
+func density(v float64) float64 {
+	if v < 1 {
+		v = 1
+	}
+	return -math.Log10(v / 0xffff)
+}
+
+// regression returns the slope of the linear fit of points, along with its
+// coefficient of determination (r²).
+func regression(points [][2]float64) (float64, float64) {
+	var meanx, meany float64
+	for _, p := range points {
+		meanx += p[0]
+		meany += p[1]
+	}
+	meanx /= float64(len(points))
+	meany /= float64(len(points))
+
+	var n, d, t float64
+	for _, p := range points {
+		n += (p[0] - meanx) * (p[1] - meany)
+		d += (p[0] - meanx) * (p[0] - meanx)
+		t += (p[1] - meany) * (p[1] - meany)
+	}
+
+	if t == 0 {
+		return n / d, 1
+	}
+	return n / d, (n * n) / (d * t)
+}
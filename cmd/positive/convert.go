@@ -0,0 +1,1086 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/djfritz/positive"
+)
+
+var (
+	convertFlags = flag.NewFlagSet("convert", flag.ExitOnError)
+
+	fManifest           = convertFlags.String("manifest", "", "CSV or JSON file of per input overrides of gamma, base-color, rotate, and crop")
+	fSaveRecipe         = convertFlags.Bool("save-recipe", false, "Write the settings and computed values of each conversion to a .positive.json sidecar next to the output")
+	fFromRecipe         = convertFlags.String("from-recipe", "", "Repeat the conversion recorded in the given .positive.json sidecar")
+	fPreset             = convertFlags.String("preset", "", "Use the flag values of the named preset from the config file, unless given on the command line")
+	fConfig             = convertFlags.String("config", "", "Config file, in TOML or JSON, to read presets from, instead of config.toml or config.json in the user config directory")
+	fInvert             = convertFlags.Bool("invert", true, "Invert the image before setting levels")
+	fGamma              = convertFlags.String("gamma", "", "Apply the given gamma profile, or a blend given as name:weight,name:weight")
+	fNormalize          = convertFlags.Bool("normalize", true, "Normalize the image by channel")
+	fBorder             = convertFlags.String("border", "10", "Percentage border to ignore when calculating normalization, or top,right,bottom,left percentages")
+	fROI                = convertFlags.String("roi", "", "Calculate normalization from the rectangle x0,y0,x1,y1 only")
+	fBase               = convertFlags.String("base", "", "Path to mask film sample for mask correction, or a JSON file written by -save-base")
+	fSaveBase           = convertFlags.String("save-base", "", "Write the color sampled from -base to the given JSON file for reuse")
+	fBaseRect           = convertFlags.String("base-rect", "", "Sample the film mask from the rectangle x0,y0,x1,y1 of each input image")
+	fBaseColor          = convertFlags.String("base-color", "", "Film mask color as 16-bit R,G,B values, as printed by the sample command")
+	fAutoBase           = convertFlags.Bool("auto-base", true, "Estimate the film mask from the frame border when no -base sample is given")
+	fDark               = convertFlags.String("dark", "", "Subtract the given dark frame, captured with the lens capped, from camera scanned inputs")
+	fStack              = convertFlags.String("stack", "", "Comma separated additional scans of the input frame to combine with it, reducing scanner noise")
+	fStackMode          = convertFlags.String("stack-mode", "mean", "How -stack scans are combined: mean, or median to also reject differences in a single scan")
+	fHDR                = convertFlags.String("hdr", "", "Comma separated bracketed captures of the input frame to merge with it, recovering dense highlights")
+	fDeskew             = convertFlags.Bool("deskew", false, "Detect and straighten the small skew of frames in a film holder")
+	fSplit              = convertFlags.Bool("split", false, "Detect the frames of a scanned film strip and convert each to its own numbered output")
+	fFlat               = convertFlags.String("flat", "", "Correct uneven illumination by dividing inputs by the given capture of the empty light source")
+	fIR                 = convertFlags.Bool("ir", true, "Remove dust and scratches using the infrared channel of RGBI scans")
+	fIRThreshold        = convertFlags.Float64("ir-threshold", positive.DefaultIRThreshold, "Fraction of the typical infrared transmission below which pixels are treated as dust")
+	fDust               = convertFlags.Float64("dust", 0, "Remove dust and scratches darker than their surroundings by this fraction, such as 0.2, in scans without an infrared channel")
+	fDustRadius         = convertFlags.Int("dust-radius", positive.DefaultDustRadius, "Radius in pixels of the surroundings -dust compares to, larger than the biggest specks")
+	fSlide              = convertFlags.Bool("slide", false, "Correct slide (E-6) film, skipping film mask removal and inversion")
+	fReverse            = convertFlags.Bool("reverse", false, "Convert a positive to a negative, undoing the conversion with the given gamma profile, -levels, and film mask")
+	fIfPositive         = convertFlags.String("if-positive", "warn", "What to do with inputs that look like they are positives already: warn, skip, or convert without checking")
+	fBW                 = convertFlags.Bool("bw", false, "Convert black and white film as a single channel, writing grayscale output")
+	fToning             = convertFlags.String("toning", "", "Tone black and white output: sepia or selenium")
+	fECN2               = convertFlags.Bool("ecn2", false, "Convert ECN-2 motion picture film, removing its dense film mask by division")
+	fLight              = convertFlags.String("light", "", "Light the film was exposed under, daylight or tungsten, if it differs from the film's balance")
+	fFilmLight          = convertFlags.String("film-light", "", "Light the film is balanced for, daylight or tungsten, overriding the gamma profile")
+	fCurves             = convertFlags.String("curves", "", "Correct for the characteristic curves in the given JSON or CSV file instead of the gamma profile")
+	fPush               = convertFlags.Float64("push", 0, "Stops the film was pushed in development, or pulled if negative")
+	fMode               = convertFlags.String("mode", "linear", "Conversion pipeline: linear, or density to invert in log density space like an optical print")
+	fMask               = convertFlags.String("mask", "add", "Film mask removal mode: add the inverted mask color, or divide by the mask color")
+	fUpper              = convertFlags.Int("tupper", 10, "Pixel count upper threshold for normalization")
+	fLower              = convertFlags.Int("tlower", 10, "Pixel count lower threshold for normalization")
+	fExclude            = convertFlags.String("exclude", "", "Ignore the white areas of the given mask image when calculating normalization")
+	fRolloff            = convertFlags.Float64("rolloff", 0, "Fraction of the range at black and white to compress clipped values into, 0 to clip")
+	fLinked             = convertFlags.Bool("linked", false, "Normalize all channels with the same levels, preserving color balance")
+	fRoll               = convertFlags.Bool("roll", false, "Normalize every input with the same levels, found across all of them")
+	fReference          = convertFlags.String("reference", "", "Normalize every input with the levels of the given reference frame")
+	fLevels             = convertFlags.String("levels", "", "Normalize every input with the levels in the given JSON file, as written by -save-levels")
+	fSaveLevels         = convertFlags.Bool("save-levels", false, "Write the normalization levels used for each output to a .levels.json sidecar")
+	fGray               = convertFlags.Bool("gray", false, "Output 16-bit grayscale")
+	fAlpha              = convertFlags.Bool("alpha", false, "Keep the alpha channel of the input, such as a mask of invalid regions, in PNG and TIFF output")
+	fTiled              = convertFlags.Bool("tiled", false, "Convert uncompressed TIFF scans a stripe of rows at a time with bounded memory, for scans too large to decode at once")
+	fOutdir             = convertFlags.String("outdir", "", "Convert all input files into the given directory")
+	fOut                = convertFlags.String("out", "", "Convert each input file to the given path template, such as {dir}/{name}_positive.tif, where {dir}, {name}, and {ext} are those of the input")
+	fForce              = convertFlags.Bool("force", false, "Overwrite existing output files")
+	fWatch              = convertFlags.String("watch", "", "Convert new scans written to the given directory, with -outdir or -out, until interrupted")
+	fWatchInterval      = convertFlags.Duration("watch-interval", 2*time.Second, "How often -watch checks for new scans")
+	fState              = convertFlags.String("state", "", "Record the outcome of each input of a batch in the given file, skipping inputs already converted when resumed")
+	fRetryFailed        = convertFlags.Bool("retry-failed", false, "With -state, convert inputs that failed in an earlier run again")
+	fWorkers            = convertFlags.Int("workers", runtime.GOMAXPROCS(0), "Number of files to convert concurrently with -outdir or -out")
+	fMem                = convertFlags.Int64("mem", 0, "Approximate memory budget in MB for concurrent conversions, 0 for unlimited")
+	fFormat             = convertFlags.String("format", "", "Output format, tiff, png, or jpeg. Inferred from the output file name if not set")
+	fProof              = convertFlags.Bool("proof", false, "Also write an 8-bit JPEG proof next to the output")
+	fThumbs             = convertFlags.String("thumbs", "", "Also write a small JPEG thumbnail of each output to the given directory")
+	fQuality            = convertFlags.Int("quality", 90, "JPEG quality, 1-100")
+	fDepth              = convertFlags.Int("depth", 16, "Bits per channel of TIFF and PNG output, 8 or 16. 8-bit output is rounded, or dithered with -dither")
+	fDither             = convertFlags.Bool("dither", false, "Dither 8-bit output, such as JPEG, -depth 8, proofs, and thumbnails, so smooth gradients don't band")
+	fColorSpace         = convertFlags.String("colorspace", "", "Encode the output in a color space and tag TIFF output with its ICC profile: srgb, adobergb, prophoto, or linear")
+	fICC                = convertFlags.String("icc", "", "Embed an ICC profile in TIFF output: srgb, adobergb, prophoto, linear, or a profile file")
+	fProfiles           = convertFlags.String("profile-file", "", "Load additional gamma profiles from the given JSON file")
+	fMatrix             = convertFlags.String("matrix", "", "Apply the color correction matrix in the given file, as written by the target command, or given as nine comma separated values")
+	fClipping           = convertFlags.String("clipping", "", "Report the pixels clipped to black and white in each output, as text or json")
+	fStats              = convertFlags.String("stats", "", "Report the levels, film base, and clipping found converting each input, as text or json")
+	fHistogram          = convertFlags.String("histogram", "", "Write input and output histograms to the given PNG file, or print them if \"text\"")
+	fNeutral            = convertFlags.String("neutral", "", "Make the pixel x,y, or the rectangle x0,y0,x1,y1, neutral after conversion, such as a gray card")
+	fAWB                = convertFlags.String("awb", "", "Automatic white balance after conversion: grayworld, or highlights to make the brightest areas neutral")
+	fTemp               = convertFlags.Float64("temp", 0, "Color temperature shift from -100 (cooler) to 100 (warmer)")
+	fTint               = convertFlags.Float64("tint", 0, "Tint shift from -100 (greener) to 100 (more magenta)")
+	fEV                 = convertFlags.Float64("ev", 0, "Exposure compensation in stops applied after conversion")
+	fDenoise            = convertFlags.Float64("denoise", 0, "Luminance grain reduction strength from 0 (off) to 1")
+	fDenoiseChroma      = convertFlags.Float64("denoise-chroma", 0, "Color noise reduction strength from 0 (off) to 1")
+	fLocalContrast      = convertFlags.Float64("local-contrast", 0, "Local contrast enhancement from 0 (off) to 1, for flat expired or underdeveloped negatives")
+	fLocalContrastClip  = convertFlags.Float64("local-contrast-clip", positive.DefaultLocalContrastClip, "Local contrast clip limit, at least 1, higher allowing more contrast")
+	fLocalContrastTiles = convertFlags.Int("local-contrast-tiles", positive.DefaultLocalContrastTiles, "Number of local contrast tiles along the longest side")
+	fSharpen            = convertFlags.Float64("sharpen", 0, "Unsharp mask amount applied to the output, such as 0.5, 0 for none")
+	fSharpenRadius      = convertFlags.Float64("sharpen-radius", positive.DefaultSharpenRadius, "Unsharp mask radius in pixels")
+	fSharpenThreshold   = convertFlags.Float64("sharpen-threshold", 0, "Smallest difference sharpened, as a fraction of white, to avoid sharpening grain")
+	fHighlights         = convertFlags.Float64("highlights", 0, "Highlight recovery from 0 (off) to 1, compressing the brightest tones to bring out detail such as in dense skies")
+	fShadows            = convertFlags.Float64("shadows", 0, "Shadow recovery from 0 (off) to 1, lifting the darkest tones to bring out detail")
+	fMidtone            = convertFlags.Float64("midtone", 1, "Midtone gamma applied after conversion, > 1 to brighten or < 1 to darken")
+	fTone               = convertFlags.String("tone", "linear", "Tone curve applied after conversion: linear, soft, or punchy")
+	fContrast           = convertFlags.Float64("contrast", 0, "Tone curve contrast from -1 (flatter) to 1 (punchier)")
+	fLook               = convertFlags.String("look", "", "Emulate the color rendering of a lab scanner: noritsu, frontier, or pakon")
+	fCrop               = convertFlags.String("crop", "", "Crop the output to the rectangle x0,y0,x1,y1 of the input")
+	fResize             = convertFlags.String("resize", "", "Resize the output to fit within WxH pixels, keeping its aspect ratio. Either may be 0")
+	fRotate             = convertFlags.Int("rotate", 0, "Rotate the output clockwise by 90, 180, or 270 degrees")
+	fFlipH              = convertFlags.Bool("flip-h", false, "Mirror the output left to right, such as for negatives scanned emulsion side down")
+	fFlipV              = convertFlags.Bool("flip-v", false, "Mirror the output top to bottom")
+	fHooks              = hookVar(convertFlags, "hook", "Run a command at a pipeline stage, given as `stage=command` where stage is post-decode, pre-invert, or pre-encode. May be repeated")
+	fThreads            = convertFlags.Int("threads", 0, "Maximum number of threads used to process each image, 0 for all cores")
+	fVerbose            = convertFlags.Bool("v", false, "Log details of each conversion")
+	fQuiet              = convertFlags.Bool("quiet", false, "Only log warnings and errors")
+	fJSONLog            = convertFlags.Bool("json-log", false, "Log JSON lines to stderr, for scripts and pipelines")
+	fProgress           = convertFlags.Bool("progress", true, "Show progress and the estimated time remaining when stderr is a terminal")
+	fCPUProfile         = convertFlags.String("cpuprofile", "", "Write a CPU profile of the conversion to the given file, for go tool pprof")
+	fMemProfile         = convertFlags.String("memprofile", "", "Write a memory profile to the given file once converting is done, for go tool pprof")
+)
+
+// convertCmd converts negatives to positives.
+func convertCmd(args []string) {
+	convertFlags.Usage = func() {
+		fmt.Fprintln(convertFlags.Output(), "usage: positive convert [flags] <input> <output>")
+		fmt.Fprintln(convertFlags.Output(), "       positive convert [flags] - -")
+		fmt.Fprintln(convertFlags.Output(), "       positive convert [flags] -outdir <dir> <input>...")
+		fmt.Fprintln(convertFlags.Output(), "       positive convert [flags] -out <template> <input>...")
+		fmt.Fprintln(convertFlags.Output(), "       positive convert [flags] -watch <dir> -outdir <dir>")
+		convertFlags.PrintDefaults()
+	}
+	convertFlags.Parse(args)
+
+	if *fPreset != "" {
+		if err := applyPreset(convertFlags, *fPreset); err != nil {
+			fatal(usageError(err))
+		}
+	}
+
+	if *fFromRecipe != "" {
+		if err := loadRecipe(convertFlags); err != nil {
+			fatal(inputError(err))
+		}
+	}
+
+	if err := setupLog(); err != nil {
+		fatal(err)
+	}
+
+	if err := startProfiles(); err != nil {
+		fatal(outputError(err))
+	}
+	defer stopProfiles()
+	checkArgs()
+
+	if err := loadProfiles(*fProfiles); err != nil {
+		fatal(inputError(err))
+	}
+
+	if *fGamma == "" {
+		fatalf("must specify gamma profile. Options are: %v", strings.Join(profileNames(), ", "))
+	}
+	p, err := profile(*fGamma)
+	if err != nil {
+		fatal(usageError(err))
+	}
+
+	if err := loadICC(); err != nil {
+		fatal(inputError(err))
+	}
+
+	if err := loadFrames(); err != nil {
+		fatal(inputError(err))
+	}
+
+	if err := loadManifest(); err != nil {
+		fatal(inputError(err))
+	}
+
+	o := positive.Options{
+		Dust:               *fDust,
+		DustRadius:         *fDustRadius,
+		Gamma:              p.Pushed(*fPush),
+		Normalize:          *fNormalize,
+		Upper:              *fUpper,
+		Lower:              *fLower,
+		Linked:             *fLinked,
+		Rolloff:            *fRolloff,
+		Balance:            positive.Balance(*fAWB),
+		Temp:               *fTemp,
+		Tint:               *fTint,
+		EV:                 *fEV,
+		Highlights:         *fHighlights,
+		Shadows:            *fShadows,
+		Midtone:            *fMidtone,
+		Tone:               positive.Tone(*fTone),
+		BW:                 *fBW,
+		Toning:             positive.Toning(*fToning),
+		FilmLight:          p.Light,
+		Light:              positive.Illuminant(*fLight),
+		Contrast:           *fContrast,
+		Look:               positive.Look(*fLook),
+		ColorSpace:         positive.ColorSpace(*fColorSpace),
+		Invert:             *fInvert,
+		Alpha:              *fAlpha,
+		Denoise:            *fDenoise,
+		DenoiseChroma:      *fDenoiseChroma,
+		LocalContrast:      *fLocalContrast,
+		LocalContrastClip:  *fLocalContrastClip,
+		LocalContrastTiles: *fLocalContrastTiles,
+		Sharpen:            *fSharpen,
+		SharpenRadius:      *fSharpenRadius,
+		SharpenThreshold:   *fSharpenThreshold,
+		Threads:            *fThreads,
+	}
+
+	if *fResize != "" {
+		if _, _, err := parseSize(*fResize); err != nil {
+			fatal(usageError(err))
+		}
+	}
+
+	if *fThumbs != "" {
+		if err := os.MkdirAll(*fThumbs, 0755); err != nil {
+			fatal(outputError(err))
+		}
+	}
+
+	if *fRotate%90 != 0 {
+		fatalf("invalid -rotate %v, must be a multiple of 90", *fRotate)
+	}
+	if *fDepth != 8 && *fDepth != 16 {
+		fatalf("invalid -depth %v, must be 8 or 16", *fDepth)
+	}
+
+	if *fClipping != "" && *fClipping != "text" && *fClipping != "json" {
+		fatalf("invalid -clipping %q, must be text or json", *fClipping)
+	}
+	if *fStats != "" && *fStats != "text" && *fStats != "json" {
+		fatalf("invalid -stats %q, must be text or json", *fStats)
+	}
+	if *fColorSpace != "" && *fICC != "" {
+		fatalf("-colorspace tags the output with its own profile and can't be used with -icc")
+	}
+	if *fIfPositive != "warn" && *fIfPositive != "skip" && *fIfPositive != "convert" {
+		fatalf("invalid -if-positive %q, must be warn, skip, or convert", *fIfPositive)
+	}
+	if *fTiled {
+		checkTiled()
+	}
+	checkStdio()
+	if *fAlpha {
+		checkAlpha()
+	}
+	if *fReverse {
+		checkReverse()
+	}
+
+	if err := parseBorder(*fBorder, &o); err != nil {
+		fatal(usageError(err))
+	}
+	if *fROI != "" {
+		r, err := parseRect(*fROI)
+		if err != nil {
+			fatal(usageError(err))
+		}
+		o.ROI = r
+	}
+
+	if *fExclude != "" {
+		x, err := decode(*fExclude)
+		if err != nil {
+			fatal(inputError(err))
+		}
+		o.Exclude = x
+	}
+
+	if *fNeutral != "" {
+		if *fAWB != "" {
+			fatalf("-neutral and -awb are mutually exclusive")
+		}
+		r, err := parseNeutral(*fNeutral)
+		if err != nil {
+			fatal(usageError(err))
+		}
+		o.Neutral = r
+	}
+
+	if *fFilmLight != "" {
+		o.FilmLight = positive.Illuminant(*fFilmLight)
+	}
+	if o.Light == "" {
+		o.Light = o.FilmLight
+	}
+
+	switch *fMask {
+	case "add":
+	case "divide":
+		o.Divide = true
+	default:
+		fatalf("invalid -mask %q, must be add or divide", *fMask)
+	}
+	if *fECN2 {
+		// the mask of cine film is dense enough that adding its inverse
+		// clips much of the image
+		o.Divide = true
+	}
+
+	switch *fMode {
+	case "linear":
+	case "density":
+		o.Density = true
+	default:
+		fatalf("invalid -mode %q, must be linear or density", *fMode)
+	}
+
+	if *fCurves != "" {
+		c, err := loadCurves(*fCurves)
+		if err != nil {
+			fatal(inputError(err))
+		}
+		o.Curves = c
+	}
+
+	o.Matrix = p.Matrix
+	if *fMatrix != "" {
+		x, err := loadMatrix(*fMatrix)
+		if err != nil {
+			fatal(inputError(err))
+		}
+		o.Matrix = x
+	}
+
+	// remove film mask
+	if countSet(*fBase, *fBaseRect, *fBaseColor) > 1 {
+		fatalf("-base, -base-rect, and -base-color are mutually exclusive")
+	}
+	switch {
+	case *fSlide:
+		if countSet(*fBase, *fBaseRect, *fBaseColor) != 0 {
+			fatalf("slide film has no film mask to remove")
+		}
+		o.Slide = true
+	case *fBaseColor != "":
+		c, err := parseColor(*fBaseColor)
+		if err != nil {
+			fatal(usageError(err))
+		}
+		o.Base = c
+	case *fBase != "":
+		s, err := sample(*fBase)
+		if err != nil {
+			fatal(inputError(err))
+		}
+		o.Base = s
+
+		if *fSaveBase != "" {
+			if err := saveBase(*fSaveBase, s, *fBase); err != nil {
+				fatal(outputError(err))
+			}
+		}
+	case *fReverse:
+		infof("no -base or -base-color, the negative won't have a film mask")
+	case *fBaseRect != "":
+		// sampled from each image when converting
+	case *fAutoBase && *fTiled:
+		warnf("-tiled can't estimate the film mask, use -base or -base-color; not removing film mask!")
+	case *fAutoBase:
+		infof("no film mask sample, estimating it from the frame border")
+	default:
+		warnf("not removing film mask!")
+	}
+
+	// share levels across the roll
+	var roll string
+	if *fRoll {
+		roll = "roll"
+	}
+	if countSet(roll, *fReference, *fLevels) > 1 {
+		fatalf("-roll, -reference, and -levels are mutually exclusive")
+	}
+	if *fRoll && *fWatch != "" {
+		fatalf("-roll needs every input up front and can't be used with -watch, use -reference or -levels")
+	}
+	switch {
+	case !*fNormalize:
+	case *fLevels != "":
+		l, err := loadLevels(*fLevels)
+		if err != nil {
+			fatal(inputError(err))
+		}
+		o.Levels = &l
+	case *fRoll || *fReference != "":
+		inputs := convertFlags.Args()
+		if !batchMode() && len(inputs) > 1 {
+			inputs = inputs[:1]
+		}
+		if *fReference != "" {
+			inputs = []string{*fReference}
+		}
+
+		l, err := rollLevels(inputs, o)
+		if err != nil {
+			fatal(inputError(err))
+		}
+		o.Levels = &l
+	}
+
+	if fromRecipe != nil && fromRecipe.Levels != nil {
+		o.Levels = fromRecipe.Levels
+	}
+
+	if batchMode() {
+		if *fOutdir != "" && *fOut != "" {
+			fatalf("-outdir and -out are mutually exclusive")
+		}
+		if fromRecipe != nil && (fromRecipe.Skew != nil || fromRecipe.Frame != nil) {
+			fatalf("-from-recipe with a recorded skew or frame repeats a single conversion and can't be used with -outdir or -out")
+		}
+		if *fStack != "" || *fHDR != "" {
+			fatalf("-stack and -hdr combine captures of a single frame and can't be used with -outdir or -out")
+		}
+		if *fState != "" {
+			s, err := loadState(*fState)
+			if err != nil {
+				fatal(inputError(err))
+			}
+			state = s
+		}
+		if *fWatch != "" {
+			if convertFlags.NArg() != 0 {
+				fatalf("-watch converts the scans in the watched directory, not arguments")
+			}
+			if err := watch(*fWatch, o, *fWorkers, *fMem<<20); err != nil {
+				fatal(err)
+			}
+			return
+		}
+		if failed := batch(convertFlags.Args(), o, *fWorkers, *fMem<<20); failed != 0 {
+			fatal(failure{exitPartial, fmt.Errorf("%v of %v conversions failed", failed, convertFlags.NArg())})
+		}
+		return
+	}
+
+	if *fWatch != "" {
+		fatalf("-watch requires -outdir or -out")
+	}
+	if *fState != "" {
+		fatalf("-state requires -outdir or -out")
+	}
+
+	input, output := paths()
+	if showProgress() {
+		bar = newProgress(imageWeight, "")
+	}
+	if err := convert(input, output, o); err != nil {
+		fatal(err)
+	}
+	bar.finish()
+}
+
+// convert a single input file to output
+func convert(input, output string, o positive.Options) error {
+	format, err := outputFormat(output)
+	if err != nil {
+		return usageError(err)
+	}
+	if *fColorSpace != "" && *fColorSpace != "srgb" && format != "tiff" {
+		warnf("%v: only TIFF output is tagged with its color space, %v is usually read as sRGB", output, strings.ToUpper(format))
+	}
+
+	// frame outputs are checked once they are found
+	if !*fSplit || (fromRecipe != nil && fromRecipe.Frame != nil) {
+		if err := checkOutputs(output); err != nil {
+			return err
+		}
+	}
+
+	debugf("%v: converting to %v as %v", input, output, format)
+
+	f := lookup(input)
+	o, err = f.apply(o)
+	if err != nil {
+		return usageError(err)
+	}
+	if *fTiled {
+		return convertTiled(input, output, format, o, f)
+	}
+	if *fAlpha {
+		if format == "jpeg" {
+			return usageError(fmt.Errorf("%v: JPEG can't hold the alpha channel kept by -alpha", output))
+		}
+		if f.crop() != "" || f.rotation() != 0 {
+			return usageError(fmt.Errorf("%v: the manifest crop and rotation can't be used with -alpha", input))
+		}
+	}
+
+	var rec *recipe
+	if *fSaveRecipe {
+		rec = newRecipe(input, output, f)
+	}
+
+	stage("reading "+input, readWeight)
+	m, err := read(input, rec)
+	if err != nil {
+		return inputError(err)
+	}
+	if *fIfPositive != "convert" && !*fReverse && o.Invert && !o.Slide && (convertedInput(input) || positive.LooksPositive(m)) {
+		if *fIfPositive == "skip" {
+			warnf("%v: looks like a positive already, skipping", input)
+			return nil
+		}
+		warnf("%v: looks like a positive already, converting anyway; use -if-positive skip to skip it", input)
+	}
+
+	if fromRecipe != nil && fromRecipe.Frame != nil {
+		r := *fromRecipe.Frame
+		if rec != nil {
+			rec.Frame = &r
+		}
+		return convertImage(positive.Crop(m, r), input, output, format, o, f, rec)
+	}
+
+	if *fSplit {
+		frames := positive.DetectFrames(m)
+		infof("%v: %v frames", input, len(frames))
+		if !batchMode() {
+			bar.grow(float64(len(frames)-1) * (processWeight + writeWeight))
+		}
+		for i, r := range frames {
+			out := framePath(output, i+1)
+			if err := checkOutputs(out); err != nil {
+				return err
+			}
+
+			// each frame has its own recipe
+			var frec *recipe
+			if rec != nil {
+				c := *rec
+				c.Output = out
+				c.Frame = &frames[i]
+				frec = &c
+			}
+			if err := convertImage(positive.Crop(m, r), input, out, format, o, f, frec); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return convertImage(m, input, output, format, o, f, rec)
+}
+
+// orient applies the -flip-h and -flip-v output transforms to m, and then
+// rotates it clockwise by the given degrees.
+func orient(m image.Image, rotate int) (image.Image, error) {
+	if *fFlipH {
+		m = positive.FlipHorizontal(m)
+	}
+	if *fFlipV {
+		m = positive.FlipVertical(m)
+	}
+	if rotate != 0 {
+		return positive.Rotate(m, rotate)
+	}
+	return m, nil
+}
+
+// flags that would drop the alpha channel kept by -alpha
+var alphaConflicts = map[string]bool{
+	"crop":   true,
+	"resize": true,
+	"rotate": true,
+	"flip-h": true,
+	"flip-v": true,
+	"split":  true,
+	"gray":   true,
+	"tiled":  true,
+}
+
+// checkAlpha exits if a flag set with -alpha would drop the alpha channel.
+func checkAlpha() {
+	convertFlags.Visit(func(fl *flag.Flag) {
+		if alphaConflicts[fl.Name] {
+			fatalf("-%v can't be used with -alpha", fl.Name)
+		}
+	})
+}
+
+// paths returns the input and output of a single conversion, from the
+// arguments or the -from-recipe recipe without them.
+func paths() (string, string) {
+	if fromRecipe != nil && convertFlags.NArg() == 0 {
+		return fromRecipe.Input, fromRecipe.Output
+	}
+	return convertFlags.Arg(0), convertFlags.Arg(1)
+}
+
+// checkArgs exits with the usage if the input or output is missing, or with
+// an error if they are the same file or the input isn't a readable image.
+func checkArgs() {
+	if batchMode() {
+		if convertFlags.NArg() == 0 && *fWatch == "" {
+			argsUsage("missing inputs")
+		}
+		return
+	}
+	if *fWatch != "" {
+		return
+	}
+
+	input, output := paths()
+	switch {
+	case input == "":
+		argsUsage("missing input and output")
+	case output == "":
+		argsUsage("missing output")
+	case convertFlags.NArg() > 2:
+		argsUsage("too many arguments, use -outdir or -out to convert more than one input")
+	}
+
+	if input != stdio {
+		if err := checkInput(input); err != nil {
+			fatal(inputError(err))
+		}
+	}
+	if input != stdio && output != stdio && samePath(input, output) {
+		fatalf("%v: input and output are the same file", output)
+	}
+}
+
+// argsUsage logs msg and exits with the usage.
+func argsUsage(msg string) {
+	errorf("%v", msg)
+	convertFlags.Usage()
+	os.Exit(exitUsage)
+}
+
+// samePath reports whether paths a and b name the same file, which must not
+// be overwritten while it is read.
+func samePath(a, b string) bool {
+	if fa, err := os.Stat(a); err == nil {
+		if fb, err := os.Stat(b); err == nil {
+			return os.SameFile(fa, fb)
+		}
+	}
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+// flags that -reverse can't be used with, which find levels or the film mask
+// in the input, or convert several frames or captures
+var reverseConflicts = map[string]bool{
+	"split":       true,
+	"tiled":       true,
+	"alpha":       true,
+	"base-rect":   true,
+	"roll":        true,
+	"reference":   true,
+	"stats":       true,
+	"save-levels": true,
+	"save-recipe": true,
+	"from-recipe": true,
+}
+
+// checkReverse exits if a flag set with -reverse doesn't apply to it.
+func checkReverse() {
+	convertFlags.Visit(func(fl *flag.Flag) {
+		if reverseConflicts[fl.Name] {
+			fatalf("-%v can't be used with -reverse", fl.Name)
+		}
+	})
+	if *fMode == "density" {
+		fatalf("-mode density can't be reversed")
+	}
+}
+
+// framePath returns the path of the nth frame split from output
+func framePath(output string, n int) string {
+	ext := filepath.Ext(output)
+	return fmt.Sprintf("%v-%02d%v", strings.TrimSuffix(output, ext), n, ext)
+}
+
+// convertImage converts m, decoded from input, to output in the given format,
+// cropped and rotated as given by f, completing and saving rec if not nil
+func convertImage(m image.Image, input, output, format string, o positive.Options, f frame, rec *recipe) error {
+	m, err := runHooks("pre-invert", m, input, output)
+	if err != nil {
+		return err
+	}
+
+	if !*fReverse {
+		o, err = sampleBase(m, input, o)
+		if err != nil {
+			return err
+		}
+	}
+
+	if (*fSaveLevels || rec != nil) && o.Normalize && o.Levels == nil {
+		l, err := positive.FindLevels(m, o)
+		if err != nil {
+			return err
+		}
+		o.Levels = &l
+	}
+	if *fSaveLevels && o.Normalize {
+		if err := saveLevels(levelsPath(output), *o.Levels); err != nil {
+			return outputError(err)
+		}
+	}
+
+	if o.Base != nil {
+		r, g, b, _ := o.Base.RGBA()
+		debugf("%v: film mask %v,%v,%v", output, r, g, b)
+	}
+	if o.Levels != nil {
+		l := o.Levels
+		debugf("%v: levels r %v-%v g %v-%v b %v-%v", output, l.RMin, l.RMax, l.GMin, l.GMax, l.BMin, l.BMax)
+	}
+
+	if rec != nil {
+		if o.Base != nil {
+			r, g, b, _ := o.Base.RGBA()
+			rec.Base = fmt.Sprintf("%v,%v,%v", r, g, b)
+		}
+		rec.Levels = o.Levels
+	}
+
+	var hist [3][]int
+	if *fHistogram != "" {
+		hist = positive.Histogram(m, histBins)
+	}
+
+	// grayscale scans are converted as black and white film, as Process
+	// does
+	switch m.(type) {
+	case *image.Gray, *image.Gray16:
+		o.BW = true
+	}
+
+	stage("processing "+output, processWeight)
+	if bar != nil && !batchMode() {
+		o.OnProgress = func(_ string, f float64) {
+			bar.fraction(f)
+		}
+	}
+	if *fStats != "" {
+		var s positive.Stats
+		m, s, err = positive.ProcessStats(context.Background(), m, o)
+		if err == nil {
+			err = reportStats(input, output, s)
+		}
+	} else if *fReverse {
+		m, err = positive.Reverse(m, o)
+	} else {
+		m, err = positive.Process(m, o)
+	}
+	if err != nil {
+		return err
+	}
+
+	if f.crop() != "" {
+		r, err := parseRect(f.crop())
+		if err != nil {
+			return err
+		}
+		if r.Intersect(m.Bounds()).Empty() {
+			return fmt.Errorf("crop %v is outside the image", r)
+		}
+		m = positive.Crop(m, r)
+	}
+
+	m, err = orient(m, f.rotation())
+	if err != nil {
+		return err
+	}
+
+	if *fResize != "" {
+		w, h, err := parseSize(*fResize)
+		if err != nil {
+			return err
+		}
+		w, h = fit(m.Bounds().Dx(), m.Bounds().Dy(), w, h)
+		m = positive.Resize(m, w, h, *fThreads)
+	}
+
+	m, err = runHooks("pre-encode", m, input, output)
+	if err != nil {
+		return err
+	}
+
+	if *fHistogram != "" {
+		if err := writeHistogram(output, hist, positive.Histogram(m, histBins)); err != nil {
+			return outputError(err)
+		}
+	}
+
+	if *fClipping != "" {
+		if err := reportClipping(output, positive.Clipping(m)); err != nil {
+			return err
+		}
+	}
+
+	// output
+	stage("writing "+output, writeWeight)
+	fout, err := createOutput(output)
+	if err != nil {
+		return err
+	}
+
+	defer fout.Close()
+
+	if _, ok := m.(*image.Gray16); !ok && (*fGray || (o.BW && o.Toning == positive.ToningNone)) {
+		b := m.Bounds()
+		g := image.NewGray16(b)
+		for x := b.Min.X; x < b.Max.X; x++ {
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				g.Set(x, y, m.At(x, y))
+			}
+		}
+		m = g
+	}
+
+	if *fDepth == 8 && format != "jpeg" {
+		if *fDither {
+			m = positive.Dither(m)
+		} else {
+			m = positive.Round(m)
+		}
+	}
+
+	if err := encode(fout, m, format, metadata(input, o)); err != nil {
+		return outputError(err)
+	}
+
+	if *fProof {
+		if err := writeProof(output, m); err != nil {
+			return outputError(err)
+		}
+	}
+
+	if *fThumbs != "" {
+		if err := writeThumb(*fThumbs, output, m); err != nil {
+			return outputError(err)
+		}
+	}
+
+	if rec != nil {
+		return outputError(rec.save())
+	}
+	return nil
+}
+
+// load decodes input and completes o with the film mask sampled from it, if
+// the mask is sampled per image.
+func load(input string, o positive.Options) (image.Image, positive.Options, error) {
+	m, err := read(input, nil)
+	if err != nil {
+		return nil, o, err
+	}
+	m, err = runHooks("pre-invert", m, input, "")
+	if err != nil {
+		return nil, o, err
+	}
+	o, err = sampleBase(m, input, o)
+	return m, o, err
+}
+
+// read decodes input, along with any other captures of the same frame, and
+// corrects it with the calibration frames, recording the skew straightened in
+// rec if not nil.
+func read(input string, rec *recipe) (image.Image, error) {
+	m, ir, err := capture(input)
+	if err != nil {
+		return nil, err
+	}
+
+	if *fStack != "" {
+		ms := []image.Image{m}
+		for _, path := range strings.Split(*fStack, ",") {
+			s, _, err := capture(path)
+			if err != nil {
+				return nil, err
+			}
+			ms = append(ms, s)
+		}
+		m, err = positive.Stack(ms, positive.Stacking(*fStackMode), *fThreads)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if *fHDR != "" {
+		ms := []image.Image{m}
+		for _, path := range strings.Split(*fHDR, ",") {
+			c, _, err := capture(path)
+			if err != nil {
+				return nil, err
+			}
+			ms = append(ms, c)
+		}
+		m, err = positive.MergeHDR(ms, *fThreads)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if flatFrame != nil {
+		m, err = positive.FlatField(m, flatFrame, *fThreads)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if ir != nil && *fIR {
+		m, err = positive.RemoveDust(m, ir, *fIRThreshold)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if *fDeskew {
+		var a float64
+		if fromRecipe != nil && fromRecipe.Skew != nil {
+			a = *fromRecipe.Skew
+		} else {
+			a = positive.DetectSkew(m)
+			infof("%v: straightening by %.2f degrees", input, a)
+		}
+		m = positive.Straighten(m, a, *fThreads)
+		if rec != nil {
+			rec.Skew = &a
+		}
+	}
+	return runHooks("post-decode", m, input, "")
+}
+
+// sampleBase completes o with the film mask sampled from m, if the mask is
+// sampled per image.
+func sampleBase(m image.Image, input string, o positive.Options) (positive.Options, error) {
+	if *fBaseRect != "" {
+		r, err := parseRect(*fBaseRect)
+		if err != nil {
+			return o, err
+		}
+		o.Base = positive.SampleRect(m, r)
+	}
+
+	if o.Base == nil && *fAutoBase && !o.Slide {
+		if b, ok := positive.EstimateBase(m); ok {
+			o.Base = b
+		} else {
+			warnf("%v: no film border found, not removing film mask!", input)
+		}
+	}
+	return o, nil
+}
+
+// capture decodes a single capture of a frame, along with its infrared
+// channel if it has one, and subtracts the dark frame from it.
+func capture(path string) (image.Image, *image.Gray16, error) {
+	m, ir, err := decodeIR(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if darkFrame != nil {
+		d, err := positive.SubtractDark(m, darkFrame, *fThreads)
+		if err != nil {
+			return nil, nil, err
+		}
+		return d, ir, nil
+	}
+	return m, ir, nil
+}
+
+// parseBorder sets the normalization border of o from s, either a single
+// percentage or top,right,bottom,left percentages.
+func parseBorder(s string, o *positive.Options) error {
+	var v []int
+	for _, f := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil {
+			return fmt.Errorf("invalid border %q, expected a percentage or top,right,bottom,left", s)
+		}
+		v = append(v, n)
+	}
+
+	switch len(v) {
+	case 1:
+		o.Border = v[0]
+	case 4:
+		o.Margins = &positive.Margins{Top: v[0], Right: v[1], Bottom: v[2], Left: v[3]}
+	default:
+		return fmt.Errorf("invalid border %q, expected a percentage or top,right,bottom,left", s)
+	}
+	return nil
+}
+
+// parseNeutral parses a gray point given as x,y, which is expanded to a small
+// square to average out grain, or a rectangle given as x0,y0,x1,y1.
+func parseNeutral(s string) (image.Rectangle, error) {
+	if strings.Count(s, ",") == 3 {
+		return parseRect(s)
+	}
+
+	var p image.Point
+	if _, err := fmt.Sscanf(s, "%d,%d", &p.X, &p.Y); err != nil {
+		return image.Rectangle{}, fmt.Errorf("invalid gray point %q, expected x,y or x0,y0,x1,y1", s)
+	}
+	return image.Rect(p.X-2, p.Y-2, p.X+3, p.Y+3), nil
+}
+
+// parseRect parses a rectangle given as x0,y0,x1,y1
+func parseRect(s string) (image.Rectangle, error) {
+	var r image.Rectangle
+	if _, err := fmt.Sscanf(s, "%d,%d,%d,%d", &r.Min.X, &r.Min.Y, &r.Max.X, &r.Max.Y); err != nil {
+		return r, fmt.Errorf("invalid rectangle %q, expected x0,y0,x1,y1", s)
+	}
+	r = r.Canon()
+	if r.Empty() {
+		return r, fmt.Errorf("empty rectangle %q", s)
+	}
+	return r, nil
+}
+
+// parseSize parses an image size given as WxH, where either may be 0
+func parseSize(s string) (int, int, error) {
+	ws, hs, ok := strings.Cut(s, "x")
+	w, werr := strconv.Atoi(ws)
+	h, herr := strconv.Atoi(hs)
+	if !ok || werr != nil || herr != nil || w < 0 || h < 0 || w+h == 0 {
+		return 0, 0, fmt.Errorf("invalid size %q, expected WxH", s)
+	}
+	return w, h, nil
+}
+
+// fit returns the size of a w by h image scaled to fit within maxW by maxH,
+// keeping its aspect ratio. A max of 0 leaves that dimension unconstrained.
+func fit(w, h, maxW, maxH int) (int, int) {
+	scale := float64(maxW) / float64(w)
+	if s := float64(maxH) / float64(h); maxW == 0 || (maxH != 0 && s < scale) {
+		scale = s
+	}
+
+	fw, fh := int(math.Round(float64(w)*scale)), int(math.Round(float64(h)*scale))
+	if fw < 1 {
+		fw = 1
+	}
+	if fh < 1 {
+		fh = 1
+	}
+	return fw, fh
+}
+
+// parseColor parses a 16-bit color given as r,g,b
+func parseColor(s string) (color.Color, error) {
+	var r, g, b uint16
+	if _, err := fmt.Sscanf(s, "%d,%d,%d", &r, &g, &b); err != nil {
+		return nil, fmt.Errorf("invalid color %q, expected 16-bit r,g,b values", s)
+	}
+	return color.RGBA64{R: r, G: g, B: b, A: 0xffff}, nil
+}
+
+// countSet returns the number of non-empty flag values
+func countSet(values ...string) int {
+	var n int
+	for _, v := range values {
+		if v != "" {
+			n++
+		}
+	}
+	return n
+}
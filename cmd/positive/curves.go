@@ -0,0 +1,74 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/djfritz/positive"
+)
+
+// loadCurves reads characteristic curves from a JSON file holding r, g, and b
+// lists of [log exposure, density] points, or a CSV file with one log
+// exposure,r,g,b density row per point. Lines starting with # and a header
+// row are ignored in CSV files.
+func loadCurves(path string) (*positive.Curves, error) {
+	if strings.ToLower(filepath.Ext(path)) != ".csv" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		c := new(positive.Curves)
+		if err := json.Unmarshal(data, c); err != nil {
+			return nil, fmt.Errorf("%v: %v", path, err)
+		}
+		return c, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	r.FieldsPerRecord = 4
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+
+	c := new(positive.Curves)
+	for i, rec := range records {
+		var v [4]float64
+		for j, s := range rec {
+			v[j], err = strconv.ParseFloat(strings.TrimSpace(s), 64)
+			if err != nil {
+				break
+			}
+		}
+		if err != nil {
+			if i == 0 {
+				// header
+				continue
+			}
+			return nil, fmt.Errorf("%v: line %v: %v", path, i+1, err)
+		}
+
+		c.R = append(c.R, [2]float64{v[0], v[1]})
+		c.G = append(c.G, [2]float64{v[0], v[2]})
+		c.B = append(c.B, [2]float64{v[0], v[3]})
+	}
+	return c, nil
+}
@@ -0,0 +1,185 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/djfritz/positive"
+)
+
+// diffCmd prints the difference between two conversions of the same
+// negative, or between the files of the same name in two directories, to
+// evaluate parameter or algorithm changes across a test roll.
+func diffCmd(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	fOut := fs.String("out", "", "Write a visualization of the differences to the given file, or directory when comparing directories")
+	fGain := fs.Float64("gain", 10, "Multiply the differences in the visualization by the given gain so small ones are visible")
+	fThreads := fs.Int("threads", 0, "Maximum number of threads used to compare each image, 0 for all cores")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: positive diff [flags] <a> <b>")
+		fmt.Fprintln(fs.Output(), "       positive diff [flags] <dir a> <dir b>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	pairs, dirs, err := diffPairs(fs.Arg(0), fs.Arg(1), *fOut)
+	if err != nil {
+		fatal(inputError(err))
+	}
+	if *fOut != "" && dirs {
+		if err := os.MkdirAll(*fOut, 0755); err != nil {
+			fatal(outputError(err))
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tCHANNEL\tRMSE\tPSNR\tSSIM")
+	var sum positive.Difference
+	// identical pairs have an infinite PSNR, which is left out of the mean
+	// unless every pair is identical
+	var finite [3]int
+	for _, p := range pairs {
+		d, err := diff(p, *fGain, *fThreads)
+		if err != nil {
+			fatal(err)
+		}
+		printDifference(w, filepath.Base(p.a), d)
+		for c := 0; c < 3; c++ {
+			sum.RMSE[c] += d.RMSE[c] / float64(len(pairs))
+			sum.SSIM[c] += d.SSIM[c] / float64(len(pairs))
+			if !math.IsInf(d.PSNR[c], 1) {
+				sum.PSNR[c] += d.PSNR[c]
+				finite[c]++
+			}
+		}
+	}
+	for c := 0; c < 3; c++ {
+		if finite[c] == 0 {
+			sum.PSNR[c] = math.Inf(1)
+		} else {
+			sum.PSNR[c] /= float64(finite[c])
+		}
+	}
+	if len(pairs) > 1 {
+		printDifference(w, "mean", sum)
+	}
+	w.Flush()
+}
+
+// A diffPair is two images to compare, and where to write their
+// visualization, if anywhere.
+type diffPair struct {
+	a, b, out string
+}
+
+// diffPairs returns the images to compare: a and b, or if both are
+// directories, the images in a that are also in b, skipping other files
+// such as the sidecars convert writes next to outputs. It also reports
+// whether a and b are directories.
+func diffPairs(a, b, out string) ([]diffPair, bool, error) {
+	fa, err := os.Stat(a)
+	if err != nil {
+		return nil, false, err
+	}
+	fb, err := os.Stat(b)
+	if err != nil {
+		return nil, false, err
+	}
+	if !fa.IsDir() && !fb.IsDir() {
+		return []diffPair{{a, b, out}}, false, nil
+	}
+	if !fa.IsDir() || !fb.IsDir() {
+		return nil, false, fmt.Errorf("%v and %v must both be files or both be directories", a, b)
+	}
+
+	entries, err := os.ReadDir(a)
+	if err != nil {
+		return nil, true, err
+	}
+	var pairs []diffPair
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		p := diffPair{a: filepath.Join(a, e.Name()), b: filepath.Join(b, e.Name())}
+		if checkInput(p.a) != nil {
+			debugf("%v: not an image, skipping", p.a)
+			continue
+		}
+		if _, err := os.Stat(p.b); err != nil {
+			warnf("%v: not in %v, skipping", e.Name(), b)
+			continue
+		}
+		if out != "" {
+			p.out = filepath.Join(out, e.Name())
+		}
+		pairs = append(pairs, p)
+	}
+	if len(pairs) == 0 {
+		return nil, true, fmt.Errorf("%v and %v have no images in common", a, b)
+	}
+	return pairs, true, nil
+}
+
+// diff compares the images of p, writing their visualization with gain.
+func diff(p diffPair, gain float64, threads int) (positive.Difference, error) {
+	a, err := decode(p.a)
+	if err != nil {
+		return positive.Difference{}, inputError(fmt.Errorf("%v: %w", p.a, err))
+	}
+	b, err := decode(p.b)
+	if err != nil {
+		return positive.Difference{}, inputError(fmt.Errorf("%v: %w", p.b, err))
+	}
+
+	d, err := positive.Compare(a, b, threads)
+	if err != nil {
+		return d, fmt.Errorf("%v: %w", p.a, err)
+	}
+	if p.out == "" {
+		return d, nil
+	}
+
+	format, err := outputFormat(p.out)
+	if err != nil {
+		return d, usageError(err)
+	}
+	m, err := positive.DiffImage(a, b, gain, threads)
+	if err != nil {
+		return d, err
+	}
+	f, err := os.Create(p.out)
+	if err != nil {
+		return d, outputError(err)
+	}
+	defer f.Close()
+	if err := encode(f, m, format, nil); err != nil {
+		return d, outputError(err)
+	}
+	return d, outputError(f.Close())
+}
+
+// printDifference prints a row of d for each channel of name to w.
+func printDifference(w *tabwriter.Writer, name string, d positive.Difference) {
+	for c, channel := range []string{"r", "g", "b"} {
+		psnr := fmt.Sprintf("%.2f dB", d.PSNR[c])
+		if math.IsInf(d.PSNR[c], 1) {
+			psnr = "identical"
+		}
+		fmt.Fprintf(w, "%v\t%v\t%.5f\t%v\t%.4f\n", name, channel, d.RMSE[c], psnr, d.SSIM[c])
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/djfritz/positive"
+	"github.com/djfritz/positive/raw"
+	"github.com/djfritz/positive/tiffmeta"
+	_ "golang.org/x/image/tiff"
+)
+
+// output file extensions for each supported format
+var formatExt = map[string]string{
+	"tiff": ".tif",
+	"png":  ".png",
+	"jpeg": ".jpg",
+}
+
+// camera raw file extensions, which are decoded by the raw package since
+// they can't be told apart from regular TIFF files by their header
+var rawExt = map[string]bool{
+	".dng": true,
+	".nef": true,
+	".arw": true,
+}
+
+// magic numbers at the start of the image files that can be decoded, besides
+// camera raw files
+var inputMagic = []string{
+	"\x89PNG\r\n\x1a\n",
+	"\xff\xd8\xff",
+	"II*\x00",
+	"MM\x00*",
+}
+
+// checkInput returns an error if path doesn't exist or isn't an image in a
+// format that can be decoded, before any work is done converting it.
+func checkInput(path string) error {
+	if rawExt[strings.ToLower(filepath.Ext(path))] {
+		_, err := os.Stat(path)
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	head := make([]byte, 8)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	for _, magic := range inputMagic {
+		if bytes.HasPrefix(head[:n], []byte(magic)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%v: unsupported format, must be TIFF, PNG, JPEG, or camera raw", path)
+}
+
+// decode an image from the given file, in any registered format
+func decode(path string) (image.Image, error) {
+	m, _, err := decodeIR(path)
+	return m, err
+}
+
+// decodeIR decodes an image like decode, also returning the infrared channel
+// of RGBI scanner TIFFs, or nil if there is none. The path "-" decodes
+// stdin.
+func decodeIR(path string) (image.Image, *image.Gray16, error) {
+	if path == stdio {
+		data, err := readStdin()
+		if err != nil {
+			return nil, nil, err
+		}
+		return decodeData(data)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".tif", ".tiff":
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return nil, nil, err
+		}
+		return decodeData(data)
+	}
+
+	if rawExt[strings.ToLower(filepath.Ext(path))] {
+		m, err := raw.Decode(f)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", positive.ErrDecode, err)
+		}
+		return m, nil, nil
+	}
+
+	m, err := positive.Decode(f)
+	return m, nil, err
+}
+
+// decodeData decodes an image read into memory like decodeIR, trying TIFFs
+// as RGBI scans first.
+func decodeData(data []byte) (image.Image, *image.Gray16, error) {
+	if bytes.HasPrefix(data, []byte("II*\x00")) || bytes.HasPrefix(data, []byte("MM\x00*")) {
+		if m, ir, err := raw.DecodeRGBI(bytes.NewReader(data)); err == nil {
+			return m, ir, nil
+		}
+	}
+	m, err := positive.Decode(bytes.NewReader(data))
+	return m, nil, err
+}
+
+// outputFormat returns the format to write path in, either the -format flag
+// or inferred from the extension of path, defaulting to tiff.
+func outputFormat(path string) (string, error) {
+	if *fFormat != "" {
+		if _, ok := formatExt[*fFormat]; !ok {
+			return "", fmt.Errorf("unknown output format: %v", *fFormat)
+		}
+		return *fFormat, nil
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png":
+		return "png", nil
+	case ".jpg", ".jpeg":
+		return "jpeg", nil
+	}
+	return "tiff", nil
+}
+
+// longest side of thumbnails written by -thumbs
+const thumbSize = 320
+
+// thumbPath returns the path of the thumbnail of output in dir
+func thumbPath(dir, output string) string {
+	base := filepath.Base(output)
+	return filepath.Join(dir, strings.TrimSuffix(base, filepath.Ext(base))+formatExt["jpeg"])
+}
+
+// writeThumb writes a JPEG thumbnail of m, converted to output, to dir, no
+// larger than thumbSize
+func writeThumb(dir, output string, m image.Image) error {
+	if b := m.Bounds(); b.Dx() > thumbSize || b.Dy() > thumbSize {
+		w, h := fit(b.Dx(), b.Dy(), thumbSize, thumbSize)
+		m = positive.Resize(m, w, h, *fThreads)
+	}
+
+	f, err := createOutput(thumbPath(dir, output))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return encode(f, m, "jpeg", nil)
+}
+
+// proofPath returns the path of the proof of output, or output itself if it
+// is already a JPEG
+func proofPath(output string) string {
+	return strings.TrimSuffix(output, filepath.Ext(output)) + formatExt["jpeg"]
+}
+
+// writeProof writes an 8-bit JPEG copy of m next to output
+func writeProof(output string, m image.Image) error {
+	proof := proofPath(output)
+	if proof == output {
+		return nil
+	}
+
+	f, err := createOutput(proof)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return encode(f, m, "jpeg", nil)
+}
+
+// encode m to w in the given format. tags are written to TIFF output.
+func encode(w io.Writer, m image.Image, format string, tags []tiffmeta.Tag) error {
+	switch format {
+	case "png":
+		return png.Encode(w, m)
+	case "jpeg":
+		// 8-bit proof, the 16-bit values are truncated by the encoder
+		// unless dithered first
+		if *fDither {
+			m = positive.Dither(m)
+		}
+		return jpeg.Encode(w, m, &jpeg.Options{Quality: *fQuality})
+	case "tiff":
+		return tiffmeta.Encode(w, m, tags)
+	}
+	return fmt.Errorf("unknown output format: %v", format)
+}
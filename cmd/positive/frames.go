@@ -0,0 +1,47 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package main
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/djfritz/positive"
+)
+
+// calibration frames to correct inputs with, loaded by loadFrames
+var (
+	darkFrame image.Image
+	flatFrame image.Image
+)
+
+// loadFrames loads the -dark and -flat calibration frames, if given. The
+// flat field is itself corrected by the dark frame, since it is captured by
+// the same sensor.
+func loadFrames() error {
+	if *fDark != "" {
+		m, err := decode(*fDark)
+		if err != nil {
+			return fmt.Errorf("dark frame: %v", err)
+		}
+		darkFrame = m
+	}
+
+	if *fFlat != "" {
+		m, err := decode(*fFlat)
+		if err != nil {
+			return fmt.Errorf("flat field: %v", err)
+		}
+		if darkFrame != nil {
+			m, err = positive.SubtractDark(m, darkFrame, *fThreads)
+			if err != nil {
+				return fmt.Errorf("flat field: %v", err)
+			}
+		}
+		flatFrame = m
+	}
+	return nil
+}
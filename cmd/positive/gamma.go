@@ -0,0 +1,66 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/djfritz/positive/gamma"
+)
+
+// gammaCmd extracts a gamma profile from a datasheet plot and writes it as
+// JSON to stdout.
+func gammaCmd(args []string) {
+	var o gamma.Options
+
+	fs := flag.NewFlagSet("gamma", flag.ExitOnError)
+	fs.BoolVar(&o.BW, "bw", false, "Set black and white mode (single curve)")
+	fs.BoolVar(&o.Color, "color", false, "Identify curves by ink color instead of by their order")
+	fs.BoolVar(&o.Calibrate, "calibrate", false, "Scale results to log exposure and density units using the plot's grid or tick marks")
+	fs.Float64Var(&o.XStep, "xstep", 1.0, "Log exposure between vertical grid lines, with -calibrate")
+	fs.Float64Var(&o.YStep, "ystep", 1.0, "Density between horizontal grid lines, with -calibrate")
+	fs.StringVar(&o.Crop, "crop", "", "Plot rectangle as x0,y0,x1,y1, or auto to trim padding around the plot")
+	fs.IntVar(&o.Knots, "knots", 16, "Number of segments in the piecewise-linear curve fit")
+	fName := fs.String("name", "", "Profile name, defaults to the input file name")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: positive gamma [flags] <datasheet plot>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	input := fs.Arg(0)
+
+	m, err := decode(input)
+	if err != nil {
+		fatal(inputError(err))
+	}
+
+	p, err := gamma.Extract(m, o)
+	if err != nil {
+		fatal(err)
+	}
+
+	p.Name = *fName
+	p.Source = filepath.Base(input)
+	if p.Name == "" {
+		p.Name = strings.TrimSuffix(p.Source, filepath.Ext(p.Source))
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(p); err != nil {
+		fatal(err)
+	}
+}
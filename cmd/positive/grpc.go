@@ -0,0 +1,342 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The job service of api/positive.proto is served over gRPC by hand, on top
+// of the HTTP/2 support of net/http, rather than with generated code, which
+// keeps the tool free of dependencies: each call is a POST of a single
+// request message, answered by response messages and a status in the
+// trailers, and messages are encoded with the few protocol buffer types the
+// service uses.
+
+// gRPC status codes
+const (
+	grpcOK                 = 0
+	grpcInvalidArgument    = 3
+	grpcNotFound           = 5
+	grpcPermissionDenied   = 7
+	grpcResourceExhausted  = 8
+	grpcFailedPrecondition = 9
+	grpcUnimplemented      = 12
+	grpcInternal           = 13
+)
+
+// grpcCodes are the gRPC status codes of the HTTP statuses of jobErrors.
+var grpcCodes = map[int]int{
+	http.StatusBadRequest:          grpcInvalidArgument,
+	http.StatusForbidden:           grpcPermissionDenied,
+	http.StatusNotFound:            grpcNotFound,
+	http.StatusConflict:            grpcFailedPrecondition,
+	http.StatusServiceUnavailable:  grpcResourceExhausted,
+	http.StatusInternalServerError: grpcInternal,
+}
+
+const (
+	// largest request message read
+	grpcMaxMessage = 4 << 20
+
+	// size of the chunks the output of a job is streamed in by Result
+	resultChunkSize = 64 << 10
+)
+
+// A grpcError is an error ending a gRPC call, with its status code.
+type grpcError struct {
+	code int
+	msg  string
+}
+
+func (e *grpcError) Error() string { return e.msg }
+
+// errBadMessage is returned for request messages that can't be decoded.
+var errBadMessage = &grpcError{grpcInvalidArgument, "malformed protocol buffer message"}
+
+// isGRPC reports whether r is a gRPC call.
+func isGRPC(r *http.Request) bool {
+	return r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+// serveGRPC serves a gRPC call of the Positive service.
+func (s *jobServer) serveGRPC(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/grpc")
+	code, msg := grpcOK, ""
+	if err := s.grpcCall(w, r); err != nil {
+		var gerr *grpcError
+		if !errors.As(err, &gerr) {
+			gerr = &grpcError{grpcInternal, err.Error()}
+		}
+		code, msg = gerr.code, gerr.msg
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", grpcEscape(msg))
+	}
+}
+
+// grpcCall runs the gRPC call r, writing its response messages to w.
+func (s *jobServer) grpcCall(w http.ResponseWriter, r *http.Request) error {
+	method, _ := strings.CutPrefix(r.URL.Path, "/positive.v1.Positive/")
+	switch method {
+	case "Submit", "Get", "Watch", "Result":
+	default:
+		return &grpcError{grpcUnimplemented, fmt.Sprintf("unknown method %v", r.URL.Path)}
+	}
+	msg, err := readGRPC(r.Body)
+	if err != nil {
+		return err
+	}
+
+	if method == "Submit" {
+		var req submitRequest
+		if err := req.unmarshal(msg); err != nil {
+			return err
+		}
+		j, jerr := s.add(req)
+		if jerr != nil {
+			return grpcStatus(jerr)
+		}
+		return writeGRPC(w, j.marshal())
+	}
+
+	// the other methods take a GetRequest
+	var id string
+	err = fields(msg, func(num int, v []byte) error {
+		if num == 1 {
+			id = string(v)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	j, ok := s.job(id)
+	if !ok {
+		return &grpcError{grpcNotFound, fmt.Sprintf("job %q not found", id)}
+	}
+
+	switch method {
+	case "Get":
+		return writeGRPC(w, j.marshal())
+	case "Watch":
+		return j.follow(r.Context(), func(lines [][]byte) error {
+			for _, l := range lines {
+				var e struct{ Time, Level, Msg string }
+				if json.Unmarshal(l, &e) != nil {
+					continue
+				}
+				var p []byte
+				p = appendString(p, 1, e.Time)
+				p = appendString(p, 2, e.Level)
+				p = appendString(p, 3, e.Msg)
+				if err := writeGRPC(w, p); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	default: // Result
+		f, jerr := openResult(j)
+		if jerr != nil {
+			return grpcStatus(jerr)
+		}
+		defer f.Close()
+		buf := make([]byte, resultChunkSize)
+		for {
+			n, err := f.Read(buf)
+			if n > 0 {
+				if err := writeGRPC(w, appendField(nil, 1, buf[:n])); err != nil {
+					return err
+				}
+			}
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// grpcStatus returns the gRPC status of a jobError.
+func grpcStatus(err *jobError) *grpcError {
+	code, ok := grpcCodes[err.status]
+	if !ok {
+		code = grpcInternal
+	}
+	return &grpcError{code, err.msg}
+}
+
+// readGRPC reads the request message of a gRPC call, which may only be
+// uncompressed.
+func readGRPC(r io.Reader) ([]byte, error) {
+	var h [5]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return nil, &grpcError{grpcInvalidArgument, fmt.Sprintf("reading request: %v", err)}
+	}
+	if h[0] != 0 {
+		return nil, &grpcError{grpcUnimplemented, "compressed messages aren't supported"}
+	}
+	n := binary.BigEndian.Uint32(h[1:])
+	if n > grpcMaxMessage {
+		return nil, &grpcError{grpcResourceExhausted, fmt.Sprintf("request of %v bytes is too large", n)}
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, &grpcError{grpcInvalidArgument, fmt.Sprintf("reading request: %v", err)}
+	}
+	return msg, nil
+}
+
+// writeGRPC writes a response message of a gRPC call, and flushes it to the
+// client so streams arrive as they are written.
+func writeGRPC(w http.ResponseWriter, msg []byte) error {
+	h := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(h[1:], uint32(len(msg)))
+	if _, err := w.Write(append(h, msg...)); err != nil {
+		return err
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// grpcEscape percent encodes msg for the Grpc-Message trailer.
+func grpcEscape(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		if c := msg[i]; c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// unmarshal decodes req from a SubmitRequest message.
+func (req *submitRequest) unmarshal(msg []byte) error {
+	return fields(msg, func(num int, v []byte) error {
+		switch num {
+		case 1:
+			req.Input = string(v)
+		case 2:
+			req.Output = string(v)
+		case 3:
+			var k, val string
+			err := fields(v, func(num int, v []byte) error {
+				switch num {
+				case 1:
+					k = string(v)
+				case 2:
+					val = string(v)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if req.Flags == nil {
+				req.Flags = make(map[string]string)
+			}
+			req.Flags[k] = val
+		}
+		return nil
+	})
+}
+
+// marshal encodes j as a Job message.
+func (j *job) marshal() []byte {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var b []byte
+	b = appendString(b, 1, j.ID)
+	b = appendString(b, 2, j.Input)
+	b = appendString(b, 3, j.Output)
+	var names []string
+	for k := range j.Flags {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		b = appendField(b, 4, appendString(appendString(nil, 1, k), 2, j.Flags[k]))
+	}
+	b = appendString(b, 5, j.State)
+	b = appendString(b, 6, j.Error)
+	return b
+}
+
+// appendField appends the length delimited field num, a string, bytes, or
+// embedded message, to the protocol buffer message b.
+func appendField(b []byte, num int, v []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(num)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// appendString appends the string field num to b, unless it is empty, the
+// default value left out of messages.
+func appendString(b []byte, num int, v string) []byte {
+	if v == "" {
+		return b
+	}
+	return appendField(b, num, []byte(v))
+}
+
+// fields calls f with the number and value of each length delimited field of
+// the protocol buffer message b, skipping fields of other types.
+func fields(b []byte, f func(num int, v []byte) error) error {
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errBadMessage
+		}
+		b = b[n:]
+
+		switch tag & 7 {
+		case 0: // varint
+			if _, n = binary.Uvarint(b); n <= 0 {
+				return errBadMessage
+			}
+			b = b[n:]
+		case 1: // 64-bit
+			if len(b) < 8 {
+				return errBadMessage
+			}
+			b = b[8:]
+		case 5: // 32-bit
+			if len(b) < 4 {
+				return errBadMessage
+			}
+			b = b[4:]
+		case 2: // length delimited
+			l, n := binary.Uvarint(b)
+			if n <= 0 || l > uint64(len(b)-n) {
+				return errBadMessage
+			}
+			v := b[n : n+int(l)]
+			b = b[n+int(l):]
+			if err := f(int(tag>>3), v); err != nil {
+				return err
+			}
+		default:
+			return errBadMessage
+		}
+	}
+	return nil
+}
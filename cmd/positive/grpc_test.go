@@ -0,0 +1,158 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// testJobServer returns a job server for a temporary root holding in.png,
+// without workers, so jobs stay queued.
+func testJobServer(t *testing.T) (*jobServer, *httptest.Server) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "in.png"), nil, 0o666); err != nil {
+		t.Fatal(err)
+	}
+	s := &jobServer{
+		root:  root,
+		max:   10,
+		jobs:  make(map[string]*job),
+		queue: make(chan *job, 10),
+	}
+	ts := httptest.NewUnstartedServer(s)
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+	return s, ts
+}
+
+// call makes the gRPC call of method with the request message req, returning
+// the response messages and the status code.
+func call(t *testing.T, ts *httptest.Server, method string, req []byte) ([][]byte, int) {
+	body := binary.BigEndian.AppendUint32([]byte{0}, uint32(len(req)))
+	r, err := http.NewRequest(http.MethodPost, ts.URL+"/positive.v1.Positive/"+method, bytes.NewReader(append(body, req...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "application/grpc")
+	resp, err := ts.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var msgs [][]byte
+	for len(data) >= 5 {
+		n := int(binary.BigEndian.Uint32(data[1:]))
+		msgs = append(msgs, data[5:5+n])
+		data = data[5+n:]
+	}
+	code, err := strconv.Atoi(resp.Trailer.Get("Grpc-Status"))
+	if err != nil {
+		t.Fatalf("%v: no status: %v", method, err)
+	}
+	return msgs, code
+}
+
+// TestGRPC checks the gRPC calls of the job service and their errors.
+func TestGRPC(t *testing.T) {
+	s, ts := testJobServer(t)
+
+	submit := func(input string, flags ...string) []byte {
+		var b []byte
+		b = appendString(b, 1, input)
+		b = appendString(b, 2, "out.png")
+		for i := 0; i < len(flags); i += 2 {
+			b = appendField(b, 3, appendString(appendString(nil, 1, flags[i]), 2, flags[i+1]))
+		}
+		return b
+	}
+	msgs, code := call(t, ts, "Submit", submit("in.png", "gamma", "portra160"))
+	if code != grpcOK || len(msgs) != 1 {
+		t.Fatalf("submit: status %v, %v messages", code, len(msgs))
+	}
+	j, _ := s.job("1")
+	if !bytes.Equal(msgs[0], j.marshal()) {
+		t.Errorf("submit: job %q, want %q", msgs[0], j.marshal())
+	}
+	if j.Input != filepath.Join(s.root, "in.png") || j.Flags["gamma"] != "portra160" {
+		t.Errorf("submit: input %v, flags %v", j.Input, j.Flags)
+	}
+
+	get := appendString(nil, 1, "1")
+	if msgs, code := call(t, ts, "Get", get); code != grpcOK || len(msgs) != 1 || !bytes.Equal(msgs[0], j.marshal()) {
+		t.Errorf("get: status %v, messages %q", code, msgs)
+	}
+
+	for _, c := range []struct {
+		method string
+		req    []byte
+		code   int
+	}{
+		{"Submit", submit("in.png", "hook", "pre-invert=true"), grpcInvalidArgument},
+		{"Submit", submit("../in.png"), grpcPermissionDenied},
+		{"Submit", submit(""), grpcInvalidArgument},
+		{"Submit", []byte{0x0a, 0xff}, grpcInvalidArgument},
+		{"Get", appendString(nil, 1, "2"), grpcNotFound},
+		{"Result", get, grpcFailedPrecondition},
+		{"Cancel", get, grpcUnimplemented},
+	} {
+		if _, code := call(t, ts, c.method, c.req); code != c.code {
+			t.Errorf("%v %q: status %v, want %v", c.method, c.req, code, c.code)
+		}
+	}
+
+	// the log of a finished job is streamed by Watch
+	j.log([]byte(`{"time":"t","level":"INFO","msg":"converting"}`))
+	j.update(func() { j.State = jobDone })
+	msgs, code = call(t, ts, "Watch", get)
+	want := appendString(appendString(appendString(nil, 1, "t"), 2, "INFO"), 3, "converting")
+	if code != grpcOK || len(msgs) != 1 || !bytes.Equal(msgs[0], want) {
+		t.Errorf("watch: status %v, messages %q", code, msgs)
+	}
+}
+
+// TestJobEvents checks only the last log lines of a job are kept, and
+// followed.
+func TestJobEvents(t *testing.T) {
+	j := &job{changed: make(chan struct{})}
+	n := maxJobEvents*2 + 10
+	for i := 0; i < n; i++ {
+		j.log([]byte(strconv.Itoa(i)))
+	}
+	j.update(func() { j.State = jobDone })
+	if len(j.events) > maxJobEvents {
+		t.Errorf("%v log lines kept, want at most %v", len(j.events), maxJobEvents)
+	}
+
+	var got []string
+	j.follow(context.Background(), func(lines [][]byte) error {
+		for _, l := range lines {
+			got = append(got, string(l))
+		}
+		return nil
+	})
+	if len(got) == 0 || got[len(got)-1] != fmt.Sprint(n-1) || len(got) != len(j.events) {
+		t.Errorf("followed %v lines ending %q, want the %v kept", len(got), got[len(got)-1:], len(j.events))
+	}
+}
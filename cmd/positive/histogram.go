@@ -0,0 +1,123 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// histogram rendering sizes
+const (
+	histBins   = 256
+	histHeight = 128
+	histText   = 64
+)
+
+// writeHistogram writes the input and output histograms of a conversion as
+// requested by -histogram: as text to stdout, or as a PNG image.
+func writeHistogram(output string, in, out [3][]int) error {
+	if *fHistogram == "text" {
+		fmt.Print(textHistogram(output, in, out))
+		return nil
+	}
+
+	path := *fHistogram
+	if batchMode() {
+		path = strings.TrimSuffix(output, filepath.Ext(output)) + ".histogram.png"
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return png.Encode(f, plotHistogram(in, out))
+}
+
+// plotHistogram draws the input histogram above the output histogram, with
+// the channels overlaid additively. Counts are square root scaled so that
+// clipped values don't flatten everything else.
+func plotHistogram(in, out [3][]int) image.Image {
+	m := image.NewRGBA(image.Rect(0, 0, histBins, 2*histHeight+1))
+	for i := range m.Pix {
+		m.Pix[i] = 0xff
+		if i%4 != 3 {
+			m.Pix[i] = 0
+		}
+	}
+
+	for i, h := range [][3][]int{in, out} {
+		top := i * (histHeight + 1)
+		peak := histPeak(h)
+		for c := range h {
+			for x, v := range h[c] {
+				bar := int(math.Sqrt(float64(v)/peak) * histHeight)
+				for y := histHeight - bar; y < histHeight; y++ {
+					m.Pix[m.PixOffset(x, top+y)+c] = 0xff
+				}
+			}
+		}
+	}
+
+	// separate the two plots
+	for x := 0; x < histBins; x++ {
+		m.Set(x, histHeight, color.Gray{0x80})
+	}
+	return m
+}
+
+// textHistogram renders the histograms as one line of block characters per
+// channel.
+func textHistogram(output string, in, out [3][]int) string {
+	blocks := []rune(" ▁▂▃▄▅▆▇█")
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%v:\n", output)
+	for _, p := range []struct {
+		name string
+		h    [3][]int
+	}{{"in", in}, {"out", out}} {
+		// merge bins down to the text width
+		var h [3][]int
+		for c := range h {
+			h[c] = make([]int, histText)
+			for i, v := range p.h[c] {
+				h[c][i*histText/len(p.h[c])] += v
+			}
+		}
+
+		peak := histPeak(h)
+		for c, name := range "rgb" {
+			fmt.Fprintf(&b, "%3v %c |", p.name, name)
+			for _, v := range h[c] {
+				b.WriteRune(blocks[int(math.Sqrt(float64(v)/peak)*float64(len(blocks)-1))])
+			}
+			b.WriteString("|\n")
+		}
+	}
+	return b.String()
+}
+
+// histPeak returns the largest bin of h, or 1 if it is empty.
+func histPeak(h [3][]int) float64 {
+	peak := 1
+	for _, c := range h {
+		for _, v := range c {
+			if v > peak {
+				peak = v
+			}
+		}
+	}
+	return float64(peak)
+}
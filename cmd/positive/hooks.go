@@ -0,0 +1,105 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// pipeline stages hooks can run at
+var hookStages = map[string]bool{
+	"post-decode": true, // after decoding and cleaning up the scan
+	"pre-invert":  true, // before the film mask is sampled and the negative inverted
+	"pre-encode":  true, // after conversion, cropping, and resizing
+}
+
+// A hook is an external command run at a stage of the pipeline, which reads
+// the image as a PNG on stdin and writes the image to continue with to
+// stdout.
+type hook struct {
+	stage string
+	args  []string
+}
+
+// hookList is the flag.Value of -hook, which may be repeated, or given
+// several hooks separated by semicolons.
+type hookList []hook
+
+func (h *hookList) String() string {
+	var s []string
+	for _, k := range *h {
+		s = append(s, k.stage+"="+strings.Join(k.args, " "))
+	}
+	return strings.Join(s, ";")
+}
+
+func (h *hookList) Set(v string) error {
+	for _, s := range strings.Split(v, ";") {
+		stage, command, ok := strings.Cut(s, "=")
+		stage = strings.TrimSpace(stage)
+		if !ok || !hookStages[stage] {
+			return fmt.Errorf("invalid hook %q, must be post-decode, pre-invert, or pre-encode=command", s)
+		}
+		args := strings.Fields(command)
+		if len(args) == 0 {
+			return fmt.Errorf("hook %q has no command", s)
+		}
+		*h = append(*h, hook{stage, args})
+	}
+	return nil
+}
+
+// hookVar defines a -hook flag in fs.
+func hookVar(fs *flag.FlagSet, name, usage string) *hookList {
+	h := new(hookList)
+	fs.Var(h, name, usage)
+	return h
+}
+
+// runHooks runs the hooks of stage in order on m, converting input to
+// output, returning the image the last of them writes.
+func runHooks(stage string, m image.Image, input, output string) (image.Image, error) {
+	for _, h := range *fHooks {
+		if h.stage != stage {
+			continue
+		}
+		debugf("%v: running %v hook %v", input, stage, strings.Join(h.args, " "))
+
+		var in bytes.Buffer
+		enc := png.Encoder{CompressionLevel: png.NoCompression}
+		if err := enc.Encode(&in, m); err != nil {
+			return nil, err
+		}
+
+		var out bytes.Buffer
+		cmd := exec.Command(h.args[0], h.args[1:]...)
+		cmd.Stdin = &in
+		cmd.Stdout = &out
+		cmd.Stderr = os.Stderr
+		cmd.Env = append(os.Environ(),
+			"POSITIVE_STAGE="+stage,
+			"POSITIVE_INPUT="+input,
+			"POSITIVE_OUTPUT="+output,
+		)
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("%v hook %v: %v", stage, h.args[0], err)
+		}
+
+		var err error
+		m, _, err = image.Decode(&out)
+		if err != nil {
+			return nil, fmt.Errorf("%v hook %v: %v", stage, h.args[0], err)
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/djfritz/positive"
+)
+
+// rollLevels finds the normalization levels of each of inputs and merges
+// them, so every frame of a roll can be converted with the same levels.
+func rollLevels(inputs []string, o positive.Options) (positive.Levels, error) {
+	var ls []positive.Levels
+	for _, input := range inputs {
+		m, o, err := load(input, o)
+		if err != nil {
+			return positive.Levels{}, fmt.Errorf("%v: %v", input, err)
+		}
+
+		l, err := positive.FindLevels(m, o)
+		if err != nil {
+			return positive.Levels{}, err
+		}
+		ls = append(ls, l)
+	}
+
+	l := positive.MergeLevels(ls...)
+	infof("levels from %v frames: r %v-%v g %v-%v b %v-%v", len(ls), l.RMin, l.RMax, l.GMin, l.GMax, l.BMin, l.BMax)
+	return l, nil
+}
+
+// levelsPath returns the path of the levels sidecar written for output
+func levelsPath(output string) string {
+	return strings.TrimSuffix(output, filepath.Ext(output)) + ".levels.json"
+}
+
+// saveLevels writes l to path as JSON
+func saveLevels(path string, l positive.Levels) error {
+	data, err := json.MarshalIndent(l, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// loadLevels reads levels written by saveLevels
+func loadLevels(path string) (positive.Levels, error) {
+	var l positive.Levels
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return l, err
+	}
+
+	if err := json.Unmarshal(data, &l); err != nil {
+		return l, fmt.Errorf("%v: %v", path, err)
+	}
+	if l.RMin >= l.RMax || l.GMin >= l.GMax || l.BMin >= l.BMax || l.RMax > 0xffff || l.GMax > 0xffff || l.BMax > 0xffff {
+		return l, fmt.Errorf("%v: levels must satisfy min < max <= 65535", path)
+	}
+	return l, nil
+}
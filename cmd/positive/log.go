@@ -0,0 +1,140 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+
+	"github.com/djfritz/positive"
+)
+
+// exit codes, by class of failure
+const (
+	exitFailure = 1 // conversion or other failure
+	exitUsage   = 2 // invalid flags or arguments, as the flag package uses
+	exitInput   = 3 // an input can't be read or decoded
+	exitOutput  = 4 // an output can't be written, or already exists
+	exitPartial = 5 // some conversions of a batch failed
+)
+
+var (
+	// messages below logLevel aren't logged
+	logLevel = slog.LevelInfo
+
+	// logger for -json-log, or nil to log text with the log package
+	jsonLog *slog.Logger
+)
+
+// setupLog configures logging from -v, -quiet, and -json-log.
+func setupLog() error {
+	if *fVerbose && *fQuiet {
+		return usageError(errors.New("-v and -quiet are mutually exclusive"))
+	}
+	switch {
+	case *fVerbose:
+		logLevel = slog.LevelDebug
+	case *fQuiet:
+		logLevel = slog.LevelWarn
+	}
+	if *fJSONLog {
+		jsonLog = slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel}))
+	}
+	return nil
+}
+
+// logf logs a message at the given level. Text messages other than
+// information are prefixed with their level.
+func logf(level slog.Level, format string, args ...any) {
+	if level < logLevel {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	if jsonLog != nil {
+		jsonLog.Log(context.Background(), level, msg)
+		return
+	}
+	switch level {
+	case slog.LevelDebug:
+		msg = "debug: " + msg
+	case slog.LevelWarn:
+		msg = "warning: " + msg
+	case slog.LevelError:
+		msg = "error: " + msg
+	}
+	bar.clear()
+	log.Print(msg)
+	bar.draw()
+}
+
+func debugf(format string, args ...any) { logf(slog.LevelDebug, format, args...) }
+func infof(format string, args ...any)  { logf(slog.LevelInfo, format, args...) }
+func warnf(format string, args ...any)  { logf(slog.LevelWarn, format, args...) }
+func errorf(format string, args ...any) { logf(slog.LevelError, format, args...) }
+
+// A failure is an error with the exit code of its class.
+type failure struct {
+	code int
+	err  error
+}
+
+func (f failure) Error() string { return f.err.Error() }
+func (f failure) Unwrap() error { return f.err }
+
+// classify returns err with the exit code code, unless it already has one.
+func classify(code int, err error) error {
+	var f failure
+	if err == nil || errors.As(err, &f) {
+		return err
+	}
+	return failure{code, err}
+}
+
+func usageError(err error) error  { return classify(exitUsage, err) }
+func inputError(err error) error  { return classify(exitInput, err) }
+func outputError(err error) error { return classify(exitOutput, err) }
+
+// exitCode returns the exit code of the class of err, or of its kind if it
+// is an unclassified library error.
+func exitCode(err error) int {
+	var f failure
+	switch {
+	case errors.As(err, &f):
+		return f.code
+	case configError(err):
+		return exitUsage
+	case errors.Is(err, positive.ErrDecode),
+		errors.Is(err, positive.ErrBadBaseSample),
+		errors.Is(err, positive.ErrImageMismatch):
+		return exitInput
+	}
+	return exitFailure
+}
+
+// configError reports whether err is a configuration error, which would fail
+// every input the same way.
+func configError(err error) bool {
+	return errors.Is(err, positive.ErrInvalidOptions) ||
+		errors.Is(err, positive.ErrUnknownProfile) ||
+		errors.Is(err, positive.ErrBadProfile)
+}
+
+// fatal logs err and exits with the exit code of its class.
+func fatal(err error) {
+	bar.finish()
+	stopProfiles()
+	errorf("%v", err)
+	os.Exit(exitCode(err))
+}
+
+// fatalf logs a usage error and exits.
+func fatalf(format string, args ...any) {
+	fatal(usageError(fmt.Errorf(format, args...)))
+}
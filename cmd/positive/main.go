@@ -0,0 +1,61 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+// Command positive converts film negatives into positives, and provides tools
+// for creating and managing the film profiles used to do so.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// commands, by name
+var commands = map[string]func(args []string){
+	"convert":   convertCmd,
+	"gamma":     gammaCmd,
+	"sample":    sampleCmd,
+	"profiles":  profilesCmd,
+	"calibrate": calibrateCmd,
+	"target":    targetCmd,
+	"analyze":   analyzeCmd,
+	"preview":   previewCmd,
+	"serve":     serveCmd,
+	"diff":      diffCmd,
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage: positive <command> [flags] [arguments]
+
+Commands:
+	convert    convert negatives to positives (the default)
+	gamma      extract a gamma profile from a datasheet plot
+	sample     print the average color of a film mask sample
+	profiles   list the available gamma profiles
+	calibrate  derive a gamma profile from a step wedge scan
+	target     fit a color correction matrix to a target scan
+	analyze    report statistics and suggested settings for negatives
+	preview    adjust settings in a web browser with a live preview
+	serve      serve a job API for converting in a lab pipeline
+	diff       compare conversions by RMSE, PSNR, and SSIM
+
+Run "positive <command> -h" for help on a command.`)
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		if c, ok := commands[os.Args[1]]; ok {
+			c(os.Args[2:])
+			return
+		}
+		if os.Args[1] == "help" {
+			usage()
+			return
+		}
+	}
+
+	// without a command, convert as earlier versions of the tool did
+	convertCmd(os.Args[1:])
+}
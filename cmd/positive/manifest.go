@@ -0,0 +1,139 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/djfritz/positive"
+)
+
+// frame is a manifest entry, overriding the settings of a single input.
+// Empty fields keep the command line's settings.
+type frame struct {
+	Gamma     string `json:"gamma"`
+	BaseColor string `json:"base-color"`
+	Rotate    *int   `json:"rotate"`
+	Crop      string `json:"crop"`
+}
+
+// per input overrides, loaded by loadManifest
+var manifest map[string]frame
+
+// loadManifest loads the -manifest file, either CSV with a header row naming
+// the input and override columns, or JSON mapping inputs to overrides.
+func loadManifest() error {
+	if *fManifest == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(*fManifest)
+	if err != nil {
+		return err
+	}
+
+	if strings.ToLower(filepath.Ext(*fManifest)) != ".csv" {
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return fmt.Errorf("%v: %v", *fManifest, err)
+		}
+		return nil
+	}
+
+	rows, err := csv.NewReader(strings.NewReader(string(data))).ReadAll()
+	if err != nil {
+		return fmt.Errorf("%v: %v", *fManifest, err)
+	}
+	if len(rows) == 0 || rows[0][0] != "input" {
+		return fmt.Errorf("%v: expected a header row starting with input", *fManifest)
+	}
+
+	manifest = make(map[string]frame)
+	for _, row := range rows[1:] {
+		var f frame
+		for i, v := range row[1:] {
+			if v == "" {
+				continue
+			}
+			switch col := rows[0][i+1]; col {
+			case "gamma":
+				f.Gamma = v
+			case "base-color":
+				f.BaseColor = v
+			case "rotate":
+				r, err := strconv.Atoi(v)
+				if err != nil {
+					return fmt.Errorf("%v: %v: invalid rotate %q", *fManifest, row[0], v)
+				}
+				f.Rotate = &r
+			case "crop":
+				f.Crop = v
+			default:
+				return fmt.Errorf("%v: unknown column %q", *fManifest, col)
+			}
+		}
+		manifest[row[0]] = f
+	}
+	return nil
+}
+
+// lookup returns the manifest entry for input, by its path or file name
+func lookup(input string) frame {
+	if f, ok := manifest[input]; ok {
+		return f
+	}
+	return manifest[filepath.Base(input)]
+}
+
+// apply returns o with the gamma profile and film mask overridden by f
+func (f frame) apply(o positive.Options) (positive.Options, error) {
+	if f.Gamma != "" {
+		p, err := profile(f.Gamma)
+		if err != nil {
+			return o, err
+		}
+		o.Gamma = p.Pushed(*fPush)
+		if *fMatrix == "" {
+			o.Matrix = p.Matrix
+		}
+		if *fFilmLight == "" {
+			o.FilmLight = p.Light
+			if *fLight == "" {
+				o.Light = o.FilmLight
+			}
+		}
+	}
+
+	if f.BaseColor != "" {
+		c, err := parseColor(f.BaseColor)
+		if err != nil {
+			return o, err
+		}
+		o.Base = c
+	}
+	return o, nil
+}
+
+// rotation returns the clockwise rotation of f, or -rotate
+func (f frame) rotation() int {
+	if f.Rotate != nil {
+		return *f.Rotate
+	}
+	return *fRotate
+}
+
+// crop returns the crop rectangle of f, or -crop
+func (f frame) crop() string {
+	if f.Crop != "" {
+		return f.Crop
+	}
+	return *fCrop
+}
@@ -0,0 +1,183 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/djfritz/positive"
+	"github.com/djfritz/positive/icc"
+	"github.com/djfritz/positive/tiffmeta"
+)
+
+// ICC profile to embed in TIFF output, loaded by loadICC
+var iccProfile []byte
+
+// loadICC loads the -icc profile, either one of the built in profiles or an
+// ICC profile file, or the profile of the -colorspace the output is encoded
+// in.
+func loadICC() error {
+	if *fColorSpace != "" {
+		p, err := icc.Profile(*fColorSpace)
+		if err != nil {
+			return usageError(fmt.Errorf("-colorspace must be one of %v", icc.Names()))
+		}
+		iccProfile = p
+		return nil
+	}
+	if *fICC == "" {
+		return nil
+	}
+
+	p, err := icc.Profile(*fICC)
+	if err != nil {
+		p, err = os.ReadFile(*fICC)
+		if err != nil {
+			return fmt.Errorf("-icc must be one of %v or a profile file: %v", icc.Names(), err)
+		}
+	}
+	iccProfile = p
+	return nil
+}
+
+// metadata returns the TIFF tags to write for a conversion of input: the
+// descriptive tags of the input, if it is a TIFF, plus the film profile and
+// processing parameters used.
+func metadata(input string, o positive.Options) []tiffmeta.Tag {
+	var tags []tiffmeta.Tag
+	if input == stdio {
+		tags, _ = tiffmeta.Read(bytes.NewReader(stdin))
+	} else if f, err := os.Open(input); err == nil {
+		// non-TIFF inputs simply have no tags to carry over
+		tags, _ = tiffmeta.Read(f)
+		f.Close()
+	}
+
+	film := *fGamma
+	if g := lookup(input).Gamma; g != "" {
+		film = g
+	}
+	desc := fmt.Sprintf("film=%v mode=%v gamma=%v,%v,%v normalize=%v linked=%v border=%v tupper=%v tlower=%v invert=%v",
+		film, *fMode, o.Gamma.R, o.Gamma.G, o.Gamma.B, o.Normalize, o.Linked, *fBorder, o.Upper, o.Lower, o.Invert)
+	if o.BW {
+		desc += " bw=true"
+		if o.Toning != positive.ToningNone {
+			desc += fmt.Sprintf(" toning=%v", o.Toning)
+		}
+	}
+	if o.Light != o.FilmLight {
+		desc += fmt.Sprintf(" film-light=%v light=%v", o.FilmLight, o.Light)
+	}
+	if o.Curves != nil {
+		desc += fmt.Sprintf(" curves=%v", filepath.Base(*fCurves))
+	}
+	if *fPush != 0 {
+		desc += fmt.Sprintf(" push=%v", *fPush)
+	}
+	if o.Dust > 0 {
+		desc += fmt.Sprintf(" dust=%v dust-radius=%v", o.Dust, o.DustRadius)
+	}
+	if o.Slide {
+		desc += " slide=true"
+	}
+	if *fReverse {
+		desc += " reverse=true"
+	}
+	if o.Rolloff > 0 {
+		desc += fmt.Sprintf(" rolloff=%v", o.Rolloff)
+	}
+	if o.Balance != positive.BalanceNone {
+		desc += fmt.Sprintf(" awb=%v", o.Balance)
+	}
+	if o.EV != 0 {
+		desc += fmt.Sprintf(" ev=%v", o.EV)
+	}
+	if o.Denoise > 0 || o.DenoiseChroma > 0 {
+		desc += fmt.Sprintf(" denoise=%v denoise-chroma=%v", o.Denoise, o.DenoiseChroma)
+	}
+	if o.LocalContrast > 0 {
+		desc += fmt.Sprintf(" local-contrast=%v local-contrast-clip=%v local-contrast-tiles=%v", o.LocalContrast, o.LocalContrastClip, o.LocalContrastTiles)
+	}
+	if o.Sharpen > 0 {
+		desc += fmt.Sprintf(" sharpen=%v sharpen-radius=%v sharpen-threshold=%v", o.Sharpen, o.SharpenRadius, o.SharpenThreshold)
+	}
+	if o.Highlights > 0 || o.Shadows > 0 {
+		desc += fmt.Sprintf(" highlights=%v shadows=%v", o.Highlights, o.Shadows)
+	}
+	if o.Midtone != 1 {
+		desc += fmt.Sprintf(" midtone=%v", o.Midtone)
+	}
+	if o.Tone != positive.ToneLinear || o.Contrast != 0 {
+		desc += fmt.Sprintf(" tone=%v contrast=%v", o.Tone, o.Contrast)
+	}
+	if o.ColorSpace != positive.ColorSpaceNone {
+		desc += fmt.Sprintf(" colorspace=%v", o.ColorSpace)
+	}
+	if o.Look != positive.LookNone {
+		desc += fmt.Sprintf(" look=%v", o.Look)
+	}
+	if o.Temp != 0 || o.Tint != 0 {
+		desc += fmt.Sprintf(" temp=%v tint=%v", o.Temp, o.Tint)
+	}
+	if !o.Neutral.Empty() {
+		desc += fmt.Sprintf(" neutral=%v", *fNeutral)
+	}
+	if !o.ROI.Empty() {
+		desc += fmt.Sprintf(" roi=%v", *fROI)
+	}
+	if o.Base != nil {
+		r, g, b, _ := o.Base.RGBA()
+		desc += fmt.Sprintf(" base=%v,%v,%v mask=%v", r, g, b, *fMask)
+	}
+
+	// the description and software of the input, such as the scanner
+	// software, are kept, with the parameters on a line of their own
+	if d := tagString(tags, tiffmeta.ImageDescription); d != "" {
+		desc = d + "\n" + desc
+	}
+	tags = tiffmeta.Set(tags, tiffmeta.ASCII(tiffmeta.ImageDescription, desc))
+	if tagString(tags, tiffmeta.Software) == "" {
+		tags = tiffmeta.Set(tags, tiffmeta.ASCII(tiffmeta.Software, "positive"))
+	}
+	tags = tiffmeta.Set(tags, tiffmeta.ASCII(tiffmeta.ProcessingSoftware, "positive"))
+	if iccProfile != nil {
+		tags = tiffmeta.Set(tags, tiffmeta.Undefined(tiffmeta.ICCProfile, iccProfile))
+	}
+	return tags
+}
+
+// tagString returns the value of the ASCII tag id, or "" if it isn't in tags.
+func tagString(tags []tiffmeta.Tag, id uint16) string {
+	for _, t := range tags {
+		if t.ID == id {
+			return string(bytes.TrimRight(t.Value, "\x00"))
+		}
+	}
+	return ""
+}
+
+// convertedInput reports whether input is a TIFF written by a conversion to a
+// positive, by its processing software and the parameters on the last line of
+// its description, so it isn't inverted again by mistake. Older versions
+// replaced the Software tag instead.
+func convertedInput(input string) bool {
+	var tags []tiffmeta.Tag
+	if input == stdio {
+		tags, _ = tiffmeta.Read(bytes.NewReader(stdin))
+	} else if f, err := os.Open(input); err == nil {
+		tags, _ = tiffmeta.Read(f)
+		f.Close()
+	}
+	if tagString(tags, tiffmeta.ProcessingSoftware) != "positive" && tagString(tags, tiffmeta.Software) != "positive" {
+		return false
+	}
+	desc := tagString(tags, tiffmeta.ImageDescription)
+	return !strings.Contains(desc[strings.LastIndex(desc, "\n")+1:], "reverse=true")
+}
@@ -0,0 +1,64 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package main
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/djfritz/positive"
+	"github.com/djfritz/positive/tiffmeta"
+)
+
+// writeTIFF writes a small TIFF with tags to a temporary file, returning its
+// path.
+func writeTIFF(t *testing.T, tags ...tiffmeta.Tag) string {
+	path := filepath.Join(t.TempDir(), "scan.tif")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := tiffmeta.Encode(f, image.NewRGBA64(image.Rect(0, 0, 2, 2)), tags); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestMetadata checks the software and description of a scan are kept in
+// its output, and the output is recognized as converted.
+func TestMetadata(t *testing.T) {
+	scan := writeTIFF(t,
+		tiffmeta.ASCII(tiffmeta.Software, "VueScan 9.8"),
+		tiffmeta.ASCII(tiffmeta.ImageDescription, "roll 12"))
+	o := positive.DefaultOptions()
+	tags := metadata(scan, o)
+
+	if s := tagString(tags, tiffmeta.Software); s != "VueScan 9.8" {
+		t.Errorf("software %q, want that of the scan", s)
+	}
+	if s := tagString(tags, tiffmeta.ProcessingSoftware); s != "positive" {
+		t.Errorf("processing software %q, want positive", s)
+	}
+	desc := tagString(tags, tiffmeta.ImageDescription)
+	if !strings.HasPrefix(desc, "roll 12\nfilm=") {
+		t.Errorf("description %q, want that of the scan and the parameters", desc)
+	}
+
+	if convertedInput(scan) {
+		t.Error("scan recognized as converted")
+	}
+	if !convertedInput(writeTIFF(t, tags...)) {
+		t.Error("output not recognized as converted")
+	}
+	reversed := tiffmeta.ASCII(tiffmeta.ImageDescription, desc+" reverse=true")
+	if convertedInput(writeTIFF(t, tiffmeta.Set(tags, reversed)...)) {
+		t.Error("reversed output recognized as converted")
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// batchMode reports whether each input is converted to an output named
+// after it, with -outdir or -out.
+func batchMode() bool {
+	return *fOutdir != "" || *fOut != ""
+}
+
+// outputPath returns the output for input, expanding the -out template, or
+// in -outdir under the same name.
+func outputPath(input string) string {
+	if *fOut != "" {
+		ext := filepath.Ext(input)
+		r := strings.NewReplacer(
+			"{dir}", filepath.Dir(input),
+			"{name}", strings.TrimSuffix(filepath.Base(input), ext),
+			"{ext}", ext,
+		)
+		return filepath.Clean(r.Replace(*fOut))
+	}
+
+	output := filepath.Join(*fOutdir, filepath.Base(input))
+	if *fFormat != "" {
+		output = strings.TrimSuffix(output, filepath.Ext(output)) + formatExt[*fFormat]
+	}
+	return output
+}
+
+// checkOutput returns an error if output exists and -force isn't set, before
+// any work is done converting to it.
+func checkOutput(output string) error {
+	if *fForce || output == stdio {
+		return nil
+	}
+	if _, err := os.Stat(output); err == nil {
+		return outputError(fmt.Errorf("%v already exists, use -force to overwrite it", output))
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return outputError(err)
+	}
+	return nil
+}
+
+// checkOutputs is checkOutput of output and the proof and thumbnail written
+// with it, if any.
+func checkOutputs(output string) error {
+	if err := checkOutput(output); err != nil {
+		return err
+	}
+	if *fProof {
+		if proof := proofPath(output); proof != output {
+			if err := checkOutput(proof); err != nil {
+				return err
+			}
+		}
+	}
+	if *fThumbs != "" {
+		return checkOutput(thumbPath(*fThumbs, output))
+	}
+	return nil
+}
+
+// createOutput creates output, which must not exist unless -force is set, so
+// concurrent conversions to the same output don't clobber each other.
+func createOutput(output string) (*os.File, error) {
+	if output == stdio {
+		return os.Stdout, nil
+	}
+
+	flag := os.O_RDWR | os.O_CREATE | os.O_TRUNC
+	if !*fForce {
+		flag |= os.O_EXCL
+	}
+	f, err := os.OpenFile(output, flag, 0666)
+	if errors.Is(err, fs.ErrExist) {
+		return nil, outputError(fmt.Errorf("%v already exists, use -force to overwrite it", output))
+	}
+	return f, outputError(err)
+}
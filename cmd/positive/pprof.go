@@ -0,0 +1,61 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package main
+
+import (
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// file the CPU profile is written to while converting, if -cpuprofile is set
+var cpuProfile *os.File
+
+// startProfiles starts the CPU profile of -cpuprofile, if set.
+func startProfiles() error {
+	if *fCPUProfile == "" {
+		return nil
+	}
+	f, err := os.Create(*fCPUProfile)
+	if err != nil {
+		return err
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+	cpuProfile = f
+	return nil
+}
+
+// stopProfiles stops the CPU profile and writes the heap profile of
+// -memprofile, if set. It is called once converting is done, including when
+// exiting on an error, so a failing run can be profiled too.
+func stopProfiles() {
+	if cpuProfile != nil {
+		pprof.StopCPUProfile()
+		cpuProfile.Close()
+		cpuProfile = nil
+	}
+
+	if *fMemProfile == "" {
+		return
+	}
+	f, err := os.Create(*fMemProfile)
+	if err != nil {
+		warnf("can't write memory profile: %v", err)
+		return
+	}
+	defer f.Close()
+
+	// only live objects are counted as in use, allocations are counted
+	// either way
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		warnf("can't write memory profile: %v", err)
+	}
+	*fMemProfile = ""
+}
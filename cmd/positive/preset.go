@@ -0,0 +1,123 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// config is the format of the config file, holding named presets of convert
+// flag values, in JSON or TOML
+type config struct {
+	Presets map[string]map[string]any `json:"presets"`
+}
+
+// configPath returns the config file to read presets from, either -config
+// or, in the user config directory, config.toml if it exists or else
+// config.json.
+func configPath() (string, error) {
+	if *fConfig != "" {
+		return *fConfig, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "positive", "config.toml")
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	return filepath.Join(dir, "positive", "config.json"), nil
+}
+
+// readConfig reads the config file at path, in TOML if its extension is
+// .toml, and JSON otherwise.
+func readConfig(path string) (config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config{}, err
+	}
+
+	var c config
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		c, err = parseTOML(data)
+	} else {
+		err = json.Unmarshal(data, &c)
+	}
+	if err != nil {
+		return config{}, fmt.Errorf("%v: %v", path, err)
+	}
+	return c, nil
+}
+
+// applyPreset sets the flags of fs from the named preset, except those set
+// on the command line, which take precedence.
+func applyPreset(fs *flag.FlagSet, name string) error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	c, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+	preset, ok := c.Presets[name]
+	if !ok {
+		var names []string
+		for k := range c.Presets {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown preset %q, options are %v", name, names)
+	}
+
+	values := make(map[string]string)
+	for k, v := range preset {
+		switch v := v.(type) {
+		case string:
+			values[k] = v
+		case float64:
+			values[k] = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			values[k] = strconv.FormatBool(v)
+		default:
+			return fmt.Errorf("preset %v: invalid value for %v: %v", name, k, v)
+		}
+	}
+	if err := setFlags(fs, values); err != nil {
+		return fmt.Errorf("preset %v: %v", name, err)
+	}
+	return nil
+}
+
+// setFlags sets the flags of fs to values, except those set on the command
+// line, which take precedence.
+func setFlags(fs *flag.FlagSet, values map[string]string) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	for k, v := range values {
+		if k == "preset" || k == "config" || k == "from-recipe" || fs.Lookup(k) == nil {
+			return fmt.Errorf("invalid flag %q", k)
+		}
+		if set[k] {
+			continue
+		}
+		if err := fs.Set(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package main
+
+import (
+	_ "embed"
+	"flag"
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/djfritz/positive"
+)
+
+//go:embed preview.html
+var previewHTML string
+
+// A slider adjusts a numeric convert flag in the preview.
+type slider struct {
+	Name, Label    string
+	Min, Max, Step float64
+	Value          string
+}
+
+// convert flags adjusted by sliders in the preview
+var sliders = []slider{
+	{Name: "ev", Label: "Exposure (stops)", Min: -3, Max: 3, Step: 0.1},
+	{Name: "highlights", Label: "Highlight recovery", Min: 0, Max: 1, Step: 0.05},
+	{Name: "shadows", Label: "Shadow recovery", Min: 0, Max: 1, Step: 0.05},
+	{Name: "midtone", Label: "Midtone gamma", Min: 0.3, Max: 3, Step: 0.05},
+	{Name: "contrast", Label: "Contrast", Min: -1, Max: 1, Step: 0.05},
+	{Name: "temp", Label: "Temperature", Min: -100, Max: 100, Step: 1},
+	{Name: "tint", Label: "Tint", Min: -100, Max: 100, Step: 1},
+	{Name: "tupper", Label: "Highlight threshold (pixels)", Min: 0, Max: 1000, Step: 1},
+	{Name: "tlower", Label: "Shadow threshold (pixels)", Min: 0, Max: 1000, Step: 1},
+}
+
+// previewCmd serves a web page previewing the conversion of a negative as
+// its settings are adjusted, and saves the chosen settings to a recipe.
+func previewCmd(args []string) {
+	fs := flag.NewFlagSet("preview", flag.ExitOnError)
+	fAddr := fs.String("addr", "localhost:8080", "Address to serve the preview on")
+	fSize := fs.Int("size", 1200, "Longest side of the preview in pixels")
+	fRecipe := fs.String("recipe", "", "Recipe file to save the chosen settings to, next to the input by default")
+	fOutput := fs.String("output", "", "Output to record in the recipe, so -from-recipe converts to it without arguments")
+	fGamma := fs.String("gamma", "none", "Initial gamma profile")
+	fProfiles := fs.String("profile-file", "", "Load additional gamma profiles from the given JSON file")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: positive preview [flags] <input>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	input := fs.Arg(0)
+	if *fRecipe == "" {
+		*fRecipe = recipePath(input)
+	}
+
+	if err := loadProfiles(*fProfiles); err != nil {
+		fatal(inputError(err))
+	}
+	if _, err := profile(*fGamma); err != nil {
+		fatal(usageError(err))
+	}
+
+	m, err := decode(input)
+	if err != nil {
+		fatal(inputError(err))
+	}
+	// the thresholds are pixel counts of the full resolution input, scaled
+	// to the fewer pixels of the preview
+	full := m.Bounds()
+	if full.Dx() > *fSize || full.Dy() > *fSize {
+		w, h := fit(full.Dx(), full.Dy(), *fSize, *fSize)
+		m = positive.Resize(m, w, h, 0)
+	}
+	scale := float64(m.Bounds().Dx()*m.Bounds().Dy()) / float64(full.Dx()*full.Dy())
+	base, _ := positive.EstimateBase(m)
+
+	page := struct {
+		Input     string
+		Profiles  []string
+		Gamma     string
+		BaseColor string
+		Tones     []positive.Tone
+		Tone      string
+		Looks     []positive.Look
+		Sliders   []slider
+	}{
+		Input:    input,
+		Profiles: profileNames(),
+		Gamma:    *fGamma,
+		Tones:    []positive.Tone{positive.ToneLinear, positive.ToneSoft, positive.TonePunchy},
+		Tone:     string(positive.ToneLinear),
+		Looks:    positive.Looks(),
+	}
+	for _, s := range sliders {
+		s.Value = convertFlags.Lookup(s.Name).DefValue
+		page.Sliders = append(page.Sliders, s)
+	}
+	tmpl := template.Must(template.New("preview").Parse(previewHTML))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if err := tmpl.Execute(w, page); err != nil {
+			errorf("%v", err)
+		}
+	})
+
+	mux.HandleFunc("/render", func(w http.ResponseWriter, r *http.Request) {
+		o, _, err := previewOptions(r.URL.Query(), base, scale)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// a slider moved again before the last render finished cancels it
+		p, err := positive.ProcessContext(r.Context(), m, o)
+		if r.Context().Err() != nil {
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		jpeg.Encode(w, p, &jpeg.Options{Quality: 90})
+	})
+
+	// the film mask color around a point of the negative
+	mux.HandleFunc("/sample", func(w http.ResponseWriter, r *http.Request) {
+		x, _ := strconv.Atoi(r.URL.Query().Get("x"))
+		y, _ := strconv.Atoi(r.URL.Query().Get("y"))
+		b := m.Bounds()
+		c := positive.SampleRect(m, image.Rect(x-2, y-2, x+3, y+3).Add(b.Min))
+		cr, cg, cb, _ := c.RGBA()
+		fmt.Fprintf(w, "%v,%v,%v", cr, cg, cb)
+	})
+
+	mux.HandleFunc("/save", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, flags, err := previewOptions(r.PostForm, base, 1)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rec := &recipe{Input: input, Output: *fOutput, Flags: flags}
+		if err := rec.write(*fRecipe); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		infof("saved %v", *fRecipe)
+		if *fOutput == "" {
+			fmt.Fprintf(w, "saved %v, convert with -from-recipe %v %v <output>", *fRecipe, *fRecipe, input)
+			return
+		}
+		fmt.Fprintf(w, "saved %v, convert with -from-recipe %v", *fRecipe, *fRecipe)
+	})
+
+	infof("previewing %v at http://%v", input, *fAddr)
+	if err := http.ListenAndServe(*fAddr, mux); err != nil {
+		fatal(err)
+	}
+}
+
+// previewOptions returns the conversion options chosen in the preview, and
+// the convert flags that select them. Without a film mask color, base is
+// used, as estimated from the preview, and the flags estimate it from each
+// input. The pixel count thresholds of the options are scaled by scale, the
+// fraction of the pixels of the input in the preview, while the flags keep
+// those of the input.
+func previewOptions(v url.Values, base color.Color, scale float64) (positive.Options, map[string]string, error) {
+	flags := map[string]string{
+		"gamma": v.Get("gamma"),
+		"tone":  v.Get("tone"),
+	}
+	if flags["tone"] == "" {
+		flags["tone"] = string(positive.ToneLinear)
+	}
+	if look := v.Get("look"); look != "" {
+		flags["look"] = look
+	}
+	num := make(map[string]float64)
+	for _, s := range sliders {
+		value := v.Get(s.Name)
+		if value == "" {
+			value = convertFlags.Lookup(s.Name).DefValue
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return positive.Options{}, nil, fmt.Errorf("invalid %v %q", s.Name, value)
+		}
+		flags[s.Name] = value
+		num[s.Name] = f
+	}
+
+	p, err := profile(flags["gamma"])
+	if err != nil {
+		return positive.Options{}, nil, err
+	}
+
+	// the defaults of convert for everything without a control
+	o := positive.DefaultOptions()
+	o.Gamma, o.Matrix = p.Gamma, p.Matrix
+	o.FilmLight, o.Light = p.Light, p.Light
+	o.Upper = int(math.Round(num["tupper"] * scale))
+	o.Lower = int(math.Round(num["tlower"] * scale))
+	o.EV = num["ev"]
+	o.Highlights, o.Shadows = num["highlights"], num["shadows"]
+	o.Midtone = num["midtone"]
+	o.Contrast = num["contrast"]
+	o.Temp, o.Tint = num["temp"], num["tint"]
+	o.Tone = positive.Tone(flags["tone"])
+	o.Look = positive.Look(flags["look"])
+
+	if c := v.Get("base-color"); c != "" {
+		b, err := parseColor(c)
+		if err != nil {
+			return positive.Options{}, nil, err
+		}
+		o.Base = b
+		flags["base-color"] = c
+		flags["auto-base"] = "false"
+	} else {
+		o.Base = base
+		flags["auto-base"] = "true"
+	}
+	return o, flags, nil
+}
@@ -0,0 +1,27 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+// TestPreviewThresholds checks the thresholds are scaled to the preview,
+// while the flags saved keep those of the input.
+func TestPreviewThresholds(t *testing.T) {
+	v := url.Values{"gamma": {"none"}, "tupper": {"100"}, "tlower": {"10"}}
+	o, flags, err := previewOptions(v, nil, 0.25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Upper != 25 || o.Lower != 3 {
+		t.Errorf("thresholds %v, %v, want 25, 3", o.Upper, o.Lower)
+	}
+	if flags["tupper"] != "100" || flags["tlower"] != "10" {
+		t.Errorf("threshold flags %v, %v, want 100, 10", flags["tupper"], flags["tlower"])
+	}
+}
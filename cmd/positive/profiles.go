@@ -0,0 +1,161 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/djfritz/positive"
+)
+
+// origin of each gamma profile loaded from a file, by name. Other profiles
+// are built in.
+var profileOrigin = map[string]string{}
+
+// profilesCmd lists the available gamma profiles.
+func profilesCmd(args []string) {
+	fs := flag.NewFlagSet("profiles", flag.ExitOnError)
+	fProfiles := fs.String("profile-file", "", "Load additional gamma profiles from the given JSON file")
+	fNames := fs.Bool("names", false, "Only list the profile names")
+	fJSON := fs.Bool("json", false, "List the profiles as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: positive profiles [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if err := loadProfiles(*fProfiles); err != nil {
+		fatal(inputError(err))
+	}
+
+	switch {
+	case *fNames:
+		for _, name := range profileNames() {
+			fmt.Println(name)
+		}
+	case *fJSON:
+		type listing struct {
+			positive.Profile
+			Origin string `json:"origin"`
+		}
+		var ps []listing
+		for _, name := range profileNames() {
+			ps = append(ps, listing{positive.Profiles[name], origin(name)})
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		if err := enc.Encode(ps); err != nil {
+			fatal(err)
+		}
+	default:
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tR\tG\tB\tLIGHT\tORIGIN\tSOURCE\tNOTES")
+		for _, name := range profileNames() {
+			p := positive.Profiles[name]
+			light := p.Light
+			if light == "" {
+				light = positive.Daylight
+			}
+			fmt.Fprintf(w, "%v\t%.3f\t%.3f\t%.3f\t%v\t%v\t%v\t%v\n", name, p.R, p.G, p.B, light, origin(name), p.Source, p.Notes)
+		}
+		w.Flush()
+	}
+}
+
+// origin returns where the named gamma profile came from: built in, or the
+// file it was loaded from.
+func origin(name string) string {
+	if o, ok := profileOrigin[name]; ok {
+		return o
+	}
+	return "built in"
+}
+
+// profileNames returns the sorted names of all gamma profiles
+func profileNames() []string {
+	var names []string
+	for k := range positive.Profiles {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// profile returns the named gamma profile, or a blend of profiles given as
+// name:weight,name:weight.
+func profile(spec string) (positive.Profile, error) {
+	if !strings.Contains(spec, ":") {
+		p, err := positive.LookupProfile(spec)
+		if err != nil {
+			return p, fmt.Errorf("%w, options are %v", err, profileNames())
+		}
+		return p, nil
+	}
+
+	var ps []positive.Profile
+	var weights []float64
+	for _, f := range strings.Split(spec, ",") {
+		name, weight, _ := strings.Cut(f, ":")
+		p, err := positive.LookupProfile(name)
+		if err != nil {
+			return p, fmt.Errorf("%w, options are %v", err, profileNames())
+		}
+		w, err := strconv.ParseFloat(weight, 64)
+		if err != nil {
+			return positive.Profile{}, fmt.Errorf("invalid weight for gamma profile %v: %q", name, weight)
+		}
+		ps = append(ps, p)
+		weights = append(weights, w)
+	}
+	return positive.Blend(ps, weights)
+}
+
+// loadProfiles loads user gamma profiles from the default profile file, if it
+// exists, and then from the given file, if any.
+func loadProfiles(file string) error {
+	if dir, err := os.UserConfigDir(); err == nil {
+		path := filepath.Join(dir, "positive", "profiles.json")
+		if _, err := os.Stat(path); err == nil {
+			if err := loadProfileFile(path); err != nil {
+				return err
+			}
+		}
+	}
+
+	if file != "" {
+		return loadProfileFile(file)
+	}
+	return nil
+}
+
+// loadProfileFile loads the gamma profiles in path, recording it as the
+// origin of the profiles it adds or replaces.
+func loadProfileFile(path string) error {
+	before := make(map[string]positive.Profile, len(positive.Profiles))
+	for k, v := range positive.Profiles {
+		before[k] = v
+	}
+
+	if err := positive.LoadProfiles(path); err != nil {
+		return err
+	}
+
+	for k, v := range positive.Profiles {
+		if p, ok := before[k]; !ok || !reflect.DeepEqual(p, v) {
+			profileOrigin[k] = path
+		}
+	}
+	return nil
+}
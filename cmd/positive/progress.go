@@ -0,0 +1,208 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// relative time taken by each stage of converting an image, for estimating
+// the time remaining of a single conversion
+const (
+	readWeight    = 2
+	processWeight = 5
+	writeWeight   = 2
+
+	imageWeight = readWeight + processWeight + writeWeight
+)
+
+// width of the progress bar, in characters
+const barWidth = 30
+
+// progress bar shown while converting, or nil if not shown
+var bar *progress
+
+// A progress bar drawn on the last line of stderr, with the estimated time
+// remaining. It is redrawn every second, so long stages show they are still
+// running. The methods of a nil progress do nothing.
+type progress struct {
+	mu    sync.Mutex
+	start time.Time
+	total float64
+	done  float64
+	unit  string
+
+	// current stage, its weight once done, and the fraction of it done
+	label  string
+	weight float64
+	part   float64
+
+	stop    chan struct{}
+	stopped bool
+}
+
+// showProgress reports whether to show progress: if -progress is set, not
+// logging only warnings or JSON, and stderr is a terminal.
+func showProgress() bool {
+	if !*fProgress || *fQuiet || *fJSONLog {
+		return false
+	}
+	fi, err := os.Stderr.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// newProgress starts a progress bar of total work, counted in unit if not
+// empty.
+func newProgress(total float64, unit string) *progress {
+	p := &progress{
+		start: time.Now(),
+		total: total,
+		unit:  unit,
+		stop:  make(chan struct{}),
+	}
+	go func() {
+		t := time.NewTicker(time.Second)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				p.draw()
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+	p.draw()
+	return p
+}
+
+// stage completes the current stage and starts the next, of the given
+// weight.
+func (p *progress) stage(label string, weight float64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.done += p.weight
+	p.label, p.weight, p.part = label, weight, 0
+	p.mu.Unlock()
+	p.draw()
+}
+
+// advance completes n work, labeling the bar with what was done.
+func (p *progress) advance(label string, n float64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.done += n
+	p.label = label
+	p.mu.Unlock()
+	p.draw()
+}
+
+// fraction sets the fraction of the current stage done, for stages that
+// report their own progress.
+func (p *progress) fraction(f float64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.part = f
+	p.mu.Unlock()
+	p.draw()
+}
+
+// grow adds n work to the total, such as when an input is split into
+// frames.
+func (p *progress) grow(n float64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.total += n
+	p.mu.Unlock()
+	p.draw()
+}
+
+// draw redraws the bar.
+func (p *progress) draw() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return
+	}
+
+	done := p.done + p.part*p.weight
+	frac := 0.0
+	if p.total > 0 {
+		frac = done / p.total
+	}
+	if frac > 1 {
+		frac = 1
+	}
+	n := int(frac * barWidth)
+	line := fmt.Sprintf("[%v%v] %3.0f%%", strings.Repeat("=", n), strings.Repeat(" ", barWidth-n), frac*100)
+	if p.unit != "" {
+		line += fmt.Sprintf(" %v/%v %v", p.done, p.total, p.unit)
+	}
+
+	elapsed := time.Since(p.start)
+	line += fmt.Sprintf(" elapsed %v", elapsed.Round(time.Second))
+	if done > 0 && frac < 1 {
+		eta := time.Duration(float64(elapsed) / done * (p.total - done))
+		line += fmt.Sprintf(" ETA %v", eta.Round(time.Second))
+	}
+	if p.label != "" {
+		line += " " + p.label
+	}
+
+	// clear to the end of the line, in case the last was longer
+	fmt.Fprintf(os.Stderr, "\r%v\x1b[K", line)
+}
+
+// clear erases the bar, so a log message can be written in its place.
+func (p *progress) clear() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.stopped {
+		fmt.Fprint(os.Stderr, "\r\x1b[K")
+	}
+}
+
+// finish stops redrawing the bar and erases it.
+func (p *progress) finish() {
+	if p == nil {
+		return
+	}
+	p.clear()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.stopped {
+		p.stopped = true
+		close(p.stop)
+	}
+}
+
+// stage starts a stage of a single conversion. Batches show progress by
+// file instead.
+func stage(label string, weight float64) {
+	debugf("%v", label)
+	if !batchMode() {
+		bar.stage(label, weight)
+	}
+}
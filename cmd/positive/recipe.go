@@ -0,0 +1,153 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/djfritz/positive"
+)
+
+// recipe records everything needed to repeat a conversion exactly: the flags
+// used and the values computed from the input.
+type recipe struct {
+	Input  string            `json:"input"`
+	Output string            `json:"output"`
+	Flags  map[string]string `json:"flags"`
+
+	// film mask color as 16-bit r,g,b values
+	Base   string           `json:"base,omitempty"`
+	Levels *positive.Levels `json:"levels,omitempty"`
+
+	// skew angle straightened, and frame split from the input
+	Skew  *float64         `json:"skew,omitempty"`
+	Frame *image.Rectangle `json:"frame,omitempty"`
+}
+
+// flags that don't affect the conversion of a single input, such as those
+// writing reports, proofs, and profiles besides the output, or are replaced by
+// values computed when recording a recipe, aren't recorded
+var recipeSkip = map[string]bool{
+	"from-recipe":    true,
+	"save-recipe":    true,
+	"preset":         true,
+	"config":         true,
+	"manifest":       true,
+	"outdir":         true,
+	"out":            true,
+	"force":          true,
+	"watch":          true,
+	"state":          true,
+	"retry-failed":   true,
+	"watch-interval": true,
+	"v":              true,
+	"quiet":          true,
+	"json-log":       true,
+	"progress":       true,
+	"workers":        true,
+	"mem":            true,
+	"split":          true,
+	"roll":           true,
+	"reference":      true,
+	"levels":         true,
+	"base":           true,
+	"base-rect":      true,
+	"save-base":      true,
+	"save-levels":    true,
+	"proof":          true,
+	"thumbs":         true,
+	"histogram":      true,
+	"clipping":       true,
+	"stats":          true,
+	"if-positive":    true,
+	"threads":        true,
+	"tiled":          true,
+	"cpuprofile":     true,
+	"memprofile":     true,
+}
+
+// recipe to reproduce, loaded by loadRecipe
+var fromRecipe *recipe
+
+// newRecipe starts a recipe for converting input to output, with the flags
+// of the conversion overridden by f.
+func newRecipe(input, output string, f frame) *recipe {
+	r := &recipe{
+		Input:  input,
+		Output: output,
+		Flags:  make(map[string]string),
+	}
+	convertFlags.VisitAll(func(fl *flag.Flag) {
+		if !recipeSkip[fl.Name] {
+			r.Flags[fl.Name] = fl.Value.String()
+		}
+	})
+
+	if f.Gamma != "" {
+		r.Flags["gamma"] = f.Gamma
+	}
+	r.Flags["rotate"] = strconv.Itoa(f.rotation())
+	r.Flags["crop"] = f.crop()
+	return r
+}
+
+// recipePath returns the path of the recipe sidecar written for output
+func recipePath(output string) string {
+	return strings.TrimSuffix(output, filepath.Ext(output)) + ".positive.json"
+}
+
+// save writes r next to its output
+func (r *recipe) save() error {
+	return r.write(recipePath(r.Output))
+}
+
+// write writes r to path
+func (r *recipe) write(path string) error {
+	data, err := json.MarshalIndent(r, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// loadRecipe loads the -from-recipe recipe, setting the flags of fs from it
+// except those set on the command line.
+func loadRecipe(fs *flag.FlagSet) error {
+	data, err := os.ReadFile(*fFromRecipe)
+	if err != nil {
+		return err
+	}
+
+	var r recipe
+	if err := json.Unmarshal(data, &r); err != nil {
+		return fmt.Errorf("%v: %v", *fFromRecipe, err)
+	}
+
+	// recipes saved by older versions may record flags skipped now
+	flags := make(map[string]string)
+	for k, v := range r.Flags {
+		if !recipeSkip[k] {
+			flags[k] = v
+		}
+	}
+	if r.Base != "" {
+		flags["base-color"] = r.Base
+		flags["auto-base"] = "false"
+	}
+	if err := setFlags(fs, flags); err != nil {
+		return fmt.Errorf("%v: %v", *fFromRecipe, err)
+	}
+
+	fromRecipe = &r
+	return nil
+}
@@ -0,0 +1,41 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestLoadRecipe checks recipes with a film mask color but no flags load, and
+// flags that aren't recorded any more are ignored.
+func TestLoadRecipe(t *testing.T) {
+	defer func(path string) { *fFromRecipe, fromRecipe = path, nil }(*fFromRecipe)
+
+	for _, data := range []string{
+		`{"input":"in.tif","output":"out.tif","base":"1,2,3"}`,
+		`{"input":"in.tif","output":"out.tif","base":"1,2,3","flags":{"cpuprofile":"cpu.out"}}`,
+	} {
+		path := filepath.Join(t.TempDir(), "r.positive.json")
+		if err := os.WriteFile(path, []byte(data), 0o666); err != nil {
+			t.Fatal(err)
+		}
+		*fFromRecipe = path
+
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		base := fs.String("base-color", "", "")
+		auto := fs.Bool("auto-base", true, "")
+		cpu := fs.String("cpuprofile", "", "")
+		if err := loadRecipe(fs); err != nil {
+			t.Fatalf("%s: %v", data, err)
+		}
+		if *base != "1,2,3" || *auto || *cpu != "" {
+			t.Errorf("%s: base-color %q, auto-base %v, cpuprofile %q", data, *base, *auto, *cpu)
+		}
+	}
+}
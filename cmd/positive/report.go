@@ -0,0 +1,75 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/djfritz/positive"
+)
+
+// clipReport is the JSON form of a clipping report for one output
+type clipReport struct {
+	File string `json:"file"`
+	positive.Clip
+	BlackPercent [3]float64 `json:"black_percent"`
+	WhitePercent [3]float64 `json:"white_percent"`
+}
+
+// reportClipping prints the clipping of output, in the -clipping format.
+func reportClipping(output string, c positive.Clip) error {
+	b, w := c.BlackPercent(), c.WhitePercent()
+
+	if *fClipping == "json" {
+		data, err := json.Marshal(clipReport{
+			File:         output,
+			Clip:         c,
+			BlackPercent: b,
+			WhitePercent: w,
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	infof("%v: clipped black r %.2f%% g %.2f%% b %.2f%%, white r %.2f%% g %.2f%% b %.2f%%",
+		output, b[0], b[1], b[2], w[0], w[1], w[2])
+	return nil
+}
+
+// statsReport is the JSON form of the statistics of one conversion
+type statsReport struct {
+	Input  string `json:"input"`
+	Output string `json:"output"`
+	positive.Stats
+}
+
+// reportStats prints the statistics of converting input to output, in the
+// -stats format.
+func reportStats(input, output string, s positive.Stats) error {
+	if *fStats == "json" {
+		data, err := json.Marshal(statsReport{input, output, s})
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	if l := s.Levels; l != nil {
+		infof("%v: levels r %v-%v g %v-%v b %v-%v", input, l.RMin, l.RMax, l.GMin, l.GMax, l.BMin, l.BMax)
+	}
+	if b := s.Base; b != nil {
+		infof("%v: film base r %v g %v b %v", input, b[0], b[1], b[2])
+	}
+	bl, w := s.Clip.BlackPercent(), s.Clip.WhitePercent()
+	infof("%v: clipped black r %.2f%% g %.2f%% b %.2f%%, white r %.2f%% g %.2f%% b %.2f%%",
+		input, bl[0], bl[1], bl[2], w[0], w[1], w[2])
+	return nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/djfritz/positive"
+)
+
+// sampleCmd prints the trimmed mean 16-bit r,g,b color of a film mask sample.
+func sampleCmd(args []string) {
+	fs := flag.NewFlagSet("sample", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: positive sample <mask sample>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	c, err := sample(fs.Arg(0))
+	if err != nil {
+		fatal(inputError(err))
+	}
+
+	r, g, b, _ := c.RGBA()
+	fmt.Printf("%v,%v,%v\n", r, g, b)
+}
+
+// A baseFile records a sampled film mask color for reuse, so the sample
+// doesn't need to be decoded and averaged again for every frame.
+type baseFile struct {
+	R      uint16 `json:"r"`
+	G      uint16 `json:"g"`
+	B      uint16 `json:"b"`
+	Source string `json:"source,omitempty"`
+}
+
+// Calculates the trimmed mean r,g,b colors of the given mask sample file. JSON
+// files written by saveBase are read directly.
+func sample(sample string) (color.Color, error) {
+	if strings.ToLower(filepath.Ext(sample)) == ".json" {
+		data, err := os.ReadFile(sample)
+		if err != nil {
+			return nil, err
+		}
+
+		var b baseFile
+		if err := json.Unmarshal(data, &b); err != nil {
+			return nil, fmt.Errorf("%v: %v", sample, err)
+		}
+		return color.RGBA64{R: b.R, G: b.G, B: b.B, A: 0xffff}, nil
+	}
+
+	m, err := decode(sample)
+	if err != nil {
+		return nil, err
+	}
+
+	return positive.Sample(m), nil
+}
+
+// saveBase writes the sampled film mask color c to path as JSON
+func saveBase(path string, c color.Color, source string) error {
+	r, g, b, _ := c.RGBA()
+	data, err := json.MarshalIndent(baseFile{
+		R:      uint16(r),
+		G:      uint16(g),
+		B:      uint16(b),
+		Source: filepath.Base(source),
+	}, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,551 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// job states
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+// A job is a conversion submitted to the job service, as the Job message of
+// api/positive.proto.
+type job struct {
+	ID     string            `json:"id"`
+	Input  string            `json:"input"`
+	Output string            `json:"output"`
+	Flags  map[string]string `json:"flags,omitempty"`
+	State  string            `json:"state"`
+	Error  string            `json:"error,omitempty"`
+
+	mu sync.Mutex
+
+	// the last JSON log lines of the conversion, streamed as its progress,
+	// after dropped earlier ones
+	events  [][]byte
+	dropped int
+
+	// closed and replaced when events or the state change
+	changed chan struct{}
+}
+
+// update changes j under its lock and wakes those watching it.
+func (j *job) update(f func()) {
+	j.mu.Lock()
+	f()
+	close(j.changed)
+	j.changed = make(chan struct{})
+	j.mu.Unlock()
+}
+
+// most log lines kept of each job, the oldest half dropped when reached
+const maxJobEvents = 1024
+
+// log appends a log line of the conversion to the progress of j.
+func (j *job) log(line []byte) {
+	j.update(func() {
+		if len(j.events) == maxJobEvents {
+			n := copy(j.events, j.events[maxJobEvents/2:])
+			clear(j.events[n:])
+			j.events = j.events[:n]
+			j.dropped += maxJobEvents / 2
+		}
+		j.events = append(j.events, line)
+	})
+}
+
+// follow calls send with the log lines of j as they are logged, skipping
+// those dropped before they were sent, until j finishes or ctx is done.
+func (j *job) follow(ctx context.Context, send func(lines [][]byte) error) error {
+	var sent int
+	for {
+		j.mu.Lock()
+		sent = max(sent, j.dropped)
+		lines := append([][]byte(nil), j.events[sent-j.dropped:]...)
+		finished := j.finished()
+		changed := j.changed
+		j.mu.Unlock()
+
+		if err := send(lines); err != nil {
+			return err
+		}
+		sent += len(lines)
+		if finished {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// finished reports whether j is done or failed. j must be locked.
+func (j *job) finished() bool {
+	return j.State == jobDone || j.State == jobFailed
+}
+
+// A jobServer runs the conversions submitted to it, each by running the
+// convert command, up to workers at once.
+type jobServer struct {
+	// directory job inputs and outputs must be within
+	root string
+
+	// most jobs remembered, the oldest finished ones forgotten first
+	max int
+
+	mu    sync.Mutex
+	jobs  map[string]*job
+	order []string // IDs of jobs, oldest first
+	next  int
+
+	queue chan *job
+}
+
+// A jobError is an error of the job API, with the HTTP status it is returned
+// with.
+type jobError struct {
+	status int
+	msg    string
+}
+
+func (e *jobError) Error() string { return e.msg }
+
+// serveCmd serves the job API of api/positive.proto over gRPC, and over HTTP
+// with JSON.
+func serveCmd(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	fAddr := fs.String("addr", "localhost:8081", "Address to serve the job API on")
+	fWorkers := fs.Int("workers", runtime.GOMAXPROCS(0), "Number of jobs to convert concurrently")
+	fRoot := fs.String("root", ".", "Directory job inputs and outputs must be within")
+	fMaxJobs := fs.Int("max-jobs", 1000, "Number of jobs to remember, forgetting the oldest finished ones")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: positive serve [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	exe, err := os.Executable()
+	if err != nil {
+		fatal(err)
+	}
+
+	if *fMaxJobs < 1 {
+		fatal(fmt.Errorf("max jobs must be at least 1"))
+	}
+	root, err := filepath.Abs(*fRoot)
+	if err == nil {
+		root, err = filepath.EvalSymlinks(root)
+	}
+	if err != nil {
+		fatal(err)
+	}
+
+	s := &jobServer{
+		root:  root,
+		max:   *fMaxJobs,
+		jobs:  make(map[string]*job),
+		queue: make(chan *job, 1024),
+	}
+	for i := 0; i < *fWorkers; i++ {
+		go func() {
+			for j := range s.queue {
+				run(exe, j)
+			}
+		}()
+	}
+
+	// gRPC clients connect with HTTP/2 without TLS
+	srv := &http.Server{Addr: *fAddr, Handler: s, Protocols: new(http.Protocols)}
+	srv.Protocols.SetHTTP1(true)
+	srv.Protocols.SetUnencryptedHTTP2(true)
+
+	infof("serving the job API for %v at http://%v", root, *fAddr)
+	if err := srv.ListenAndServe(); err != nil {
+		fatal(err)
+	}
+}
+
+// ServeHTTP routes the requests of the job API.
+func (s *jobServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if isGRPC(r) {
+		s.serveGRPC(w, r)
+		return
+	}
+
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if path[0] != "jobs" || len(path) > 3 {
+		http.NotFound(w, r)
+		return
+	}
+
+	if len(path) == 1 {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.submit(w, r)
+		return
+	}
+
+	j, ok := s.job(path[1])
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch {
+	case len(path) == 2:
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		writeJSON(w, j)
+	case path[2] == "progress":
+		watchJob(w, r, j)
+	case path[2] == "result":
+		jobResult(w, j)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// job returns the job with the given ID, if it is remembered.
+func (s *jobServer) job(id string) (*job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	return j, ok
+}
+
+// A submitRequest is a conversion submitted to the job API, as the
+// SubmitRequest message of api/positive.proto.
+type submitRequest struct {
+	Input  string            `json:"input"`
+	Output string            `json:"output"`
+	Flags  map[string]string `json:"flags"`
+}
+
+// submit queues the job in the request.
+func (s *jobServer) submit(w http.ResponseWriter, r *http.Request) {
+	var req submitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	j, err := s.add(req)
+	if err != nil {
+		http.Error(w, err.Error(), err.status)
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	writeJSON(w, j)
+}
+
+// add queues a job converting req.
+func (s *jobServer) add(req submitRequest) (*job, *jobError) {
+	if req.Input == "" || req.Output == "" {
+		return nil, &jobError{http.StatusBadRequest, "input and output are required"}
+	}
+	for k := range req.Flags {
+		if !jobFlags[k] {
+			return nil, &jobError{http.StatusBadRequest, fmt.Sprintf("flag %q can't be set by a job", k)}
+		}
+	}
+	input, err := s.within(req.Input)
+	if err != nil {
+		return nil, &jobError{http.StatusForbidden, err.Error()}
+	}
+	output, err := s.within(req.Output)
+	if err != nil {
+		return nil, &jobError{http.StatusForbidden, err.Error()}
+	}
+
+	s.mu.Lock()
+	if !s.forget() {
+		s.mu.Unlock()
+		return nil, &jobError{http.StatusServiceUnavailable, "too many jobs unfinished"}
+	}
+	s.next++
+	j := &job{
+		ID:      strconv.Itoa(s.next),
+		Input:   input,
+		Output:  output,
+		Flags:   req.Flags,
+		State:   jobQueued,
+		changed: make(chan struct{}),
+	}
+	s.jobs[j.ID] = j
+	s.order = append(s.order, j.ID)
+	s.mu.Unlock()
+
+	select {
+	case s.queue <- j:
+	default:
+		j.update(func() {
+			j.State, j.Error = jobFailed, "too many jobs queued"
+		})
+	}
+
+	infof("job %v: %v -> %v", j.ID, j.Input, j.Output)
+	return j, nil
+}
+
+// within returns path, relative to the root of s or absolute, resolving
+// symbolic links, or an error if it isn't within the root. The last element
+// of path needn't exist, for outputs.
+func (s *jobServer) within(path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(s.root, path)
+	}
+	dir, file := filepath.Split(filepath.Clean(path))
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	path = filepath.Join(dir, file)
+	if p, err := filepath.EvalSymlinks(path); err == nil {
+		path = p
+	}
+
+	rel, err := filepath.Rel(s.root, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%v is not within %v", path, s.root)
+	}
+	return path, nil
+}
+
+// forget makes room for another job by forgetting the oldest finished jobs
+// beyond the most s remembers, reporting false if there are too many
+// unfinished. s must be locked.
+func (s *jobServer) forget() bool {
+	for i := 0; len(s.jobs) >= s.max && i < len(s.order); {
+		j := s.jobs[s.order[i]]
+		j.mu.Lock()
+		finished := j.finished()
+		j.mu.Unlock()
+		if !finished {
+			i++
+			continue
+		}
+		delete(s.jobs, j.ID)
+		s.order = append(s.order[:i], s.order[i+1:]...)
+	}
+	return len(s.jobs) < s.max
+}
+
+// flags jobs may set, which only take values: flags naming other files to
+// read or write, commands to run, or other conversions than a single input
+// to output can't be set by jobs, as they would reach past the job's input
+// and output
+var jobFlags = map[string]bool{
+	"invert":               true,
+	"gamma":                true,
+	"normalize":            true,
+	"border":               true,
+	"roi":                  true,
+	"base-rect":            true,
+	"base-color":           true,
+	"auto-base":            true,
+	"stack-mode":           true,
+	"deskew":               true,
+	"split":                true,
+	"ir":                   true,
+	"ir-threshold":         true,
+	"dust":                 true,
+	"dust-radius":          true,
+	"slide":                true,
+	"reverse":              true,
+	"if-positive":          true,
+	"bw":                   true,
+	"toning":               true,
+	"ecn2":                 true,
+	"light":                true,
+	"film-light":           true,
+	"push":                 true,
+	"mode":                 true,
+	"mask":                 true,
+	"tupper":               true,
+	"tlower":               true,
+	"rolloff":              true,
+	"linked":               true,
+	"save-levels":          true,
+	"save-recipe":          true,
+	"gray":                 true,
+	"alpha":                true,
+	"tiled":                true,
+	"force":                true,
+	"format":               true,
+	"proof":                true,
+	"quality":              true,
+	"depth":                true,
+	"dither":               true,
+	"colorspace":           true,
+	"clipping":             true,
+	"stats":                true,
+	"neutral":              true,
+	"awb":                  true,
+	"temp":                 true,
+	"tint":                 true,
+	"ev":                   true,
+	"denoise":              true,
+	"denoise-chroma":       true,
+	"local-contrast":       true,
+	"local-contrast-clip":  true,
+	"local-contrast-tiles": true,
+	"sharpen":              true,
+	"sharpen-radius":       true,
+	"sharpen-threshold":    true,
+	"highlights":           true,
+	"shadows":              true,
+	"midtone":              true,
+	"tone":                 true,
+	"contrast":             true,
+	"look":                 true,
+	"crop":                 true,
+	"resize":               true,
+	"rotate":               true,
+	"flip-h":               true,
+	"flip-v":               true,
+	"threads":              true,
+}
+
+// run converts j with the convert command of the executable exe, recording
+// its log as progress.
+func run(exe string, j *job) {
+	args := []string{"convert", "-json-log", "-v", "-progress=false"}
+	var names []string
+	for k := range j.Flags {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		args = append(args, "-"+k+"="+j.Flags[k])
+	}
+	args = append(args, "--", j.Input, j.Output)
+
+	j.update(func() { j.State = jobRunning })
+
+	cmd := exec.Command(exe, args...)
+	stderr, err := cmd.StderrPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		j.update(func() { j.State, j.Error = jobFailed, err.Error() })
+		return
+	}
+
+	// the last error logged is why the job failed
+	var last string
+	sc := bufio.NewScanner(stderr)
+	for sc.Scan() {
+		line := append([]byte(nil), sc.Bytes()...)
+		var e struct{ Level, Msg string }
+		if json.Unmarshal(line, &e) == nil && e.Level == "ERROR" {
+			last = e.Msg
+		}
+		j.log(line)
+	}
+
+	err = cmd.Wait()
+	j.update(func() {
+		if err == nil {
+			j.State = jobDone
+			return
+		}
+		j.State, j.Error = jobFailed, last
+		if last == "" {
+			j.Error = err.Error()
+		}
+	})
+	infof("job %v: %v", j.ID, j.State)
+}
+
+// watchJob streams the log of j as newline delimited JSON until it finishes
+// or the client goes away.
+func watchJob(w http.ResponseWriter, r *http.Request, j *job) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+
+	j.follow(r.Context(), func(lines [][]byte) error {
+		for _, l := range lines {
+			w.Write(l)
+			w.Write([]byte{'\n'})
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+}
+
+// jobResult writes the output of j, once it is done.
+func jobResult(w http.ResponseWriter, j *job) {
+	f, err := openResult(j)
+	if err != nil {
+		http.Error(w, err.Error(), err.status)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	io.Copy(w, f)
+}
+
+// openResult opens the output of j, once it is done.
+func openResult(j *job) (*os.File, *jobError) {
+	j.mu.Lock()
+	state, output := j.State, j.Output
+	j.mu.Unlock()
+	if state != jobDone {
+		return nil, &jobError{http.StatusConflict, fmt.Sprintf("job is %v", state)}
+	}
+
+	f, err := os.Open(output)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, os.ErrNotExist) {
+			status = http.StatusNotFound
+		}
+		return nil, &jobError{status, err.Error()}
+	}
+	return f, nil
+}
+
+// writeJSON writes v as the JSON response.
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	enc.Encode(v)
+}
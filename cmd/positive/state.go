@@ -0,0 +1,123 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// status of an input in a batch state file
+const (
+	statusStarted = "started"
+	statusDone    = "done"
+	statusFailed  = "failed"
+)
+
+// A batchState records the outcome of each input of a batch in a file, as
+// requested by -state, so an interrupted batch can be resumed. The methods of
+// a nil batchState do nothing.
+type batchState struct {
+	mu   sync.Mutex
+	path string
+
+	// results by absolute input path, with absolute output paths
+	Files map[string]*result `json:"files"`
+}
+
+// result of converting one input
+type result struct {
+	Status string `json:"status"`
+	Output string `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// batch state, loaded from -state, or nil
+var state *batchState
+
+// loadState loads the batch state in path, which is created when first
+// saved if it doesn't exist.
+func loadState(path string) (*batchState, error) {
+	s := &batchState{path: path, Files: make(map[string]*result)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	if s.Files == nil {
+		s.Files = make(map[string]*result)
+	}
+	return s, nil
+}
+
+// lookup returns the result of input recorded by an earlier run, if any.
+func (s *batchState) lookup(input string) (result, bool) {
+	if s == nil {
+		return result{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.Files[key(input)]
+	if !ok {
+		return result{}, false
+	}
+	return *r, true
+}
+
+// set records the result of input and saves the state.
+func (s *batchState) set(input string, r result) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Files[key(input)] = &r
+	return s.save()
+}
+
+// save writes the state to a temporary file and renames it over the state
+// file, so the state file is intact if interrupted.
+func (s *batchState) save() error {
+	data, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// key returns the absolute path of a file recorded in the state, so a batch
+// can be resumed from another directory.
+func key(input string) string {
+	if abs, err := filepath.Abs(input); err == nil {
+		return abs
+	}
+	return input
+}
@@ -0,0 +1,60 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package main
+
+import (
+	"io"
+	"os"
+)
+
+// stdio is the input or output path that reads from stdin or writes to
+// stdout, so conversions can be used in pipelines.
+const stdio = "-"
+
+// stdin holds the input read from stdin, which can only be read once but is
+// decoded again for its metadata and by -roll.
+var stdin []byte
+
+// readStdin returns the input read from stdin, reading it the first time.
+func readStdin() ([]byte, error) {
+	if stdin == nil {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		stdin = data
+	}
+	return stdin, nil
+}
+
+// checkStdio exits if stdin or stdout is used with flags that need a file
+// name, or that print to stdout along with the image.
+func checkStdio() {
+	for _, arg := range convertFlags.Args() {
+		if arg == stdio && batchMode() {
+			fatalf("-outdir and -out convert files, not stdin")
+		}
+	}
+	if batchMode() {
+		return
+	}
+
+	if convertFlags.Arg(0) == stdio && *fTiled {
+		fatalf("-tiled reads the input more than once and can't read stdin")
+	}
+
+	if convertFlags.Arg(1) != stdio {
+		return
+	}
+	switch {
+	case *fSplit:
+		fatalf("-split writes numbered outputs and can't write to stdout")
+	case *fProof || *fThumbs != "" || *fSaveLevels || *fSaveRecipe:
+		fatalf("-proof, -thumbs, -save-levels, and -save-recipe write next to the output and can't be used writing to stdout")
+	case *fClipping == "json" || *fStats == "json" || *fHistogram == "text":
+		fatalf("-clipping json, -stats json, and -histogram text print to stdout along with the image")
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/djfritz/positive"
+)
+
+// A matrixProfile is a color correction matrix fitted to a target, which can
+// be applied with -matrix.
+type matrixProfile struct {
+	Name   string          `json:"name"`
+	Source string          `json:"source"`
+	Matrix positive.Matrix `json:"matrix"`
+
+	// root mean square error of the corrected patches, from 0 to 1
+	RMSE float64 `json:"rmse"`
+}
+
+// targetCmd fits a color correction matrix to a converted scan of an IT8 or
+// ColorChecker target and its reference values, and writes it as JSON to
+// stdout.
+func targetCmd(args []string) {
+	fs := flag.NewFlagSet("target", flag.ExitOnError)
+	fGrid := fs.String("grid", "6x4", "Patch layout of the target as columns x rows")
+	fScale := fs.Float64("scale", 255, "Maximum value of the reference colors")
+	fName := fs.String("name", "", "Profile name, defaults to the input file name")
+	fGamma := fs.String("gamma", "", "Write a gamma profile combining the given profile with the matrix")
+	fProfiles := fs.String("profile-file", "", "Load additional gamma profiles from the given JSON file")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: positive target [flags] <converted target> <reference csv>")
+		fmt.Fprintln(fs.Output(), "")
+		fmt.Fprintln(fs.Output(), "The target scan must be converted with the same settings as the images")
+		fmt.Fprintln(fs.Output(), "the matrix will be applied to, and cropped to the patches. The reference")
+		fmt.Fprintln(fs.Output(), "file has one r,g,b line per patch, row by row, optionally preceded by a")
+		fmt.Fprintln(fs.Output(), "patch name.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	input := fs.Arg(0)
+
+	var cols, rows int
+	if _, err := fmt.Sscanf(*fGrid, "%dx%d", &cols, &rows); err != nil || cols < 1 || rows < 1 {
+		fatalf("invalid grid %q", *fGrid)
+	}
+
+	m, err := decode(input)
+	if err != nil {
+		fatal(inputError(err))
+	}
+
+	reference, err := readReference(fs.Arg(1), *fScale)
+	if err != nil {
+		fatal(inputError(err))
+	}
+	if len(reference) != cols*rows {
+		fatalf("%v reference colors for %v patches", len(reference), cols*rows)
+	}
+
+	// measure the middle half of each patch
+	b := m.Bounds()
+	var measured [][3]float64
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			x0 := b.Min.X + b.Dx()*col/cols
+			x1 := b.Min.X + b.Dx()*(col+1)/cols
+			y0 := b.Min.Y + b.Dy()*row/rows
+			y1 := b.Min.Y + b.Dy()*(row+1)/rows
+			r := image.Rect(x0+(x1-x0)/4, y0+(y1-y0)/4, x1-(x1-x0)/4, y1-(y1-y0)/4)
+			if r.Empty() {
+				fatalf("target scan is too small for the grid")
+			}
+
+			c := mean(m, r)
+			measured = append(measured, [3]float64{c[0] / 0xffff, c[1] / 0xffff, c[2] / 0xffff})
+		}
+	}
+
+	x, err := positive.FitMatrix(measured, reference)
+	if err != nil {
+		fatal(err)
+	}
+
+	p := matrixProfile{
+		Name:   *fName,
+		Source: filepath.Base(input),
+		Matrix: x,
+	}
+	if p.Name == "" {
+		p.Name = strings.TrimSuffix(p.Source, filepath.Ext(p.Source))
+	}
+
+	var sum float64
+	for i, c := range measured {
+		for j := 0; j < 3; j++ {
+			v := x[j][0]*c[0] + x[j][1]*c[1] + x[j][2]*c[2]
+			sum += (v - reference[i][j]) * (v - reference[i][j])
+		}
+	}
+	p.RMSE = math.Sqrt(sum / float64(len(measured)*3))
+
+	// a gamma profile carrying the matrix, which is applied whenever the
+	// profile is used
+	var out interface{} = p
+	if *fGamma != "" {
+		if err := loadProfiles(*fProfiles); err != nil {
+			fatal(inputError(err))
+		}
+		g, err := profile(*fGamma)
+		if err != nil {
+			fatal(usageError(err))
+		}
+		g.Name = p.Name
+		g.Source = p.Source
+		g.Matrix = &p.Matrix
+		out = g
+		infof("rmse %.4f", p.RMSE)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(out); err != nil {
+		fatal(err)
+	}
+}
+
+// readReference reads reference colors from a CSV file, scaled to [0,1]
+func readReference(path string, scale float64) ([][3]float64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ret [][3]float64
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		t := strings.TrimSpace(s.Text())
+		if t == "" || strings.HasPrefix(t, "#") {
+			continue
+		}
+
+		fields := strings.Split(t, ",")
+		if len(fields) == 4 {
+			fields = fields[1:]
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%v:%v: expected r,g,b", path, line)
+		}
+
+		var c [3]float64
+		for i, v := range fields {
+			c[i], err = strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return nil, fmt.Errorf("%v:%v: %v", path, line, err)
+			}
+			c[i] /= scale
+		}
+		ret = append(ret, c)
+	}
+
+	return ret, s.Err()
+}
+
+// loadMatrix loads a color correction matrix given inline as nine comma
+// separated values, row by row, or from a JSON file with a matrix, as written
+// by target.
+func loadMatrix(spec string) (*positive.Matrix, error) {
+	if f := strings.Split(spec, ","); len(f) == 9 {
+		var x positive.Matrix
+		for i, v := range f {
+			n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid matrix %q: %v", spec, err)
+			}
+			x[i/3][i%3] = n
+		}
+		return &x, nil
+	}
+
+	data, err := os.ReadFile(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	var p struct {
+		Matrix *positive.Matrix `json:"matrix"`
+	}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("%v: %v", spec, err)
+	}
+	if p.Matrix == nil {
+		return nil, fmt.Errorf("%v: no matrix", spec)
+	}
+	return p.Matrix, nil
+}
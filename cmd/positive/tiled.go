@@ -0,0 +1,108 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"os"
+
+	"github.com/djfritz/positive"
+	"github.com/djfritz/positive/tiffmeta"
+)
+
+// flags that need the whole image in memory, which -tiled avoids
+var tiledConflicts = map[string]bool{
+	"split":       true,
+	"stack":       true,
+	"hdr":         true,
+	"flat":        true,
+	"dark":        true,
+	"deskew":      true,
+	"crop":        true,
+	"resize":      true,
+	"rotate":      true,
+	"flip-h":      true,
+	"flip-v":      true,
+	"base-rect":   true,
+	"roll":        true,
+	"reference":   true,
+	"histogram":   true,
+	"clipping":    true,
+	"stats":       true,
+	"save-levels": true,
+	"save-recipe": true,
+	"from-recipe": true,
+	"proof":       true,
+	"thumbs":      true,
+	"hook":        true,
+}
+
+// checkTiled exits if a flag set with -tiled needs the whole image.
+func checkTiled() {
+	convertFlags.Visit(func(fl *flag.Flag) {
+		if tiledConflicts[fl.Name] {
+			fatalf("-%v can't be used with -tiled", fl.Name)
+		}
+	})
+}
+
+// convertTiled converts input to output a stripe of rows at a time, reading
+// and writing uncompressed TIFFs, so memory is bounded by the width of the
+// scan instead of its size.
+func convertTiled(input, output, format string, o positive.Options, f frame) error {
+	if format != "tiff" || *fDepth != 16 {
+		return usageError(errors.New("-tiled can only write 16-bit TIFF output"))
+	}
+	if f.crop() != "" || f.rotation() != 0 {
+		return usageError(fmt.Errorf("%v: the manifest crop and rotation can't be used with -tiled", input))
+	}
+
+	in, err := os.Open(input)
+	if err != nil {
+		return inputError(err)
+	}
+	defer in.Close()
+	src, err := tiffmeta.NewReader(in)
+	if err != nil {
+		return inputError(fmt.Errorf("%w: %v", positive.ErrDecode, err))
+	}
+
+	stage("converting "+output, imageWeight)
+	if !batchMode() {
+		o.OnProgress = func(_ string, f float64) {
+			bar.fraction(f)
+		}
+	}
+
+	out, err := createOutput(output)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	size := src.Size()
+	gray := *fGray || (o.BW && o.Toning == positive.ToningNone)
+	tw, err := tiffmeta.NewWriter(out, size.X, size.Y, gray, metadata(input, o))
+	if err != nil {
+		return outputError(err)
+	}
+
+	err = positive.ProcessTiled(context.Background(), src, o, 0, func(m image.Image) error {
+		return outputError(tw.WriteRows(m))
+	})
+	if err != nil {
+		// what isn't an option or output error is from reading the input
+		if exitCode(err) == exitFailure {
+			err = inputError(err)
+		}
+		return err
+	}
+	return outputError(tw.Close())
+}
@@ -0,0 +1,219 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// parseTOML parses a TOML config file. Only the subset presets need is
+// supported: a [presets.<name>] table for each preset, of keys set to
+// strings, numbers, or booleans, and comments. Numbers are returned as
+// float64, as JSON config files decode to.
+func parseTOML(data []byte) (config, error) {
+	c := config{Presets: make(map[string]map[string]any)}
+	var preset map[string]any
+	for i, line := range strings.Split(string(data), "\n") {
+		err := func() error {
+			s := strings.TrimSpace(line)
+			if s == "" || s[0] == '#' {
+				return nil
+			}
+
+			if s[0] == '[' {
+				keys, rest, err := tomlKeys(s[1:])
+				if err != nil {
+					return err
+				}
+				if !strings.HasPrefix(rest, "]") || strings.HasPrefix(rest, "]]") {
+					return errors.New("invalid table header")
+				}
+				if err := tomlEnd(rest[1:]); err != nil {
+					return err
+				}
+				if len(keys) != 2 || keys[0] != "presets" {
+					return fmt.Errorf("table %q isn't a [presets.<name>] table", strings.Join(keys, "."))
+				}
+				if _, ok := c.Presets[keys[1]]; ok {
+					return fmt.Errorf("preset %q defined twice", keys[1])
+				}
+				preset = make(map[string]any)
+				c.Presets[keys[1]] = preset
+				return nil
+			}
+
+			keys, rest, err := tomlKeys(s)
+			if err != nil {
+				return err
+			}
+			if len(keys) != 1 {
+				return fmt.Errorf("dotted key %q", strings.Join(keys, "."))
+			}
+			if preset == nil {
+				return fmt.Errorf("key %q outside a [presets.<name>] table", keys[0])
+			}
+			if !strings.HasPrefix(rest, "=") {
+				return fmt.Errorf("missing = after key %q", keys[0])
+			}
+			v, rest, err := tomlValue(strings.TrimSpace(rest[1:]))
+			if err != nil {
+				return fmt.Errorf("%v: %v", keys[0], err)
+			}
+			if err := tomlEnd(rest); err != nil {
+				return err
+			}
+			if _, ok := preset[keys[0]]; ok {
+				return fmt.Errorf("key %q set twice", keys[0])
+			}
+			preset[keys[0]] = v
+			return nil
+		}()
+		if err != nil {
+			return config{}, fmt.Errorf("line %v: %v", i+1, err)
+		}
+	}
+	return c, nil
+}
+
+// tomlKeys parses the dotted key at the start of s, returning its parts and
+// the rest of s, without leading space.
+func tomlKeys(s string) ([]string, string, error) {
+	var keys []string
+	for {
+		s = strings.TrimSpace(s)
+		var k string
+		var err error
+		switch {
+		case strings.HasPrefix(s, `"`), strings.HasPrefix(s, "'"):
+			k, s, err = tomlString(s)
+			if err != nil {
+				return nil, "", err
+			}
+		default:
+			n := strings.IndexFunc(s, func(r rune) bool {
+				return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-')
+			})
+			if n < 0 {
+				n = len(s)
+			}
+			if n == 0 {
+				return nil, "", errors.New("missing key")
+			}
+			k, s = s[:n], s[n:]
+		}
+		keys = append(keys, k)
+
+		s = strings.TrimSpace(s)
+		if !strings.HasPrefix(s, ".") {
+			return keys, s, nil
+		}
+		s = s[1:]
+	}
+}
+
+// tomlValue parses the string, number, or boolean at the start of s,
+// returning it and the rest of s.
+func tomlValue(s string) (any, string, error) {
+	if strings.HasPrefix(s, `"`) || strings.HasPrefix(s, "'") {
+		return tomlString(s)
+	}
+
+	n := strings.IndexAny(s, " \t#")
+	if n < 0 {
+		n = len(s)
+	}
+	tok, rest := s[:n], s[n:]
+	switch tok {
+	case "true":
+		return true, rest, nil
+	case "false":
+		return false, rest, nil
+	}
+	// numbers may separate digits with underscores, and must be decimal,
+	// though strconv would accept hexadecimal too
+	num := strings.ReplaceAll(tok, "_", "")
+	f, err := strconv.ParseFloat(num, 64)
+	if p := strings.ToLower(strings.TrimLeft(num, "+-")); strings.HasPrefix(p, "0x") {
+		err = errors.New("hexadecimal")
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid value %q, only strings, numbers, and booleans are supported", tok)
+	}
+	return f, rest, nil
+}
+
+// tomlString parses the basic "string" or literal 'string' at the start of
+// s, returning it and the rest of s. Multi-line strings aren't supported.
+func tomlString(s string) (string, string, error) {
+	if strings.HasPrefix(s, `"""`) || strings.HasPrefix(s, "'''") {
+		return "", "", errors.New("multi-line strings aren't supported")
+	}
+	if s[0] == '\'' {
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return "", "", errors.New("unterminated string")
+		}
+		return s[1 : end+1], s[end+2:], nil
+	}
+
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return b.String(), s[i+1:], nil
+		case '\\':
+			if i+1 >= len(s) {
+				return "", "", errors.New("unterminated string")
+			}
+			i++
+			switch e := s[i]; e {
+			case 'b':
+				b.WriteByte('\b')
+			case 't':
+				b.WriteByte('\t')
+			case 'n':
+				b.WriteByte('\n')
+			case 'f':
+				b.WriteByte('\f')
+			case 'r':
+				b.WriteByte('\r')
+			case '"', '\\':
+				b.WriteByte(e)
+			case 'u', 'U':
+				n := 4
+				if e == 'U' {
+					n = 8
+				}
+				if i+n >= len(s) {
+					return "", "", errors.New("invalid unicode escape")
+				}
+				r, err := strconv.ParseUint(s[i+1:i+1+n], 16, 32)
+				if err != nil || !utf8.ValidRune(rune(r)) {
+					return "", "", fmt.Errorf("invalid unicode escape %q", s[i-1:i+1+n])
+				}
+				b.WriteRune(rune(r))
+				i += n
+			default:
+				return "", "", fmt.Errorf("invalid escape \\%c", e)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", "", errors.New("unterminated string")
+}
+
+// tomlEnd checks s, the rest of a line, holds nothing but a comment.
+func tomlEnd(s string) error {
+	if s = strings.TrimSpace(s); s != "" && s[0] != '#' {
+		return fmt.Errorf("unexpected %q", s)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestParseTOML checks TOML presets parse to the same config as JSON ones.
+func TestParseTOML(t *testing.T) {
+	toml := `
+# lab presets
+[presets.portra400-frontier]
+gamma = "portra160"  # closest built in profile
+tupper = 20
+ev = -0.5
+look = 'frontier'
+format = "jpeg"
+auto-base = false
+
+[presets."b&w"]
+bw = true
+tlower = 1_000
+`
+	js := `{"presets": {
+		"portra400-frontier": {"gamma": "portra160", "tupper": 20, "ev": -0.5, "look": "frontier", "format": "jpeg", "auto-base": false},
+		"b&w": {"bw": true, "tlower": 1000}
+	}}`
+
+	got, err := parseTOML([]byte(toml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want config
+	if err := json.Unmarshal([]byte(js), &want); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("presets %v, want %v", got.Presets, want.Presets)
+	}
+
+	for _, c := range []struct{ toml, err string }{
+		{"gamma = \"none\"", "line 1: key \"gamma\" outside"},
+		{"[presets.a]\ngamma = \"none", "line 2: gamma: unterminated string"},
+		{"[presets.a]\ngamma = none", "line 2: gamma: invalid value"},
+		{"[presets.a]\nev = 1 2", "line 2: unexpected \"2\""},
+		{"[presets.a]\nev = 1\nev = 2", "line 3: key \"ev\" set twice"},
+		{"[presets.a]\n[presets.a]", "line 2: preset \"a\" defined twice"},
+		{"[looks.a]", "line 1: table \"looks.a\" isn't"},
+		{"[presets.a]\nmask = [1, 2]", "line 2: mask: invalid value"},
+	} {
+		if _, err := parseTOML([]byte(c.toml)); err == nil || !strings.HasPrefix(err.Error(), c.err) {
+			t.Errorf("%q: error %v, want %v", c.toml, err, c.err)
+		}
+	}
+}
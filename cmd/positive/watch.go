@@ -0,0 +1,127 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/djfritz/positive"
+)
+
+// size and modification time of a file when last polled
+type fileState struct {
+	size int64
+	mod  time.Time
+}
+
+// watch polls dir for new scans, converting each to its outputPath once the
+// scanner has finished writing it, until interrupted. Scans already
+// converted, with an existing output, are skipped.
+func watch(dir string, o positive.Options, workers int, mem int64) error {
+	// outputs written to dir would be converted again
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	out, err := filepath.Abs(filepath.Dir(outputPath(filepath.Join(dir, "scan.tif"))))
+	if err != nil {
+		return err
+	}
+	if out == abs {
+		return usageError(errors.New("-watch must write outputs outside the watched directory"))
+	}
+
+	// the first interrupt finishes the conversions in progress, and a
+	// second quits immediately
+	stop := make(chan struct{})
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupt
+		signal.Stop(interrupt)
+		infof("stopping, interrupt again to quit immediately")
+		close(stop)
+	}()
+
+	t := time.NewTicker(*fWatchInterval)
+	defer t.Stop()
+
+	seen := make(map[string]bool)
+	pending := make(map[string]fileState)
+	infof("watching %v for new scans", dir)
+	for {
+		ready, err := poll(dir, seen, pending)
+		if err != nil {
+			return inputError(err)
+		}
+		if len(ready) != 0 {
+			// failures are logged, and the file isn't retried until it
+			// changes
+			batch(ready, o, workers, mem)
+		}
+
+		select {
+		case <-stop:
+			return nil
+		case <-t.C:
+		}
+	}
+}
+
+// poll returns the scans in dir that are ready to convert: those not seen
+// before, that haven't changed since the last poll.
+func poll(dir string, seen map[string]bool, pending map[string]fileState) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ready []string
+	for _, e := range entries {
+		path := filepath.Join(dir, e.Name())
+		ext := strings.ToLower(filepath.Ext(path))
+		if e.IsDir() || !(ext == ".tif" || ext == ".tiff" || rawExt[ext]) {
+			continue
+		}
+
+		// removed since listed
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		s := fileState{fi.Size(), fi.ModTime()}
+
+		// a file changed since it was converted is a new scan
+		last, ok := pending[path]
+		if seen[path] {
+			if last.size == s.size && last.mod.Equal(s.mod) {
+				continue
+			}
+			delete(seen, path)
+			ok = false
+		}
+
+		// still being written
+		if !ok || last.size != s.size || !last.mod.Equal(s.mod) {
+			pending[path] = s
+			continue
+		}
+
+		seen[path] = true
+		if _, err := os.Stat(outputPath(path)); err == nil && !*fForce {
+			debugf("%v: already converted", path)
+			continue
+		}
+		ready = append(ready, path)
+	}
+	return ready, nil
+}
@@ -0,0 +1,195 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+//go:build js && wasm
+
+// Command wasm exports the conversion pipeline to JavaScript when built for
+// WebAssembly, so negatives can be converted entirely in a web browser:
+//
+//	GOOS=js GOARCH=wasm go build -o positive.wasm ./cmd/wasm
+//
+// Once loaded with the wasm_exec.js support file of the Go distribution, it
+// sets a global positive object with two functions:
+//
+//	positive.profiles()
+//
+// returns the names of the built in gamma profiles, and
+//
+//	positive.convert(data, options)
+//
+// converts the TIFF, PNG, or JPEG file in the Uint8Array data, returning a
+// Promise of the output file as a Uint8Array. The optional options object
+// may set:
+//
+//	gamma      gamma profile, "none" by default
+//	base       film mask color as [r, g, b] 16-bit values, estimated from
+//	           the frame border by default
+//	normalize  normalize each channel, true by default
+//	linked     normalize all channels with the same levels
+//	ev         exposure compensation in stops
+//	highlights highlight recovery from 0 to 1
+//	shadows    shadow recovery from 0 to 1
+//	midtone    midtone gamma, 1 by default
+//	tone       tone curve: linear, soft, or punchy
+//	contrast   tone curve contrast from -1 to 1
+//	look       lab scanner look: noritsu, frontier, or pakon
+//	temp       color temperature shift from -100 to 100
+//	tint       tint shift from -100 to 100
+//	bw         convert black and white film
+//	slide      convert slide film
+//	format     output format, png (16-bit, the default) or jpeg
+//	quality    JPEG quality, 90 by default
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"sort"
+	"syscall/js"
+
+	"github.com/djfritz/positive"
+	_ "golang.org/x/image/tiff"
+)
+
+func main() {
+	js.Global().Set("positive", js.ValueOf(map[string]any{
+		"profiles": js.FuncOf(profiles),
+		"convert":  js.FuncOf(convert),
+	}))
+
+	// the exported functions run as long as the page does
+	select {}
+}
+
+// profiles returns the names of the gamma profiles.
+func profiles(this js.Value, args []js.Value) any {
+	var names []any
+	for k := range positive.Profiles {
+		names = append(names, k)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i].(string) < names[j].(string) })
+	return js.ValueOf(names)
+}
+
+// convert returns a Promise of the conversion of args[0] with the options
+// args[1].
+func convert(this js.Value, args []js.Value) any {
+	if len(args) == 0 || args[0].Type() != js.TypeObject {
+		return reject(errors.New("convert requires a Uint8Array of the image file"))
+	}
+	data := make([]byte, args[0].Get("length").Int())
+	js.CopyBytesToGo(data, args[0])
+
+	opts := js.Global().Get("Object").New()
+	if len(args) > 1 && args[1].Type() == js.TypeObject {
+		opts = args[1]
+	}
+
+	executor := js.FuncOf(func(this js.Value, p []js.Value) any {
+		resolve, reject := p[0], p[1]
+
+		// converting takes too long to block the caller
+		go func() {
+			out, err := run(data, opts)
+			if err != nil {
+				reject.Invoke(js.Global().Get("Error").New(err.Error()))
+				return
+			}
+			a := js.Global().Get("Uint8Array").New(len(out))
+			js.CopyBytesToJS(a, out)
+			resolve.Invoke(a)
+		}()
+		return nil
+	})
+	defer executor.Release()
+	return js.Global().Get("Promise").New(executor)
+}
+
+// reject returns a Promise rejected with err.
+func reject(err error) js.Value {
+	return js.Global().Get("Promise").Call("reject", js.Global().Get("Error").New(err.Error()))
+}
+
+// run converts the image file data with the options opts, returning the
+// encoded output.
+func run(data []byte, opts js.Value) ([]byte, error) {
+	m, err := positive.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := positive.LookupProfile(str(opts, "gamma", "none"))
+	if err != nil {
+		return nil, err
+	}
+
+	// the defaults of the command line for everything not supplied
+	o := positive.DefaultOptions()
+	o.Gamma, o.Matrix = p.Gamma, p.Matrix
+	o.FilmLight, o.Light = p.Light, p.Light
+	o.Normalize = boolean(opts, "normalize", true)
+	o.Linked = boolean(opts, "linked", false)
+	o.EV = num(opts, "ev", 0)
+	o.Highlights, o.Shadows = num(opts, "highlights", 0), num(opts, "shadows", 0)
+	o.Midtone = num(opts, "midtone", 1)
+	o.Tone = positive.Tone(str(opts, "tone", string(positive.ToneLinear)))
+	o.Contrast = num(opts, "contrast", 0)
+	o.Look = positive.Look(str(opts, "look", ""))
+	o.Temp, o.Tint = num(opts, "temp", 0), num(opts, "tint", 0)
+	o.BW = boolean(opts, "bw", false)
+	o.Slide = boolean(opts, "slide", false)
+
+	if b := opts.Get("base"); b.Type() == js.TypeObject && b.Length() == 3 {
+		o.Base = color.RGBA64{R: uint16(b.Index(0).Int()), G: uint16(b.Index(1).Int()), B: uint16(b.Index(2).Int()), A: 0xffff}
+	} else if !o.Slide {
+		if c, ok := positive.EstimateBase(m); ok {
+			o.Base = c
+		}
+	}
+
+	m, err = positive.Process(m, o)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	switch f := str(opts, "format", "png"); f {
+	case "png":
+		err = png.Encode(&out, m)
+	case "jpeg":
+		err = jpeg.Encode(&out, m, &jpeg.Options{Quality: int(num(opts, "quality", 90))})
+	default:
+		return nil, fmt.Errorf("unknown format %q, must be png or jpeg", f)
+	}
+	return out.Bytes(), err
+}
+
+// str returns the string option k of o, or def if not set.
+func str(o js.Value, k, def string) string {
+	if v := o.Get(k); v.Type() == js.TypeString {
+		return v.String()
+	}
+	return def
+}
+
+// num returns the number option k of o, or def if not set.
+func num(o js.Value, k string, def float64) float64 {
+	if v := o.Get(k); v.Type() == js.TypeNumber {
+		return v.Float()
+	}
+	return def
+}
+
+// boolean returns the boolean option k of o, or def if not set.
+func boolean(o js.Value, k string, def bool) bool {
+	if v := o.Get(k); v.Type() == js.TypeBoolean {
+		return v.Bool()
+	}
+	return def
+}
@@ -0,0 +1,72 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package positive
+
+import (
+	"math"
+
+	"github.com/djfritz/positive/icc"
+)
+
+// ColorSpace is an RGB color space to encode the positive in, see
+// Options.ColorSpace. The names are those of the icc package's profiles.
+type ColorSpace string
+
+// Color spaces
+const (
+	ColorSpaceNone     ColorSpace = ""
+	ColorSpaceSRGB     ColorSpace = "srgb"
+	ColorSpaceAdobeRGB ColorSpace = "adobergb"
+	ColorSpaceProPhoto ColorSpace = "prophoto"
+	ColorSpaceLinear   ColorSpace = "linear"
+)
+
+// number of steps of the curve encoding linear values, fine enough that the
+// steepest part of the tone responses, near black, is smooth in 16 bits
+const encodeSteps = 1 << 20
+
+// spaceFunc returns the stage encoding the positive in cs, or nil for
+// ColorSpaceNone. Positives have sRGB primaries and are encoded with
+// displayGamma, or are linear in density mode.
+func spaceFunc(cs ColorSpace, linear bool) pixelFunc {
+	if cs == ColorSpaceNone {
+		return nil
+	}
+
+	// from linear sRGB to XYZ, and on to linear values in cs
+	src, _ := icc.Primaries(string(ColorSpaceSRGB))
+	dst, _ := icc.Primaries(string(cs))
+	inv, _ := Matrix(dst).inverse()
+	var x Matrix
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				x[i][j] += inv[i][k] * src[k][j]
+			}
+		}
+	}
+
+	dec := gammaCurve(displayGamma)
+	if linear {
+		dec = nil
+	}
+	// the tone responses aren't defined beyond black and white, so values
+	// are clipped as they would be when stored
+	encode, _ := icc.Encoder(string(cs))
+	enc := newCurve(encodeSteps, func(v float64) float64 {
+		return encode(math.Max(0, math.Min(1, v)))
+	})
+
+	encodeRow := func(row [3]float64, r, g, b float32) float32 {
+		return enc.at(float32(row[0]*float64(r) + row[1]*float64(g) + row[2]*float64(b)))
+	}
+	return func(r, g, b float32) (float32, float32, float32) {
+		if dec != nil {
+			r, g, b = dec.at(r), dec.at(g), dec.at(b)
+		}
+		return encodeRow(x[0], r, g, b), encodeRow(x[1], r, g, b), encodeRow(x[2], r, g, b)
+	}
+}
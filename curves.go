@@ -0,0 +1,69 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package positive
+
+import (
+	"math"
+	"sort"
+)
+
+// validate checks that each channel of c has at least two points along which
+// density increases with exposure.
+func (c *Curves) validate() error {
+	for _, ch := range [][][2]float64{c.R, c.G, c.B} {
+		if len(monotone(ch)) < 2 {
+			return errorf(ErrInvalidOptions, "curves must have at least two points per channel, with density increasing with exposure")
+		}
+	}
+	return nil
+}
+
+// monotone returns the points of c in increasing exposure, dropping any that
+// don't increase in density, such as measurement noise in the toe of a curve.
+func monotone(c [][2]float64) [][2]float64 {
+	s := append([][2]float64(nil), c...)
+	sort.Slice(s, func(i, j int) bool {
+		return s[i][0] < s[j][0]
+	})
+
+	var p [][2]float64
+	for _, v := range s {
+		if len(p) == 0 || v[0] > p[len(p)-1][0] && v[1] > p[len(p)-1][1] {
+			p = append(p, v)
+		}
+	}
+	return p
+}
+
+// curvesFunc corrects for the film response described by characteristic
+// curves c, in place of a single gamma. Mask removed values are taken as
+// transmittance above the curve's minimum density, and mapped to values
+// inversely proportional to the exposure the curve gives for that density,
+// the same as gamma correction does for a straight line curve.
+func curvesFunc(c *Curves) pixelFunc {
+	return curveFunc(filmCurve(c.R), filmCurve(c.G), filmCurve(c.B))
+}
+
+func filmCurve(c [][2]float64) *curve {
+	p := monotone(c)
+	dmin := p[0][1]
+
+	// log exposure of density d, extrapolating the end segments
+	logE := func(d float64) float64 {
+		i := sort.Search(len(p)-1, func(i int) bool {
+			return p[i+1][1] >= d
+		})
+		if i == len(p)-1 {
+			i--
+		}
+		a, b := p[i], p[i+1]
+		return a[0] + (d-a[1])*(b[0]-a[0])/(b[1]-a[1])
+	}
+
+	return newCurve(curveSteps, func(v float64) float64 {
+		return math.Pow(10, -(logE(dmin+density(v)) - p[0][0]))
+	})
+}
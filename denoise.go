@@ -0,0 +1,176 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package positive
+
+import (
+	"context"
+	"image"
+	"math"
+)
+
+const (
+	// radius of the luminance filter, in pixels, which only needs to cover
+	// individual grains
+	lumaRadius = 2
+
+	// largest radius of the chroma blur, at full strength, since color noise
+	// is blotchier than luminance grain and the eye is less sensitive to
+	// color detail
+	chromaRadius = 8
+
+	// range of luminance differences smoothed at full strength, as a
+	// fraction of white
+	lumaRange = 0.08
+)
+
+// Denoise reduces film grain in m. The luminance is smoothed with an edge
+// preserving (bilateral) filter, which averages neighboring pixels of
+// similar brightness, and the color is blurred, each by the given strength
+// from 0 (none) to 1. Rows are processed concurrently by up to threads
+// goroutines.
+func Denoise(m *image.RGBA64, luma, chroma float64, threads int) *image.RGBA64 {
+	ret, _ := DenoiseContext(context.Background(), m, luma, chroma, threads)
+	return ret
+}
+
+// DenoiseContext is Denoise, returning ctx.Err() if ctx is done first.
+func DenoiseContext(ctx context.Context, m *image.RGBA64, luma, chroma float64, threads int) (*image.RGBA64, error) {
+	p, err := mapFloat(ctx, m, identity, threads)
+	if err != nil {
+		return nil, err
+	}
+	if p, err = denoise(ctx, p, luma, chroma, identity, threads); err != nil {
+		return nil, err
+	}
+	return mapPixelsContext(ctx, p, identity, threads)
+}
+
+// denoise is Denoise on the floating point image p, passing the recombined
+// pixels through f, which saves Process a pass over the image.
+func denoise(ctx context.Context, p *floatImage, luma, chroma float64, f pixelFunc, threads int) (*floatImage, error) {
+	w, h := p.w, p.h
+
+	// luminance and color difference planes
+	y := make([]float32, w*h)
+	cb := make([]float32, w*h)
+	cr := make([]float32, w*h)
+	stripesContext(ctx, p.Bounds(), threads, func(stripe image.Rectangle) {
+		for i := stripe.Min.Y * w; i < stripe.Max.Y*w; i++ {
+			r, g, b := p.pix[i*3], p.pix[i*3+1], p.pix[i*3+2]
+			y[i] = luminance(r, g, b)
+			cb[i] = b - y[i]
+			cr[i] = r - y[i]
+		}
+	})
+
+	if luma > 0 {
+		y = bilateral(ctx, y, w, h, luma*lumaRange, threads)
+	}
+	if rad := int(math.Ceil(chroma * chromaRadius)); rad > 0 {
+		cb = boxBlur(ctx, cb, w, h, rad, threads)
+		cr = boxBlur(ctx, cr, w, h, rad, threads)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ret := newFloatImage(w, h)
+	stripesContext(ctx, ret.Bounds(), threads, func(stripe image.Rectangle) {
+		for i := stripe.Min.Y * w; i < stripe.Max.Y*w; i++ {
+			r := cr[i] + y[i]
+			b := cb[i] + y[i]
+			g := (y[i] - lumaR*r - lumaB*b) / lumaG
+			ret.pix[i*3], ret.pix[i*3+1], ret.pix[i*3+2] = f(r, g, b)
+		}
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// bilateral filters the w by h plane v, weighting neighbors by both their
+// distance and their difference from each pixel, relative to sigma. The
+// result is incomplete if ctx is done first.
+func bilateral(ctx context.Context, v []float32, w, h int, sigma float64, threads int) []float32 {
+	const size = 2*lumaRadius + 1
+	var spatial [size * size]float64
+	for dy := -lumaRadius; dy <= lumaRadius; dy++ {
+		for dx := -lumaRadius; dx <= lumaRadius; dx++ {
+			spatial[(dy+lumaRadius)*size+dx+lumaRadius] = math.Exp(-float64(dx*dx+dy*dy) / (2 * 1.5 * 1.5))
+		}
+	}
+
+	ret := make([]float32, len(v))
+	stripesContext(ctx, image.Rect(0, 0, w, h), threads, func(stripe image.Rectangle) {
+		for y := stripe.Min.Y; y < stripe.Max.Y; y++ {
+			for x := 0; x < w; x++ {
+				c := float64(v[y*w+x])
+				var sum, weight float64
+				for dy := -lumaRadius; dy <= lumaRadius; dy++ {
+					ny := y + dy
+					if ny < 0 || ny >= h {
+						continue
+					}
+					for dx := -lumaRadius; dx <= lumaRadius; dx++ {
+						nx := x + dx
+						if nx < 0 || nx >= w {
+							continue
+						}
+						n := float64(v[ny*w+nx])
+						d := (n - c) / sigma
+						k := spatial[(dy+lumaRadius)*size+dx+lumaRadius] * math.Exp(-d*d/2)
+						sum += n * k
+						weight += k
+					}
+				}
+				ret[y*w+x] = float32(sum / weight)
+			}
+		}
+	})
+	return ret
+}
+
+// boxBlur blurs the w by h plane v with a square of the given radius, as
+// separate horizontal and vertical passes. The result is incomplete if ctx is
+// done first.
+func boxBlur(ctx context.Context, v []float32, w, h, radius, threads int) []float32 {
+	tmp := make([]float32, len(v))
+	stripesContext(ctx, image.Rect(0, 0, w, h), threads, func(stripe image.Rectangle) {
+		for y := stripe.Min.Y; y < stripe.Max.Y; y++ {
+			row := v[y*w : (y+1)*w]
+			for x := 0; x < w; x++ {
+				var sum float32
+				var n int
+				for nx := x - radius; nx <= x+radius; nx++ {
+					if nx >= 0 && nx < w {
+						sum += row[nx]
+						n++
+					}
+				}
+				tmp[y*w+x] = sum / float32(n)
+			}
+		}
+	})
+
+	ret := make([]float32, len(v))
+	stripesContext(ctx, image.Rect(0, 0, w, h), threads, func(stripe image.Rectangle) {
+		for y := stripe.Min.Y; y < stripe.Max.Y; y++ {
+			for x := 0; x < w; x++ {
+				var sum float32
+				var n int
+				for ny := y - radius; ny <= y+radius; ny++ {
+					if ny >= 0 && ny < h {
+						sum += tmp[ny*w+x]
+						n++
+					}
+				}
+				ret[y*w+x] = sum / float32(n)
+			}
+		}
+	})
+	return ret
+}
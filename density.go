@@ -0,0 +1,69 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package positive
+
+import (
+	"image/color"
+	"math"
+)
+
+// maxDensity is the highest density a 16-bit scan can represent.
+var maxDensity = math.Log10(0xffff)
+
+// density returns the optical density of the scan value v, a fraction of
+// white, limited to maxDensity.
+func density(v float64) float64 {
+	if v < 1.0/0xffff {
+		v = 1.0 / 0xffff
+	}
+	return -math.Log10(v)
+}
+
+// densityFunc converts scan values to density above the film base s, or
+// above clear film if s is nil, encoded linearly with maxDensity as 1 so the
+// levels of the result can be found the same as any other image. Subtracting
+// the base density is the log space equivalent of dividing out the film mask.
+func densityFunc(s color.Color) pixelFunc {
+	var br, bg, bb uint32 = 0xffff, 0xffff, 0xffff
+	if s != nil {
+		br, bg, bb, _ = s.RGBA()
+	}
+	return curveFunc(densityCurve(br), densityCurve(bg), densityCurve(bb))
+}
+
+func densityCurve(base uint32) *curve {
+	db := density(float64(unit(base)))
+	return newCurve(curveSteps, func(v float64) float64 {
+		return (density(v) - db) / maxDensity
+	})
+}
+
+// printFunc maps encoded densities, as produced by densityFunc, back to a
+// linear positive, like exposing a print through the negative. Film density
+// grows with log exposure by the film gamma, so exposure is recovered as
+// 10^(density/gamma). The densest point, hi, maps to white and lo to black,
+// with values beyond them rolled off within knee. See soft.
+func printFunc(l Levels, g Gamma, knee float64) pixelFunc {
+	return curveFunc(
+		printCurve(l.RMin, l.RMax, g.R, knee),
+		printCurve(l.GMin, l.GMax, g.G, knee),
+		printCurve(l.BMin, l.BMax, g.B, knee))
+}
+
+func printCurve(lo, hi uint32, gamma, knee float64) *curve {
+	dlo, dhi := float64(unit(lo))*maxDensity, float64(unit(hi))*maxDensity
+
+	// exposure of the black point, relative to white
+	var black float64
+	if hi > lo {
+		black = math.Pow(10, (dlo-dhi)/gamma)
+	}
+
+	return newCurve(curveSteps, func(v float64) float64 {
+		e := math.Pow(10, (v*maxDensity-dhi)/gamma)
+		return soft((e-black)/(1-black), knee)
+	})
+}
@@ -0,0 +1,184 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package positive
+
+import (
+	"context"
+	"image"
+	"math"
+)
+
+const (
+	// MaxSkew is the largest skew angle, in degrees, DetectSkew looks for.
+	MaxSkew = 5.0
+
+	// angle step between skews tried, in degrees
+	skewStep = 0.05
+
+	// longest side of the image skew is detected in, which is downsampled
+	// for speed
+	skewSize = 600
+)
+
+// DetectSkew returns the angle in degrees, counterclockwise, that the frame
+// or film edges in m are rotated from square by, up to MaxSkew, as
+// introduced by a film holder. Straight edges project onto the rows and
+// columns of the image most sharply when they are aligned with them, so the
+// angle whose projections have the most contrast between neighboring rows
+// and columns is found.
+func DetectSkew(m image.Image) float64 {
+	bounds := m.Bounds()
+	step := bounds.Dx()
+	if bounds.Dy() > step {
+		step = bounds.Dy()
+	}
+	step = (step + skewSize - 1) / skewSize
+
+	// downsampled luminance
+	w, h := bounds.Dx()/step, bounds.Dy()/step
+	if w < 2 || h < 2 {
+		return 0
+	}
+	luma := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r, g, b, _ := m.At(bounds.Min.X+x*step, bounds.Min.Y+y*step).RGBA()
+			luma[y*w+x] = 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+		}
+	}
+
+	// search coarsely, then finely around the best coarse angle
+	const coarse = 10
+	best := search(luma, w, h, 0, int(MaxSkew/skewStep)/coarse, skewStep*coarse)
+	return search(luma, w, h, best, coarse, skewStep)
+}
+
+// search returns the angle with the best skewScore of those up to n steps
+// of the given size either side of center.
+func search(luma []float64, w, h int, center float64, n int, step float64) float64 {
+	best, bestScore := center, -1.0
+	for i := -n; i <= n; i++ {
+		a := center + float64(i)*step
+		if s := skewScore(luma, w, h, a); s > bestScore {
+			best, bestScore = a, s
+		}
+	}
+	return best
+}
+
+// skewScore returns the sharpness of the row and column projections of the
+// w by h plane luma, after rotating it clockwise by angle degrees.
+func skewScore(luma []float64, w, h int, angle float64) float64 {
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+	cx, cy := float64(w)/2, float64(h)/2
+
+	// rotated rows and columns are binned with room for the corners, each
+	// pixel split between the two nearest bins so the projections change
+	// smoothly with the angle
+	n := w + h
+	rows := make([]float64, 2*n)
+	cols := make([]float64, 2*n)
+	rowN := make([]float64, 2*n)
+	colN := make([]float64, 2*n)
+	bin := func(sum, count []float64, pos, v float64) {
+		i := int(math.Floor(pos))
+		f := pos - float64(i)
+		sum[n+i] += v * (1 - f)
+		count[n+i] += 1 - f
+		sum[n+i+1] += v * f
+		count[n+i+1] += f
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dx, dy := float64(x)-cx, float64(y)-cy
+			v := luma[y*w+x]
+			bin(rows, rowN, dy*cos+dx*sin, v)
+			bin(cols, colN, dx*cos-dy*sin, v)
+		}
+	}
+
+	score := func(sum, count []float64, length int) float64 {
+		var s float64
+		for i := 1; i < len(sum); i++ {
+			// partial lines at the corners are noisy
+			if count[i]*2 < float64(length) || count[i-1]*2 < float64(length) {
+				continue
+			}
+			d := sum[i]/count[i] - sum[i-1]/count[i-1]
+			s += d * d
+		}
+		return s
+	}
+	return score(rows, rowN, w) + score(cols, colN, h)
+}
+
+// Straighten rotates m clockwise by angle degrees about its center, undoing
+// a skew found by DetectSkew, keeping the size of m. Corners rotated in from
+// outside the image repeat its nearest edge pixels, so they don't skew
+// normalization. Rows are processed concurrently by up to threads
+// goroutines.
+func Straighten(m image.Image, angle float64, threads int) *image.RGBA64 {
+	ret, _ := StraightenContext(context.Background(), m, angle, threads)
+	return ret
+}
+
+// StraightenContext is Straighten, returning ctx.Err() if ctx is done first.
+func StraightenContext(ctx context.Context, m image.Image, angle float64, threads int) (*image.RGBA64, error) {
+	src, err := mapPixelsContext(ctx, m, identity, threads)
+	if err != nil {
+		return nil, err
+	}
+	if angle == 0 {
+		return src, nil
+	}
+
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+	cx, cy := float64(w)/2, float64(h)/2
+
+	at := func(x, y int) []uint8 {
+		if x < 0 {
+			x = 0
+		} else if x >= w {
+			x = w - 1
+		}
+		if y < 0 {
+			y = 0
+		} else if y >= h {
+			y = h - 1
+		}
+		return src.Pix[y*src.Stride+x*8:]
+	}
+
+	ret := image.NewRGBA64(src.Rect)
+	err = stripesContext(ctx, ret.Rect, threads, func(stripe image.Rectangle) {
+		for y := stripe.Min.Y; y < stripe.Max.Y; y++ {
+			for x := 0; x < w; x++ {
+				// the source of each output pixel, rotated back
+				dx, dy := float64(x)+0.5-cx, float64(y)+0.5-cy
+				sx := dx*cos + dy*sin + cx - 0.5
+				sy := dy*cos - dx*sin + cy - 0.5
+
+				x0, y0 := int(math.Floor(sx)), int(math.Floor(sy))
+				fx, fy := sx-float64(x0), sy-float64(y0)
+				p00, p10 := at(x0, y0), at(x0+1, y0)
+				p01, p11 := at(x0, y0+1), at(x0+1, y0+1)
+
+				d := ret.Pix[y*ret.Stride+x*8:]
+				for c := 0; c < 6; c += 2 {
+					top := float64(get16(p00[c:]))*(1-fx) + float64(get16(p10[c:]))*fx
+					bottom := float64(get16(p01[c:]))*(1-fx) + float64(get16(p11[c:]))*fx
+					put16(d[c:], clip(top*(1-fy)+bottom*fy))
+				}
+				put16(d[6:], 0xffff)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
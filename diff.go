@@ -0,0 +1,180 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package positive
+
+import (
+	"context"
+	"image"
+	"math"
+	"sync"
+)
+
+const (
+	// size and spacing of the windows SSIM is measured over
+	ssimWindow = 8
+	ssimStep   = 4
+
+	// SSIM stabilizing constants, for values in [0,1]
+	ssimC1 = 0.01 * 0.01
+	ssimC2 = 0.03 * 0.03
+)
+
+// A Difference measures how two images of the same size differ, in r,g,b
+// order, with values scaled to [0,1].
+type Difference struct {
+	// RMSE is the root mean square error.
+	RMSE [3]float64
+
+	// PSNR is the peak signal to noise ratio in dB, +Inf for identical
+	// channels.
+	PSNR [3]float64
+
+	// SSIM is the mean structural similarity of 8x8 windows, 1 for
+	// identical channels.
+	SSIM [3]float64
+}
+
+// Compare measures the difference between a and b, such as conversions of
+// the same negative with different parameters, which must be the same size.
+// Rows are processed concurrently by up to threads goroutines.
+func Compare(a, b image.Image, threads int) (Difference, error) {
+	return CompareContext(context.Background(), a, b, threads)
+}
+
+// CompareContext is Compare, returning ctx.Err() if ctx is done first.
+func CompareContext(ctx context.Context, a, b image.Image, threads int) (Difference, error) {
+	pa, pb, err := sameSize(ctx, a, b, threads)
+	if err != nil {
+		return Difference{}, err
+	}
+	w, h := pa.Rect.Dx(), pa.Rect.Dy()
+
+	var d Difference
+	var mu sync.Mutex
+
+	var sq [3]float64
+	stripesContext(ctx, pa.Rect, threads, func(stripe image.Rectangle) {
+		var s [3]float64
+		for y := stripe.Min.Y; y < stripe.Max.Y; y++ {
+			ra, rb := pa.Pix[y*pa.Stride:], pb.Pix[y*pb.Stride:]
+			for x := 0; x < w*8; x += 8 {
+				for c := 0; c < 3; c++ {
+					e := (float64(get16(ra[x+2*c:])) - float64(get16(rb[x+2*c:]))) / 0xffff
+					s[c] += e * e
+				}
+			}
+		}
+		mu.Lock()
+		for c := range sq {
+			sq[c] += s[c]
+		}
+		mu.Unlock()
+	})
+
+	// windows are no larger than the image, and cover its last rows and
+	// columns
+	ww, wh := min(ssimWindow, w), min(ssimWindow, h)
+	nx, ny := (w-ww+ssimStep-1)/ssimStep+1, (h-wh+ssimStep-1)/ssimStep+1
+
+	var ssim [3]float64
+	stripesContext(ctx, image.Rect(0, 0, nx, ny), threads, func(stripe image.Rectangle) {
+		var s [3]float64
+		for j := stripe.Min.Y; j < stripe.Max.Y; j++ {
+			y0 := min(j*ssimStep, h-wh)
+			for i := 0; i < nx; i++ {
+				x0 := min(i*ssimStep, w-ww)
+				for c := 0; c < 3; c++ {
+					s[c] += ssimAt(pa, pb, x0, y0, ww, wh, c)
+				}
+			}
+		}
+		mu.Lock()
+		for c := range ssim {
+			ssim[c] += s[c]
+		}
+		mu.Unlock()
+	})
+	if err := ctx.Err(); err != nil {
+		return Difference{}, err
+	}
+
+	for c := 0; c < 3; c++ {
+		mse := sq[c] / float64(w*h)
+		d.RMSE[c] = math.Sqrt(mse)
+		d.PSNR[c] = -10 * math.Log10(mse)
+		d.SSIM[c] = ssim[c] / float64(nx*ny)
+	}
+	return d, nil
+}
+
+// ssimAt returns the structural similarity of channel c of a and b in the
+// ww by wh window at x0,y0.
+func ssimAt(a, b *image.RGBA64, x0, y0, ww, wh, c int) float64 {
+	var sa, sb, saa, sbb, sab float64
+	for y := y0; y < y0+wh; y++ {
+		ra, rb := a.Pix[y*a.Stride+x0*8+2*c:], b.Pix[y*b.Stride+x0*8+2*c:]
+		for x := 0; x < ww*8; x += 8 {
+			va, vb := float64(get16(ra[x:]))/0xffff, float64(get16(rb[x:]))/0xffff
+			sa += va
+			sb += vb
+			saa += va * va
+			sbb += vb * vb
+			sab += va * vb
+		}
+	}
+
+	n := float64(ww * wh)
+	ma, mb := sa/n, sb/n
+	va, vb := saa/n-ma*ma, sbb/n-mb*mb
+	cov := sab/n - ma*mb
+	return (2*ma*mb + ssimC1) * (2*cov + ssimC2) / ((ma*ma + mb*mb + ssimC1) * (va + vb + ssimC2))
+}
+
+// DiffImage returns the absolute difference between a and b in each channel,
+// multiplied by gain so small differences are visible. a and b must be the
+// same size. Rows are processed concurrently by up to threads goroutines.
+func DiffImage(a, b image.Image, gain float64, threads int) (*image.RGBA64, error) {
+	pa, pb, err := sameSize(context.Background(), a, b, threads)
+	if err != nil {
+		return nil, err
+	}
+	w := pa.Rect.Dx()
+
+	ret := image.NewRGBA64(pa.Rect)
+	stripes(pa.Rect, threads, func(stripe image.Rectangle) {
+		for y := stripe.Min.Y; y < stripe.Max.Y; y++ {
+			ra, rb, d := pa.Pix[y*pa.Stride:], pb.Pix[y*pb.Stride:], ret.Pix[y*ret.Stride:]
+			for x := 0; x < w*8; x += 8 {
+				for c := 0; c < 3; c++ {
+					e := math.Abs(float64(get16(ra[x+2*c:])) - float64(get16(rb[x+2*c:])))
+					put16(d[x+2*c:], clip(e*gain))
+				}
+				put16(d[x+6:], 0xffff)
+			}
+		}
+	})
+	return ret, nil
+}
+
+// sameSize returns a and b as 16-bit images at the origin, or an error if
+// they aren't the same size.
+func sameSize(ctx context.Context, a, b image.Image, threads int) (*image.RGBA64, *image.RGBA64, error) {
+	if a.Bounds().Size() != b.Bounds().Size() {
+		return nil, nil, errorf(ErrImageMismatch, "images to compare must be the same size")
+	}
+	if a.Bounds().Empty() {
+		return nil, nil, errorf(ErrImageMismatch, "images to compare are empty")
+	}
+	pa, err := mapPixelsContext(ctx, a, identity, threads)
+	if err != nil {
+		return nil, nil, err
+	}
+	pb, err := mapPixelsContext(ctx, b, identity, threads)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pa, pb, nil
+}
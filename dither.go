@@ -0,0 +1,113 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package positive
+
+import (
+	"image"
+)
+
+// Dither reduces m to 8 bits per channel for 8-bit output, such as JPEG,
+// diffusing the rounding error of each pixel to the pixels after it
+// (Floyd-Steinberg), so smooth gradients such as skies don't band.
+// *image.Gray16 images are returned as *image.Gray, *image.NRGBA64 images as
+// *image.NRGBA keeping their alpha, and others as opaque *image.RGBA, all
+// starting at the origin.
+func Dither(m image.Image) image.Image {
+	d := newReduction(m)
+	w, h := m.Bounds().Dx(), m.Bounds().Dy()
+	n := d.n
+
+	// errors diffused to the rest of this row and the next, with a pixel
+	// of padding on each side
+	cur := make([]float32, (w+2)*n)
+	next := make([]float32, (w+2)*n)
+	s := make([]uint32, n)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			d.read(x, y, s)
+			for c := 0; c < n; c++ {
+				v := float32(s[c]) + cur[(x+1)*n+c]
+				q := int((v + 0x101/2) / 0x101)
+				if q < 0 {
+					q = 0
+				} else if q > 0xff {
+					q = 0xff
+				}
+				d.pix[y*d.stride+x*n+c] = uint8(q)
+
+				e := v - float32(q*0x101)
+				cur[(x+2)*n+c] += e * 7 / 16
+				next[x*n+c] += e * 3 / 16
+				next[(x+1)*n+c] += e * 5 / 16
+				next[(x+2)*n+c] += e * 1 / 16
+			}
+		}
+		cur, next = next, cur
+		for i := range next {
+			next[i] = 0
+		}
+	}
+	return d.m
+}
+
+// Round reduces m to 8 bits per channel like Dither, but rounding each
+// sample to the nearest 8-bit value on its own, which is faster and keeps
+// flat areas flat.
+func Round(m image.Image) image.Image {
+	d := newReduction(m)
+	w, h := m.Bounds().Dx(), m.Bounds().Dy()
+	stripes(image.Rect(0, 0, w, h), 0, func(stripe image.Rectangle) {
+		s := make([]uint32, d.n)
+		for y := stripe.Min.Y; y < stripe.Max.Y; y++ {
+			for x := 0; x < w; x++ {
+				d.read(x, y, s)
+				for c, v := range s {
+					d.pix[y*d.stride+x*d.n+c] = uint8((v + 0x101/2) / 0x101)
+				}
+			}
+		}
+	})
+	return d.m
+}
+
+// A reduction is an 8-bit copy of a 16-bit image being made: *image.Gray
+// for *image.Gray16, *image.NRGBA for *image.NRGBA64, and *image.RGBA for
+// anything else.
+type reduction struct {
+	m      image.Image
+	pix    []uint8
+	stride int
+	n      int // samples per pixel
+
+	// read reads the 16-bit samples of pixel x,y of the source, counted
+	// from its top left, into s in the order of pix
+	read func(x, y int, s []uint32)
+}
+
+func newReduction(m image.Image) reduction {
+	b := m.Bounds()
+	r := image.Rect(0, 0, b.Dx(), b.Dy())
+	switch src := m.(type) {
+	case *image.Gray16:
+		g := image.NewGray(r)
+		return reduction{g, g.Pix, g.Stride, 1, func(x, y int, s []uint32) {
+			s[0] = uint32(src.Gray16At(b.Min.X+x, b.Min.Y+y).Y)
+		}}
+	case *image.NRGBA64:
+		d := image.NewNRGBA(r)
+		return reduction{d, d.Pix, d.Stride, 4, func(x, y int, s []uint32) {
+			c := src.NRGBA64At(b.Min.X+x, b.Min.Y+y)
+			s[0], s[1], s[2], s[3] = uint32(c.R), uint32(c.G), uint32(c.B), uint32(c.A)
+		}}
+	}
+
+	c := mapPixels(m, identity, 0)
+	d := image.NewRGBA(r)
+	return reduction{d, d.Pix, d.Stride, 4, func(x, y int, s []uint32) {
+		p := c.Pix[y*c.Stride+x*8:]
+		s[0], s[1], s[2], s[3] = get16(p), get16(p[2:]), get16(p[4:]), 0xffff
+	}}
+}
@@ -0,0 +1,222 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package positive
+
+import (
+	"context"
+	"image"
+)
+
+// DefaultIRThreshold is the fraction of the typical infrared transmission
+// below which RemoveDust treats a pixel as a defect.
+const DefaultIRThreshold = 0.7
+
+// DefaultDustRadius is the radius Despeckle compares pixels to their
+// surroundings within if Options.DustRadius is 0.
+const DefaultDustRadius = 3
+
+// dustGrow is the number of pixels defects are grown by, to cover the soft
+// edges of dust that don't fall below the threshold themselves.
+const dustGrow = 2
+
+// RemoveDust removes dust and scratches from m using ir, the infrared channel
+// of the same scan. Dye images are nearly transparent to infrared light, while
+// dust and scratches block it, so pixels whose infrared transmission is below
+// threshold times the median are replaced by interpolating the surrounding
+// image. This doesn't work for silver based black and white film, which also
+// blocks infrared light.
+func RemoveDust(m image.Image, ir *image.Gray16, threshold float64) (image.Image, error) {
+	if ir.Rect.Size() != m.Bounds().Size() {
+		return nil, errorf(ErrImageMismatch, "infrared channel size does not match the image")
+	}
+	if threshold <= 0 || threshold >= 1 {
+		return nil, errorf(ErrInvalidOptions, "infrared threshold must be between 0 and 1")
+	}
+
+	ret := mapPixels(m, identity, 0)
+	inpaint(ret, dustMask(ir, threshold))
+	return ret, nil
+}
+
+// Despeckle removes dust and scratches from scans without an infrared
+// channel. Dust blocks light, so pixels whose luminance is more than
+// threshold, as a fraction, below the median of the surrounding square of the
+// given radius are replaced by interpolating the surrounding image. The
+// radius must be larger than the biggest specks to remove, and a lower
+// threshold catches fainter specks but also more fine image detail.
+func Despeckle(m image.Image, threshold float64, radius, threads int) *image.RGBA64 {
+	ret, _ := DespeckleContext(context.Background(), m, threshold, radius, threads)
+	return ret
+}
+
+// DespeckleContext is Despeckle, returning ctx.Err() if ctx is done first.
+func DespeckleContext(ctx context.Context, m image.Image, threshold float64, radius, threads int) (*image.RGBA64, error) {
+	ret, err := mapPixelsContext(ctx, m, identity, threads)
+	if err != nil {
+		return nil, err
+	}
+	defect := speckMask(ctx, ret, threshold, radius, threads)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	inpaint(ret, defect)
+	return ret, nil
+}
+
+// identity is a pixelFunc that doesn't change pixels, to copy images.
+func identity(r, g, b float32) (float32, float32, float32) {
+	return r, g, b
+}
+
+// dustMask returns the defects of the infrared channel ir, indexed by pixel
+// offset from the origin of ir.
+func dustMask(ir *image.Gray16, threshold float64) []bool {
+	w, h := ir.Rect.Dx(), ir.Rect.Dy()
+
+	var hist [65536]uint64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			hist[ir.Gray16At(ir.Rect.Min.X+x, ir.Rect.Min.Y+y).Y]++
+		}
+	}
+	var med int
+	var n uint64
+	for v, c := range hist {
+		n += c
+		if n*2 >= uint64(w*h) {
+			med = v
+			break
+		}
+	}
+	cutoff := uint16(float64(med) * threshold)
+
+	defect := make([]bool, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			defect[y*w+x] = ir.Gray16At(ir.Rect.Min.X+x, ir.Rect.Min.Y+y).Y < cutoff
+		}
+	}
+	return grow(defect, w, h)
+}
+
+// speckMask returns the pixels of m darker than threshold below their local
+// median luminance, indexed by pixel offset. The mask is incomplete if ctx is
+// done first.
+func speckMask(ctx context.Context, m *image.RGBA64, threshold float64, radius, threads int) []bool {
+	w, h := m.Rect.Dx(), m.Rect.Dy()
+
+	luma := make([]uint16, w*h)
+	for i := range luma {
+		p := m.Pix[(i/w)*m.Stride+(i%w)*8:]
+		luma[i] = uint16((19595*get16(p) + 38470*get16(p[2:]) + 7471*get16(p[4:]) + 1<<15) >> 16)
+	}
+
+	defect := make([]bool, w*h)
+	stripesContext(ctx, m.Rect, threads, func(stripe image.Rectangle) {
+		window := make([]uint16, 0, (2*radius+1)*(2*radius+1))
+		for y := stripe.Min.Y; y < stripe.Max.Y; y++ {
+			for x := 0; x < w; x++ {
+				window = window[:0]
+				for ny := y - radius; ny <= y+radius; ny++ {
+					for nx := x - radius; nx <= x+radius; nx++ {
+						if nx >= 0 && nx < w && ny >= 0 && ny < h {
+							window = append(window, luma[ny*w+nx])
+						}
+					}
+				}
+				med := median(window)
+				defect[y*w+x] = float64(luma[y*w+x]) < float64(med)*(1-threshold)
+			}
+		}
+	})
+	return grow(defect, w, h)
+}
+
+// median returns the median of v, reordering it.
+func median(v []uint16) uint16 {
+	// windows are small, so an insertion sort is fast enough
+	for i := 1; i < len(v); i++ {
+		for j := i; j > 0 && v[j] < v[j-1]; j-- {
+			v[j], v[j-1] = v[j-1], v[j]
+		}
+	}
+	return v[len(v)/2]
+}
+
+// grow returns the defects, of a w by h image, grown by dustGrow pixels.
+func grow(defect []bool, w, h int) []bool {
+	ret := make([]bool, len(defect))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if !defect[y*w+x] {
+				continue
+			}
+			for dy := -dustGrow; dy <= dustGrow; dy++ {
+				for dx := -dustGrow; dx <= dustGrow; dx++ {
+					nx, ny := x+dx, y+dy
+					if nx >= 0 && nx < w && ny >= 0 && ny < h {
+						ret[ny*w+nx] = true
+					}
+				}
+			}
+		}
+	}
+	return ret
+}
+
+// inpaint fills the pixels of m marked in defect from the outside in, setting
+// each to the mean of its already valid neighbors, until every defect is
+// filled.
+func inpaint(m *image.RGBA64, defect []bool) {
+	w, h := m.Rect.Dx(), m.Rect.Dy()
+
+	type fill struct {
+		i       int
+		r, g, b uint32
+	}
+
+	for {
+		var fills []fill
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				if !defect[y*w+x] {
+					continue
+				}
+
+				var r, g, b, n uint32
+				for dy := -1; dy <= 1; dy++ {
+					for dx := -1; dx <= 1; dx++ {
+						nx, ny := x+dx, y+dy
+						if nx < 0 || nx >= w || ny < 0 || ny >= h || defect[ny*w+nx] {
+							continue
+						}
+						p := m.Pix[ny*m.Stride+nx*8:]
+						r += get16(p)
+						g += get16(p[2:])
+						b += get16(p[4:])
+						n++
+					}
+				}
+				if n > 0 {
+					fills = append(fills, fill{y*w + x, r / n, g / n, b / n})
+				}
+			}
+		}
+
+		// nothing left, or no valid pixels to fill from
+		if len(fills) == 0 {
+			return
+		}
+
+		for _, f := range fills {
+			p := m.Pix[(f.i/w)*m.Stride+(f.i%w)*8:]
+			put16(p, f.r)
+			put16(p[2:], f.g)
+			put16(p[4:], f.b)
+			defect[f.i] = false
+		}
+	}
+}
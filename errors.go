@@ -0,0 +1,78 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package positive
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+)
+
+// Kinds of errors returned by the package, which can be told apart with
+// errors.Is. ErrInvalidOptions, ErrUnknownProfile, and ErrBadProfile are
+// configuration errors, which would fail every image the same way, while
+// the others are specific to an image.
+var (
+	// ErrInvalidOptions is the kind of error for options or arguments that
+	// can't be used with any image.
+	ErrInvalidOptions = errors.New("invalid options")
+
+	// ErrUnknownProfile is the kind of error for a gamma profile name that
+	// isn't in Profiles.
+	ErrUnknownProfile = errors.New("unknown gamma profile")
+
+	// ErrBadProfile is the kind of error for a profile file that can't be
+	// loaded.
+	ErrBadProfile = errors.New("bad profile file")
+
+	// ErrDecode is the kind of error for input that can't be decoded as an
+	// image.
+	ErrDecode = errors.New("can't decode image")
+
+	// ErrBadBaseSample is the kind of error for a film mask sample that
+	// can't be removed, such as one taken outside the image.
+	ErrBadBaseSample = errors.New("bad film base sample")
+
+	// ErrImageMismatch is the kind of error for an image that doesn't fit
+	// the options or the other images it is used with, such as a flat field
+	// of a different size.
+	ErrImageMismatch = errors.New("image mismatch")
+)
+
+// kindError is an error of one of the kinds above, with its own message.
+type kindError struct {
+	kind error
+	msg  string
+}
+
+func (e kindError) Error() string { return e.msg }
+func (e kindError) Unwrap() error { return e.kind }
+
+// errorf returns an error of the given kind, formatted like fmt.Errorf.
+func errorf(kind error, format string, args ...any) error {
+	return kindError{kind, fmt.Sprintf(format, args...)}
+}
+
+// LookupProfile returns the named profile from Profiles, or an
+// ErrUnknownProfile error.
+func LookupProfile(name string) (Profile, error) {
+	p, ok := Profiles[name]
+	if !ok {
+		return Profile{}, errorf(ErrUnknownProfile, "unknown gamma profile %q", name)
+	}
+	return p, nil
+}
+
+// Decode decodes an image in any format registered with the image package,
+// returning an ErrDecode error if it can't be decoded.
+func Decode(r io.Reader) (image.Image, error) {
+	m, _, err := image.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecode, err)
+	}
+	return m, nil
+}
@@ -0,0 +1,17 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package positive
+
+import "testing"
+
+// TestSharpenDefaultRadius checks a radius of 0 sharpens with
+// DefaultSharpenRadius, rather than a kernel of NaN.
+func TestSharpenDefaultRadius(t *testing.T) {
+	m := negative(64, 48, gradient)
+	if err := compareImages(Sharpen(m, 1, 0, 0, 1), Sharpen(m, 1, DefaultSharpenRadius, 0, 1), 0); err != nil {
+		t.Error(err)
+	}
+}
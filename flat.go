@@ -0,0 +1,129 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package positive
+
+import (
+	"context"
+	"image"
+)
+
+const (
+	// radius the flat field is smoothed by, so its grain and noise aren't
+	// imprinted on the corrected image
+	flatRadius = 4
+
+	// largest gain flat field correction applies, so nearly black areas of
+	// the flat field, such as the edge of a holder, aren't blown out
+	flatMaxGain = 16
+)
+
+// FlatField corrects uneven illumination of m, such as light source falloff
+// and lens vignetting, by dividing it by flat, a capture of the empty light
+// source with the same setup. Each channel is divided by the flat field
+// relative to its brightest point, so the brightest point is unchanged and
+// the rest of the image is brightened to match. Rows are processed
+// concurrently by up to threads goroutines.
+func FlatField(m, flat image.Image, threads int) (*image.RGBA64, error) {
+	return FlatFieldContext(context.Background(), m, flat, threads)
+}
+
+// FlatFieldContext is FlatField, returning ctx.Err() if ctx is done first.
+func FlatFieldContext(ctx context.Context, m, flat image.Image, threads int) (*image.RGBA64, error) {
+	if flat.Bounds().Size() != m.Bounds().Size() {
+		return nil, errorf(ErrImageMismatch, "flat field size does not match the image")
+	}
+
+	f, err := mapPixelsContext(ctx, flat, identity, threads)
+	if err != nil {
+		return nil, err
+	}
+	w, h := f.Rect.Dx(), f.Rect.Dy()
+
+	// smoothed flat field and its peak, per channel
+	var planes [3][]float32
+	var peak [3]float32
+	for c := range planes {
+		p := make([]float32, w*h)
+		for i := range p {
+			p[i] = float32(get16(f.Pix[(i/w)*f.Stride+(i%w)*8+c*2:]))
+		}
+		p = boxBlur(ctx, p, w, h, flatRadius, threads)
+		for _, v := range p {
+			if v > peak[c] {
+				peak[c] = v
+			}
+		}
+		planes[c] = p
+	}
+
+	ret, err := mapPixelsContext(ctx, m, identity, threads)
+	if err != nil {
+		return nil, err
+	}
+	err = stripesContext(ctx, image.Rect(0, 0, w, h), threads, func(stripe image.Rectangle) {
+		for y := stripe.Min.Y; y < stripe.Max.Y; y++ {
+			for x := 0; x < w; x++ {
+				d := ret.Pix[y*ret.Stride+x*8:]
+				for c := range planes {
+					gain := float64(flatMaxGain)
+					if v := planes[c][y*w+x]; v*flatMaxGain > peak[c] {
+						gain = float64(peak[c] / v)
+					}
+					put16(d[c*2:], clip(float64(get16(d[c*2:]))*gain))
+				}
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// SubtractDark subtracts dark, a capture with the same camera settings and
+// the lens capped, from m, removing the sensor's fixed pattern noise, bias,
+// and hot pixels. Rows are processed concurrently by up to threads
+// goroutines.
+func SubtractDark(m, dark image.Image, threads int) (*image.RGBA64, error) {
+	return SubtractDarkContext(context.Background(), m, dark, threads)
+}
+
+// SubtractDarkContext is SubtractDark, returning ctx.Err() if ctx is done
+// first.
+func SubtractDarkContext(ctx context.Context, m, dark image.Image, threads int) (*image.RGBA64, error) {
+	if dark.Bounds().Size() != m.Bounds().Size() {
+		return nil, errorf(ErrImageMismatch, "dark frame size does not match the image")
+	}
+
+	d, err := mapPixelsContext(ctx, dark, identity, threads)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := mapPixelsContext(ctx, m, identity, threads)
+	if err != nil {
+		return nil, err
+	}
+	w, h := d.Rect.Dx(), d.Rect.Dy()
+	err = stripesContext(ctx, image.Rect(0, 0, w, h), threads, func(stripe image.Rectangle) {
+		for y := stripe.Min.Y; y < stripe.Max.Y; y++ {
+			for x := 0; x < w; x++ {
+				p := ret.Pix[y*ret.Stride+x*8:]
+				q := d.Pix[y*d.Stride+x*8:]
+				for c := 0; c < 6; c += 2 {
+					v, dv := get16(p[c:]), get16(q[c:])
+					if v < dv {
+						v = dv
+					}
+					put16(p[c:], v-dv)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
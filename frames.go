@@ -0,0 +1,175 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package positive
+
+import (
+	"image"
+	"math"
+)
+
+const (
+	// lines across a scan whose luminance varies less than gapFlat, and is
+	// within gapLevel of the brightest or darkest line, are gaps between
+	// frames: unexposed film base or an opaque holder
+	gapFlat  = 0.03 * 0xffff
+	gapLevel = 0.05 * 0xffff
+
+	// narrowest gap split at, in pixels, so noise doesn't split frames
+	gapMin = 3
+)
+
+// DetectFrames finds the frames in m, a scan of one or more film strips, by
+// the unexposed gaps between them, or the holder between strips, returning
+// them in order from the top left. Each frame extends halfway into the gaps
+// around it, so the unexposed film at its edges can still be used to estimate
+// the film mask. A scan of a single frame is returned as is. Frames are in
+// the coordinates of m, to be cropped with Crop.
+func DetectFrames(m image.Image) []image.Rectangle {
+	c := mapPixels(m, identity, 0)
+
+	// strips are split first along their length, then across, and then
+	// along again to find the frames of strips side by side in a holder
+	r := c.Rect
+	frames := splitFrames(c, r, r.Dx() > r.Dy(), 3)
+	for i := range frames {
+		frames[i] = frames[i].Add(m.Bounds().Min)
+	}
+	return frames
+}
+
+// splitFrames splits r along the x axis if vertical is true, or the y axis
+// if not, and then recursively splits each piece the other way, depth times.
+func splitFrames(m *image.RGBA64, r image.Rectangle, vertical bool, depth int) []image.Rectangle {
+	if depth == 0 {
+		return []image.Rectangle{r}
+	}
+
+	var ret []image.Rectangle
+	for _, p := range splitGaps(m, r, vertical) {
+		ret = append(ret, splitFrames(m, p, !vertical, depth-1)...)
+	}
+	return ret
+}
+
+// splitGaps splits r at the gaps between frames. If vertical is true, the
+// gaps are columns of r, otherwise rows.
+func splitGaps(m *image.RGBA64, r image.Rectangle, vertical bool) []image.Rectangle {
+	// length of r along and across the split
+	n, across := r.Dy(), r.Dx()
+	if vertical {
+		n, across = r.Dx(), r.Dy()
+	}
+	if n == 0 || across == 0 {
+		return []image.Rectangle{r}
+	}
+
+	// mean and standard deviation of the luminance of the middle half of
+	// each line, leaving out the sprocket holes and edge printing along the
+	// sides of a strip
+	mean := make([]float64, n)
+	sd := make([]float64, n)
+	for i := 0; i < n; i++ {
+		var sum, sum2 float64
+		for j := across / 4; j < across-across/4; j++ {
+			x, y := r.Min.X+j, r.Min.Y+i
+			if vertical {
+				x, y = r.Min.X+i, r.Min.Y+j
+			}
+			p := m.Pix[y*m.Stride+x*8:]
+			v := 0.299*float64(get16(p)) + 0.587*float64(get16(p[2:])) + 0.114*float64(get16(p[4:]))
+			sum += v
+			sum2 += v * v
+		}
+		k := float64(across - across/4*2)
+		mean[i] = sum / k
+		sd[i] = math.Sqrt(math.Max(0, sum2/k-mean[i]*mean[i]))
+	}
+
+	lo, hi := mean[0], mean[0]
+	for _, v := range mean {
+		if v < lo {
+			lo = v
+		}
+		if v > hi {
+			hi = v
+		}
+	}
+
+	gap := make([]bool, n)
+	for i := range gap {
+		gap[i] = sd[i] < gapFlat && (mean[i] > hi-gapLevel || mean[i] < lo+gapLevel)
+	}
+
+	// gaps too narrow to split at are part of the frame, and frames are
+	// at least a quarter as long as they are wide, even half frame or
+	// panoramic, so shorter runs are sprocket holes or edge printing
+	fill(gap, false, gapMin)
+	fill(gap, true, across/4)
+
+	// split at the middle of each gap with a frame on both sides
+	var cuts []int
+	for i := 0; i < n; i++ {
+		if !gap[i] || i == 0 || gap[i-1] {
+			continue
+		}
+		j := i
+		for j < n && gap[j] {
+			j++
+		}
+		if j < n {
+			cuts = append(cuts, (i+j)/2)
+		}
+	}
+
+	var ret []image.Rectangle
+	start := 0
+	for _, c := range append(cuts, n) {
+		p := r
+		if vertical {
+			p.Min.X, p.Max.X = r.Min.X+start, r.Min.X+c
+		} else {
+			p.Min.Y, p.Max.Y = r.Min.Y+start, r.Min.Y+c
+		}
+		ret = append(ret, p)
+		start = c
+	}
+	return ret
+}
+
+// fill sets runs of values in v other than to that are shorter than min to
+// to.
+func fill(v []bool, to bool, min int) {
+	for i := 0; i < len(v); {
+		if v[i] == to {
+			i++
+			continue
+		}
+		j := i
+		for j < len(v) && v[j] != to {
+			j++
+		}
+		if j-i < min {
+			for k := i; k < j; k++ {
+				v[k] = to
+			}
+		}
+		i = j
+	}
+}
+
+// Crop returns a copy of the rectangle r of m, with its origin at 0,0.
+func Crop(m image.Image, r image.Rectangle) *image.RGBA64 {
+	r = r.Intersect(m.Bounds())
+	ret := image.NewRGBA64(image.Rect(0, 0, r.Dx(), r.Dy()))
+	stripes(ret.Rect, 0, func(stripe image.Rectangle) {
+		for y := stripe.Min.Y; y < stripe.Max.Y; y++ {
+			for x := 0; x < r.Dx(); x++ {
+				ret.Set(x, y, m.At(r.Min.X+x, r.Min.Y+y))
+			}
+		}
+	})
+	return ret
+}
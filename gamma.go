@@ -0,0 +1,82 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package positive
+
+import (
+	"image"
+	"math"
+)
+
+// number of intervals curves are sampled at by default, one per 16-bit value
+const curveSteps = 0xffff
+
+// A curve maps channel values through a function f, precomputed at steps+1
+// evenly spaced points over [0,1] and interpolated linearly between them, so
+// the cost per pixel is independent of f. Values outside [0,1], and within
+// the first interval, where power functions are too steep to interpolate,
+// are passed to f itself, which must handle them.
+type curve struct {
+	f     func(float64) float64
+	t     []float32
+	steps float32
+}
+
+func newCurve(steps int, f func(float64) float64) *curve {
+	c := &curve{f: f, t: make([]float32, steps+1), steps: float32(steps)}
+	for i := range c.t {
+		c.t[i] = float32(f(float64(i) / float64(steps)))
+	}
+	return c
+}
+
+// at returns the curve's value at v.
+func (c *curve) at(v float32) float32 {
+	x := v * c.steps
+	if !(x >= 1 && x < c.steps) {
+		return float32(c.f(float64(v)))
+	}
+	i := int(x)
+	t := c.t[i : i+2 : i+2]
+	return t[0] + (t[1]-t[0])*(x-float32(i))
+}
+
+// curveFunc returns a pixelFunc mapping each channel through its curve.
+func curveFunc(rc, gc, bc *curve) pixelFunc {
+	return func(r, g, b float32) (float32, float32, float32) {
+		return rc.at(r), gc.at(g), bc.at(b)
+	}
+}
+
+// gammaCurve precomputes a gamma correction with exponent e, which maps 0
+// and 1 to themselves, extended to negative values by symmetry.
+func gammaCurve(e float64) *curve {
+	return newCurve(curveSteps, func(v float64) float64 {
+		if v < 0 {
+			return -math.Pow(-v, e)
+		}
+		return math.Pow(v, e)
+	})
+}
+
+// ApplyGamma applies a 0,1 bound gamma correction with the given per channel
+// exponents. The correction is precomputed into lookup tables, so the cost per
+// pixel is independent of the exponents.
+func ApplyGamma(m image.Image, rg, gg, bg float64) image.Image {
+	return mapPixels(m, gammaFunc(rg, gg, bg), 0)
+}
+
+func gammaFunc(rg, gg, bg float64) pixelFunc {
+	rc := gammaCurve(rg)
+	gc := rc
+	if gg != rg {
+		gc = gammaCurve(gg)
+	}
+	bc := gc
+	if bg != gg {
+		bc = gammaCurve(bg)
+	}
+	return curveFunc(rc, gc, bc)
+}
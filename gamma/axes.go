@@ -0,0 +1,103 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package gamma
+
+import (
+	"image"
+	"sort"
+)
+
+// Any channel below this (out of 0xffff) marks a pixel as part of a grid line
+// or tick mark. This is lighter than BLACK_POINT, as grids are often gray.
+const GRID_POINT uint32 = 0xd000
+
+// Fraction of a column (or row) that must be marked for it to be a grid line.
+const GRID_FILL = 0.6
+
+// Axes are the detected grid spacings of a plot, in pixels.
+type Axes struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// findAxes detects the spacing between vertical and horizontal grid lines. If
+// the plot has no grid, tick marks along the bottom and left edges are used
+// instead. ok is false if either spacing could not be found.
+func findAxes(m image.Image) (a Axes, ok bool) {
+	b := m.Bounds()
+
+	// full height grid lines, or ticks within the bottom tenth
+	a.X = spacing(lines(m, b, true))
+	if a.X == 0 {
+		band := b
+		band.Min.Y = b.Max.Y - b.Dy()/10
+		a.X = spacing(lines(m, band, true))
+	}
+
+	// full width grid lines, or ticks within the left tenth
+	a.Y = spacing(lines(m, b, false))
+	if a.Y == 0 {
+		band := b
+		band.Max.X = b.Min.X + b.Dx()/10
+		a.Y = spacing(lines(m, band, false))
+	}
+
+	return a, a.X != 0 && a.Y != 0
+}
+
+// lines returns the centers of the columns (vertical) or rows that are mostly
+// marked within r. Adjacent marked columns are merged into a single line.
+func lines(m image.Image, r image.Rectangle, vertical bool) []float64 {
+	outer, inner := r.Dx(), r.Dy()
+	if !vertical {
+		outer, inner = inner, outer
+	}
+
+	var ret []float64
+	run := -1
+	for i := 0; i <= outer; i++ {
+		marked := false
+		if i < outer {
+			var n int
+			for j := 0; j < inner; j++ {
+				x, y := r.Min.X+i, r.Min.Y+j
+				if !vertical {
+					x, y = r.Min.X+j, r.Min.Y+i
+				}
+				cr, cg, cb, _ := m.At(x, y).RGBA()
+				if cr < GRID_POINT || cg < GRID_POINT || cb < GRID_POINT {
+					n++
+				}
+			}
+			marked = float64(n) >= GRID_FILL*float64(inner)
+		}
+
+		if marked && run < 0 {
+			run = i
+		} else if !marked && run >= 0 {
+			ret = append(ret, float64(run+i-1)/2)
+			run = -1
+		}
+	}
+
+	return ret
+}
+
+// spacing returns the median distance between consecutive lines, or 0 if there
+// are fewer than three lines. Three are required so that the plot's frame
+// alone isn't mistaken for a grid.
+func spacing(l []float64) float64 {
+	if len(l) < 3 {
+		return 0
+	}
+
+	var d []float64
+	for i := 1; i < len(l); i++ {
+		d = append(d, l[i]-l[i-1])
+	}
+	sort.Float64s(d)
+	return d[len(d)/2]
+}
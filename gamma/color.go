@@ -0,0 +1,102 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package gamma
+
+import (
+	"image"
+)
+
+// Minimum amount (out of 0xffff) an ink's channel must exceed the other two
+// channels by for a pixel to be considered part of that ink's curve.
+const INK_MARGIN uint32 = 0x4000
+
+// colorCurves extracts the curves of a plot drawn in red, green, and blue
+// ink. Unlike lineCurves, this doesn't depend on the order the curves appear
+// in each column, so it works when curves cross. Each curve starts at the
+// first column it is found in, relative to the left of m, which is returned in
+// start, and columns where
+// it is hidden are interpolated.
+func colorCurves(m image.Image) (red, green, blue []int, start [3]int) {
+	bounds := m.Bounds()
+
+	// mean height of each ink in each column, -1 if not present
+	var cols [3][]float64
+	for x := bounds.Min.X; x < bounds.Max.X; x++ {
+		var sum [3]int
+		var n [3]int
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			if c := ink(m.At(x, y).RGBA()); c >= 0 {
+				sum[c] += bounds.Max.Y - y
+				n[c]++
+			}
+		}
+		for c := range cols {
+			v := -1.0
+			if n[c] > 0 {
+				v = float64(sum[c]) / float64(n[c])
+			}
+			cols[c] = append(cols[c], v)
+		}
+	}
+
+	var ys [3][]int
+	for c := range cols {
+		ys[c], start[c] = fill(cols[c])
+	}
+
+	return ys[0], ys[1], ys[2], start
+}
+
+// ink returns 0, 1, or 2 if the color is predominantly red, green, or blue,
+// and -1 otherwise.
+func ink(r, g, b, _ uint32) int {
+	switch {
+	case r > g+INK_MARGIN && r > b+INK_MARGIN:
+		return 0
+	case g > r+INK_MARGIN && g > b+INK_MARGIN:
+		return 1
+	case b > r+INK_MARGIN && b > g+INK_MARGIN:
+		return 2
+	}
+	return -1
+}
+
+// fill trims missing (negative) values from both ends of v and linearly
+// interpolates missing values in between, returning the result and the index
+// of the first value.
+func fill(v []float64) ([]int, int) {
+	first, last := -1, -1
+	for i, f := range v {
+		if f >= 0 {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	if first < 0 {
+		return nil, 0
+	}
+
+	var ret []int
+	prev := first
+	for i := first; i <= last; i++ {
+		if v[i] >= 0 {
+			prev = i
+			ret = append(ret, int(v[i]+0.5))
+			continue
+		}
+
+		next := i + 1
+		for v[next] < 0 {
+			next++
+		}
+		t := float64(i-prev) / float64(next-prev)
+		ret = append(ret, int(v[prev]+(v[next]-v[prev])*t+0.5))
+	}
+
+	return ret, first
+}
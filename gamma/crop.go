@@ -0,0 +1,74 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package gamma
+
+import (
+	"fmt"
+	"image"
+)
+
+// Any channel below this (out of 0xffff) marks a pixel as part of the plot
+// when finding its bounding box.
+const PAD_POINT uint32 = 0xf000
+
+// crop returns the part of m to extract curves from. spec is either empty for
+// the whole image, "auto" to trim the padding around the plot, or a rectangle
+// as "x0,y0,x1,y1".
+func crop(m image.Image, spec string) (image.Image, error) {
+	var r image.Rectangle
+	switch spec {
+	case "":
+		return m, nil
+	case "auto":
+		r = plotBounds(m)
+	default:
+		if _, err := fmt.Sscanf(spec, "%d,%d,%d,%d", &r.Min.X, &r.Min.Y, &r.Max.X, &r.Max.Y); err != nil {
+			return nil, fmt.Errorf("invalid crop rectangle %q: %v", spec, err)
+		}
+		r = r.Canon().Intersect(m.Bounds())
+	}
+
+	if r.Empty() {
+		return nil, fmt.Errorf("empty crop rectangle %v", r)
+	}
+
+	s, ok := m.(interface {
+		SubImage(image.Rectangle) image.Image
+	})
+	if !ok {
+		return nil, fmt.Errorf("unable to crop %T", m)
+	}
+	return s.SubImage(r), nil
+}
+
+// plotBounds returns the bounding box of all non-background pixels of m.
+func plotBounds(m image.Image) image.Rectangle {
+	b := m.Bounds()
+	r := image.Rectangle{Min: b.Max, Max: b.Min}
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			cr, cg, cb, _ := m.At(x, y).RGBA()
+			if cr >= PAD_POINT && cg >= PAD_POINT && cb >= PAD_POINT {
+				continue
+			}
+			if x < r.Min.X {
+				r.Min.X = x
+			}
+			if y < r.Min.Y {
+				r.Min.Y = y
+			}
+			if x >= r.Max.X {
+				r.Max.X = x + 1
+			}
+			if y >= r.Max.Y {
+				r.Max.Y = y + 1
+			}
+		}
+	}
+
+	return r
+}
@@ -0,0 +1,45 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package gamma
+
+// curve fits the extracted points y, which are evenly distributed along the
+// x axis starting at column start of a w by h plot, with a piecewise-linear
+// model of n segments. Knots are x,y pairs as fractions of the plot size. Each
+// knot is the average of the points within half a segment of it, which smooths
+// out line thickness and anti-aliasing while keeping the toe and shoulder that
+// a single slope discards.
+func curve(y []int, start, w, h, n int) [][2]float64 {
+	if len(y) < 2 || n < 1 {
+		return nil
+	}
+	if n > len(y)-1 {
+		n = len(y) - 1
+	}
+
+	step := float64(len(y)-1) / float64(n)
+	var ret [][2]float64
+	for k := 0; k <= n; k++ {
+		center := float64(k) * step
+		lo := int(center - step/2 + 0.5)
+		hi := int(center + step/2 + 0.5)
+		if lo < 0 {
+			lo = 0
+		}
+		if hi > len(y)-1 {
+			hi = len(y) - 1
+		}
+
+		var sum float64
+		for i := lo; i <= hi; i++ {
+			sum += float64(y[i])
+		}
+		avg := sum / float64(hi-lo+1)
+
+		ret = append(ret, [2]float64{(float64(start) + center) / float64(w), avg / float64(h)})
+	}
+
+	return ret
+}
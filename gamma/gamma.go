@@ -0,0 +1,236 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+// Package gamma extracts film gamma profiles from the characteristic curve
+// plots published in film datasheets. By default the plot is expected to hold
+// black curves, ordered red, green, blue from the bottom up; the slope of each
+// curve is found by linear regression, and the full curve is fitted with a
+// piecewise-linear model.
+package gamma
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+
+	"github.com/djfritz/positive"
+)
+
+const BLACK_POINT uint32 = 32768
+
+// Options control how curves are extracted.
+type Options struct {
+	// BW extracts a single curve, used for all three channels.
+	BW bool
+
+	// Color identifies curves by ink color instead of by their order.
+	Color bool
+
+	// Crop is the plot rectangle as "x0,y0,x1,y1", or "auto" to trim
+	// padding around the plot. If empty, the whole image is used.
+	Crop string
+
+	// Knots is the number of segments in the piecewise-linear curve fit.
+	Knots int
+
+	// Calibrate scales results to log exposure and density units using
+	// the plot's grid or tick marks. XStep and YStep are the log exposure
+	// and density between grid lines.
+	Calibrate bool
+	XStep     float64
+	YStep     float64
+}
+
+// A Result is an extracted profile.
+type Result struct {
+	positive.Profile
+
+	// detected grid spacing in pixels, when calibrated
+	Axes *Axes `json:"axes,omitempty"`
+}
+
+// Extract extracts a gamma profile from the datasheet plot m.
+func Extract(m image.Image, o Options) (*Result, error) {
+	m, err := crop(m, o.Crop)
+	if err != nil {
+		return nil, err
+	}
+	bounds := m.Bounds()
+
+	var red, green, blue []int
+	var start [3]int
+	if o.Color {
+		red, green, blue, start = colorCurves(m)
+	} else {
+		red, green, blue = lineCurves(m, o.BW)
+	}
+
+	if o.BW {
+		green = red
+		blue = red
+	}
+
+	for i, c := range [][]int{red, green, blue} {
+		if len(c) < 2 {
+			return nil, fmt.Errorf("no %v curve found", []string{"red", "green", "blue"}[i])
+		}
+	}
+
+	p := &Result{}
+	p.Fit = &positive.Gamma{}
+	p.Curves = &positive.Curves{}
+
+	// calculate the slope
+	p.R, p.Fit.R = slope(red)
+	p.G, p.Fit.G = slope(green)
+	p.B, p.Fit.B = slope(blue)
+
+	p.Curves.R = curve(red, start[0], bounds.Dx(), bounds.Dy(), o.Knots)
+	p.Curves.G = curve(green, start[1], bounds.Dx(), bounds.Dy(), o.Knots)
+	p.Curves.B = curve(blue, start[2], bounds.Dx(), bounds.Dy(), o.Knots)
+
+	if o.Calibrate {
+		a, ok := findAxes(m)
+		if !ok {
+			return nil, errors.New("unable to find grid lines or tick marks to calibrate with")
+		}
+		p.Axes = &a
+
+		// pixels per unit on each axis
+		px := a.X / o.XStep
+		py := a.Y / o.YStep
+
+		p.R *= px / py
+		p.G *= px / py
+		p.B *= px / py
+		for _, c := range [][][2]float64{p.Curves.R, p.Curves.G, p.Curves.B} {
+			for i := range c {
+				c[i][0] *= float64(bounds.Dx()) / px
+				c[i][1] *= float64(bounds.Dy()) / py
+			}
+		}
+	} else {
+		// uncalibrated slopes treat the plot as a unit square, so
+		// non-square plots are scaled by their aspect ratio
+		aspect := float64(bounds.Dx()) / float64(bounds.Dy())
+		p.R *= aspect
+		p.G *= aspect
+		p.B *= aspect
+	}
+
+	return p, nil
+}
+
+// lineCurves extracts the curves of a plot drawn with black lines. Each column
+// is walked from the bottom up, and it's assumed the red, green, and blue
+// curves are encountered in that order.
+func lineCurves(m image.Image, bw bool) (red, green, blue []int) {
+	bounds := m.Bounds()
+
+OUTER:
+	for x := bounds.Min.X; x < bounds.Max.X; x++ {
+		// start from the max Y and walk upwards
+		// on these curves, it's going to encounter red, green, then
+		// blue, in that order.
+		y := bounds.Max.Y - 1
+
+		for {
+			if y == bounds.Min.Y {
+				break
+			}
+			if black(m.At(x, y)) {
+				break
+			}
+			y--
+		}
+
+		// red
+		red = append(red, bounds.Max.Y-y)
+
+		// get back to white
+		for {
+			if y == bounds.Min.Y || !black(m.At(x, y)) {
+				break
+			}
+			y--
+		}
+
+		for {
+			if y == bounds.Min.Y {
+				break
+			}
+			if black(m.At(x, y)) {
+				break
+			}
+			y--
+		}
+
+		if bw {
+			continue
+		}
+
+		// green
+		green = append(green, bounds.Max.Y-y)
+
+		// get back to white
+		for {
+			if y == bounds.Min.Y || !black(m.At(x, y)) {
+				break
+			}
+			y--
+		}
+
+		for {
+			if y == bounds.Min.Y {
+				// we've run out of data -- likely just the rightmost edge of the red curve
+				break OUTER
+			}
+			if black(m.At(x, y)) {
+				break
+			}
+			y--
+		}
+
+		// blue
+		blue = append(blue, bounds.Max.Y-y)
+	}
+
+	return red, green, blue
+}
+
+func black(c color.Color) bool {
+	r, b, g, _ := c.RGBA()
+
+	if r < BLACK_POINT && b < BLACK_POINT && g < BLACK_POINT {
+		return true
+	}
+	return false
+}
+
+// Calculate the slope of evently distributed points y using linear regression,
+// along with the coefficient of determination (r²) of the fit.
+func slope(y []int) (float64, float64) {
+	var meanx, meany float64
+	for i, v := range y {
+		meanx += float64(i)
+		meany += float64(v)
+	}
+	meanx /= float64(len(y))
+	meany /= float64(len(y))
+
+	var n, d, t float64
+	for i, v := range y {
+		n += (float64(i) - meanx) * (float64(v) - meany)
+		d += (float64(i) - meanx) * (float64(i) - meanx)
+		t += (float64(v) - meany) * (float64(v) - meany)
+	}
+
+	m := n / d
+	if t == 0 {
+		return m, 1
+	}
+	return m, (n * n) / (d * t)
+}
@@ -0,0 +1,296 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package positive
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden outputs in testdata/golden")
+
+// golden outputs may differ from a conversion by this many 16-bit steps per
+// channel, for floating point differences between architectures
+const goldenTolerance = 2
+
+// testBase is the film mask color of the synthetic negatives, a typical C-41
+// orange.
+var testBase = color.RGBA64{R: 0xd800, G: 0x9000, B: 0x6000, A: 0xffff}
+
+// negative returns a w by h synthetic negative of scene, which gives the
+// brightness of each channel, from 0 to 1, at x and y from 0 to 1. The film
+// is 1.0 denser at white than black, on top of the film mask, and the frame
+// is surrounded by a border of unexposed film 5% wide, within the border
+// normalization ignores.
+func negative(w, h int, scene func(x, y float64) (float64, float64, float64)) *image.RGBA64 {
+	m := image.NewRGBA64(image.Rect(0, 0, w, h))
+	frame := image.Rect(w/20, h/20, w-w/20, h-h/20)
+	density := func(base uint16, v float64) uint16 {
+		return uint16(math.Round(float64(base) * math.Pow(10, -v)))
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := testBase
+			if (image.Point{x, y}).In(frame) {
+				fx := (float64(x-frame.Min.X) + 0.5) / float64(frame.Dx())
+				fy := (float64(y-frame.Min.Y) + 0.5) / float64(frame.Dy())
+				r, g, b := scene(fx, fy)
+				c.R, c.G, c.B = density(testBase.R, r), density(testBase.G, g), density(testBase.B, b)
+			}
+			m.SetRGBA64(x, y, c)
+		}
+	}
+	return m
+}
+
+// gradient is a scene of color ramps: red increasing to the right, green
+// down, and blue along the diagonal.
+func gradient(x, y float64) (float64, float64, float64) {
+	return x, y, (x + y) / 2
+}
+
+// wedge is a neutral step wedge of 11 steps from black to white, left to
+// right.
+func wedge(x, y float64) (float64, float64, float64) {
+	v := math.Floor(x*11) / 10
+	return v, v, v
+}
+
+// grayWedge returns the step wedge scanned as black and white film, a
+// grayscale image with a clear film base.
+func grayWedge(w, h int) *image.Gray16 {
+	m := negative(w, h, wedge)
+	g := image.NewGray16(m.Bounds())
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			g.SetGray16(x, y, color.Gray16{m.RGBA64At(x, y).R})
+		}
+	}
+	return g
+}
+
+// specks returns m with a sparse pattern of dark dust specks.
+func specks(m *image.RGBA64) *image.RGBA64 {
+	b := m.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if (x*7+y*13)%89 == 0 {
+				m.SetRGBA64(x, y, color.RGBA64{A: 0xffff})
+			}
+		}
+	}
+	return m
+}
+
+// goldenCases are the conversions checked against golden outputs, and
+// benchmarked, each exercising one stage on top of the default conversion of
+// a synthetic negative of the given size with a known film mask.
+var goldenCases = []struct {
+	name string
+	m    func(w, h int) image.Image
+	o    func(o *Options)
+}{
+	{"mask", colorNegative, func(o *Options) { o.Normalize, o.Invert = false, false }},
+	{"gamma", colorNegative, func(o *Options) {
+		o.Gamma = Profiles["portra160"].Gamma
+		o.Normalize, o.Invert = false, false
+	}},
+	{"normalize", colorNegative, func(o *Options) { o.Invert = false }},
+	{"invert", colorNegative, func(o *Options) {}},
+	{"divide", colorNegative, func(o *Options) { o.Divide = true }},
+	{"density", colorNegative, func(o *Options) { o.Density = true }},
+	{"rolloff", colorNegative, func(o *Options) { o.Rolloff = 0.05 }},
+	{"linked", colorNegative, func(o *Options) { o.Linked = true }},
+	{"matrix", colorNegative, func(o *Options) {
+		o.Matrix = &Matrix{{1.2, -0.1, -0.1}, {-0.05, 1.1, -0.05}, {0, -0.2, 1.2}}
+	}},
+	{"light", colorNegative, func(o *Options) { o.FilmLight, o.Light = Daylight, Tungsten }},
+	{"grayworld", colorNegative, func(o *Options) { o.Balance = BalanceGrayWorld }},
+	{"highlights-balance", colorNegative, func(o *Options) { o.Balance = BalanceHighlights }},
+	{"temp-tint", colorNegative, func(o *Options) { o.Temp, o.Tint = 30, -20 }},
+	{"ev", colorNegative, func(o *Options) { o.EV = 0.5 }},
+	{"denoise", colorNegative, func(o *Options) { o.Denoise, o.DenoiseChroma = 0.5, 0.5 }},
+	{"local-contrast", colorNegative, func(o *Options) { o.LocalContrast = 1 }},
+	{"recovery", colorNegative, func(o *Options) { o.Highlights, o.Shadows = 0.5, 0.5 }},
+	{"midtone", colorNegative, func(o *Options) { o.Midtone = 1.4 }},
+	{"tone-soft", colorNegative, func(o *Options) { o.Tone = ToneSoft }},
+	{"tone-punchy", colorNegative, func(o *Options) { o.Tone, o.Contrast = TonePunchy, 0.5 }},
+	{"look-noritsu", colorNegative, func(o *Options) { o.Look = LookNoritsu }},
+	{"look-frontier", colorNegative, func(o *Options) { o.Look = LookFrontier }},
+	{"look-pakon", colorNegative, func(o *Options) { o.Look = LookPakon }},
+	{"colorspace", colorNegative, func(o *Options) { o.ColorSpace = ColorSpaceAdobeRGB }},
+	{"sharpen", colorNegative, func(o *Options) { o.Sharpen = 1 }},
+	{"dust", func(w, h int) image.Image { return specks(negative(w, h, gradient)) }, func(o *Options) { o.Dust = 0.2 }},
+	{"slide", colorNegative, func(o *Options) { o.Slide = true }},
+	{"wedge", wedgeNegative, func(o *Options) {}},
+	{"wedge-density", wedgeNegative, func(o *Options) { o.Density = true }},
+	{"wedge-portra160", wedgeNegative, func(o *Options) { o.Gamma = Profiles["portra160"].Gamma }},
+	{"bw", func(w, h int) image.Image { return grayWedge(w, h) }, func(o *Options) {}},
+	{"toning", func(w, h int) image.Image { return grayWedge(w, h) }, func(o *Options) { o.Toning = ToningSepia }},
+}
+
+func colorNegative(w, h int) image.Image { return negative(w, h, gradient) }
+func wedgeNegative(w, h int) image.Image { return negative(w, h, wedge) }
+
+// goldenOptions returns the default options with the film mask of the
+// synthetic negatives, changed by f.
+func goldenOptions(f func(o *Options)) Options {
+	o := DefaultOptions()
+	o.Base = testBase
+	f(&o)
+	if o.Slide {
+		o.Base = nil
+	}
+	return o
+}
+
+// TestGolden converts the synthetic negatives of goldenCases and compares
+// them to testdata/golden. Run with -update to rewrite the golden outputs
+// after an intended change, and review the differences before committing.
+func TestGolden(t *testing.T) {
+	for _, c := range goldenCases {
+		t.Run(c.name, func(t *testing.T) {
+			p, err := Process(c.m(64, 48), goldenOptions(c.o))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			path := filepath.Join("testdata", "golden", c.name+".png")
+			if *update {
+				if err := writePNG(path, p); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := readPNG(path)
+			if err != nil {
+				t.Fatalf("%v, run with -update to create it", err)
+			}
+			if err := compareImages(p, want, goldenTolerance); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// TestGoldenStripes converts a negative large enough to be split into many
+// stripes of rows, both at once and a stripe at a time with ProcessTiled, and
+// compares both to testdata/golden/stripes.png.
+func TestGoldenStripes(t *testing.T) {
+	m := negative(320, 240, gradient)
+	o := goldenOptions(func(o *Options) { o.Threads = 4 })
+
+	p, err := Process(m, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join("testdata", "golden", "stripes.png")
+	if *update {
+		if err := writePNG(path, p); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := readPNG(path)
+	if err != nil {
+		t.Fatalf("%v, run with -update to create it", err)
+	}
+	if err := compareImages(p, want, goldenTolerance); err != nil {
+		t.Error(err)
+	}
+
+	tiled := image.NewRGBA64(m.Bounds())
+	var y int
+	err = ProcessTiled(context.Background(), imageRows{m}, o, 64, func(s image.Image) error {
+		b := s.Bounds()
+		draw.Draw(tiled, image.Rect(0, y, b.Dx(), y+b.Dy()), s, b.Min, draw.Src)
+		y += b.Dy()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := compareImages(tiled, want, goldenTolerance); err != nil {
+		t.Errorf("tiled: %v", err)
+	}
+}
+
+// imageRows reads the rows of an image in memory, for ProcessTiled.
+type imageRows struct{ m *image.RGBA64 }
+
+func (r imageRows) Size() image.Point { return r.m.Bounds().Size() }
+
+func (r imageRows) ReadRows(m *image.RGBA64, y int) error {
+	draw.Draw(m, m.Bounds(), r.m, image.Pt(0, y), draw.Src)
+	return nil
+}
+
+// TestEstimateKnownMask checks the film mask is found in the unexposed
+// border of the synthetic negatives.
+func TestEstimateKnownMask(t *testing.T) {
+	c, ok := EstimateBase(colorNegative(64, 48))
+	if !ok {
+		t.Fatal("no film mask found")
+	}
+	r, g, b, _ := c.RGBA()
+	if r != uint32(testBase.R) || g != uint32(testBase.G) || b != uint32(testBase.B) {
+		t.Errorf("film mask %v,%v,%v, want %v,%v,%v", r, g, b, testBase.R, testBase.G, testBase.B)
+	}
+}
+
+// compareImages returns an error describing the first pixel of a and b that
+// differs by more than tolerance in any channel, or if their sizes differ.
+func compareImages(a, b image.Image, tolerance int) error {
+	ab, bb := a.Bounds(), b.Bounds()
+	if ab.Size() != bb.Size() {
+		return fmt.Errorf("size %v, want %v", ab.Size(), bb.Size())
+	}
+	for y := 0; y < ab.Dy(); y++ {
+		for x := 0; x < ab.Dx(); x++ {
+			ar, ag, abl, _ := a.At(ab.Min.X+x, ab.Min.Y+y).RGBA()
+			br, bg, bbl, _ := b.At(bb.Min.X+x, bb.Min.Y+y).RGBA()
+			for i, d := range []int{int(ar) - int(br), int(ag) - int(bg), int(abl) - int(bbl)} {
+				if d < -tolerance || d > tolerance {
+					return fmt.Errorf("pixel %v,%v channel %v: %v,%v,%v, want %v,%v,%v", x, y, i, ar, ag, abl, br, bg, bbl)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func readPNG(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
+
+func writePNG(path string, m image.Image) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, m); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,124 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package positive
+
+import (
+	"context"
+	"image"
+)
+
+const (
+	// values above hdrClip are considered clipped, and below hdrFloor too
+	// noisy to measure the exposure difference between captures with
+	hdrClip  = 0xf000
+	hdrFloor = 0x0400
+)
+
+// MergeHDR merges ms, bracketed captures of the same frame taken without
+// moving the film, into a single linear image with the detail of each. The
+// exposure difference between captures is measured from the pixels well
+// exposed in both, and each pixel is the exposure weighted average of the
+// captures that don't clip it, scaled to the darkest capture. Longer
+// exposures lift the densest parts of the negative, such as the highlights
+// of an overexposed negative, out of the scanner's noise. The captures must
+// be linear, not gamma encoded. Rows are processed concurrently by up to
+// threads goroutines.
+func MergeHDR(ms []image.Image, threads int) (*image.RGBA64, error) {
+	return MergeHDRContext(context.Background(), ms, threads)
+}
+
+// MergeHDRContext is MergeHDR, returning ctx.Err() if ctx is done first.
+func MergeHDRContext(ctx context.Context, ms []image.Image, threads int) (*image.RGBA64, error) {
+	if len(ms) == 0 {
+		return nil, errorf(ErrInvalidOptions, "no captures to merge")
+	}
+
+	caps := make([]*image.RGBA64, len(ms))
+	for i, m := range ms {
+		if m.Bounds().Size() != ms[0].Bounds().Size() {
+			return nil, errorf(ErrImageMismatch, "captures to merge must be the same size")
+		}
+		var err error
+		if caps[i], err = mapPixelsContext(ctx, m, identity, threads); err != nil {
+			return nil, err
+		}
+	}
+
+	// exposure of each capture relative to the first, and then to the
+	// darkest
+	k := make([]float64, len(caps))
+	k[0] = 1
+	for i := 1; i < len(caps); i++ {
+		r, ok := exposureRatio(caps[0], caps[i])
+		if !ok {
+			return nil, errorf(ErrImageMismatch, "captures to merge don't overlap in exposure")
+		}
+		k[i] = r
+	}
+	var darkest int
+	for i, v := range k {
+		if v < k[darkest] {
+			darkest = i
+		}
+	}
+	least := k[darkest]
+	for i := range k {
+		k[i] /= least
+	}
+
+	ret := image.NewRGBA64(caps[0].Rect)
+	err := stripesContext(ctx, ret.Rect, threads, func(stripe image.Rectangle) {
+		for y := stripe.Min.Y; y < stripe.Max.Y; y++ {
+			for i := y * ret.Stride; i < (y+1)*ret.Stride; i += 8 {
+				for c := 0; c < 6; c += 2 {
+					// each capture estimates the exposure as v/k, which
+					// is weighted by k since longer exposures are less
+					// noisy
+					var sum, weight float64
+					for j, m := range caps {
+						if v := get16(m.Pix[i+c:]); v < hdrClip {
+							sum += float64(v)
+							weight += k[j]
+						}
+					}
+
+					// the darkest capture is used even if it clips
+					if weight == 0 {
+						put16(ret.Pix[i+c:], get16(caps[darkest].Pix[i+c:]))
+						continue
+					}
+					put16(ret.Pix[i+c:], clip(sum/weight))
+				}
+				put16(ret.Pix[i+6:], 0xffff)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// exposureRatio returns the exposure of b relative to a, from the pixels
+// that are neither clipped nor too noisy in both.
+func exposureRatio(a, b *image.RGBA64) (float64, bool) {
+	var sa, sb float64
+	for i := 0; i < len(a.Pix); i += 2 {
+		if i%8 == 6 {
+			continue
+		}
+		va, vb := get16(a.Pix[i:]), get16(b.Pix[i:])
+		if va < hdrFloor || vb < hdrFloor || va >= hdrClip || vb >= hdrClip {
+			continue
+		}
+		sa += float64(va)
+		sb += float64(vb)
+	}
+	if sa == 0 {
+		return 0, false
+	}
+	return sb / sa, true
+}
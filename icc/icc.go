@@ -0,0 +1,223 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+// Package icc generates minimal ICC v2 matrix/TRC display profiles for common
+// RGB working spaces, so output files can be tagged without shipping profile
+// files alongside the binary.
+package icc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// A space describes an RGB working space by its D50 adapted primaries and
+// tone response.
+type space struct {
+	desc string
+	r    [3]float64
+	g    [3]float64
+	b    [3]float64
+
+	// gamma is a pure power law tone response. If zero, the sRGB curve is
+	// used instead.
+	gamma float64
+}
+
+var spaces = map[string]space{
+	"srgb": {
+		desc: "sRGB",
+		r:    [3]float64{0.4360747, 0.2225045, 0.0139322},
+		g:    [3]float64{0.3850649, 0.7168786, 0.0971045},
+		b:    [3]float64{0.1430804, 0.0606169, 0.7141733},
+	},
+	"adobergb": {
+		desc:  "Adobe RGB (1998) compatible",
+		r:     [3]float64{0.6097559, 0.3111242, 0.0194811},
+		g:     [3]float64{0.2052401, 0.6256560, 0.0608902},
+		b:     [3]float64{0.1492240, 0.0632197, 0.7448387},
+		gamma: 563.0 / 256.0,
+	},
+	"prophoto": {
+		desc:  "ProPhoto RGB",
+		r:     [3]float64{0.7976749, 0.2880402, 0.0000000},
+		g:     [3]float64{0.1351917, 0.7118741, 0.0000000},
+		b:     [3]float64{0.0313534, 0.0000857, 0.8252100},
+		gamma: 1.8,
+	},
+	"linear": {
+		desc:  "Linear sRGB",
+		r:     [3]float64{0.4360747, 0.2225045, 0.0139322},
+		g:     [3]float64{0.3850649, 0.7168786, 0.0971045},
+		b:     [3]float64{0.1430804, 0.0606169, 0.7141733},
+		gamma: 1.0,
+	},
+}
+
+// D50 white point of the profile connection space.
+var d50 = [3]float64{0.9642, 1.0, 0.8249}
+
+// Names returns the names of the available profiles.
+func Names() []string {
+	var ret []string
+	for k := range spaces {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Primaries returns the matrix converting linear RGB values in the named
+// space to D50 adapted XYZ, whose columns are its red, green, and blue
+// primaries.
+func Primaries(name string) ([3][3]float64, error) {
+	s, ok := spaces[name]
+	if !ok {
+		return [3][3]float64{}, fmt.Errorf("icc: unknown profile: %v", name)
+	}
+	var m [3][3]float64
+	for i := 0; i < 3; i++ {
+		m[i] = [3]float64{s.r[i], s.g[i], s.b[i]}
+	}
+	return m, nil
+}
+
+// Encoder returns the tone response of the named space, encoding linear
+// values from 0 to 1.
+func Encoder(name string) (func(float64) float64, error) {
+	s, ok := spaces[name]
+	if !ok {
+		return nil, fmt.Errorf("icc: unknown profile: %v", name)
+	}
+	if s.gamma > 0 {
+		return func(v float64) float64 { return math.Pow(v, 1/s.gamma) }, nil
+	}
+	return func(v float64) float64 {
+		if v <= 0.0031308 {
+			return v * 12.92
+		}
+		return 1.055*math.Pow(v, 1/2.4) - 0.055
+	}, nil
+}
+
+// Profile returns the ICC profile for the named space.
+func Profile(name string) ([]byte, error) {
+	s, ok := spaces[name]
+	if !ok {
+		return nil, fmt.Errorf("icc: unknown profile: %v", name)
+	}
+
+	trc := curve(s.gamma)
+	tags := []struct {
+		sig  string
+		data []byte
+	}{
+		{"desc", desc(s.desc)},
+		{"cprt", text("No copyright, use freely")},
+		{"wtpt", xyz(d50)},
+		{"rXYZ", xyz(s.r)},
+		{"gXYZ", xyz(s.g)},
+		{"bXYZ", xyz(s.b)},
+		{"rTRC", trc},
+		{"gTRC", trc},
+		{"bTRC", trc},
+	}
+
+	// lay out the tag data after the header and tag table, 4 byte aligned
+	off := 128 + 4 + 12*len(tags)
+	var table, data bytes.Buffer
+	binary.Write(&table, binary.BigEndian, uint32(len(tags)))
+	for _, t := range tags {
+		table.WriteString(t.sig)
+		binary.Write(&table, binary.BigEndian, uint32(off+data.Len()))
+		binary.Write(&table, binary.BigEndian, uint32(len(t.data)))
+		data.Write(t.data)
+		for data.Len()%4 != 0 {
+			data.WriteByte(0)
+		}
+	}
+
+	size := 128 + table.Len() + data.Len()
+	h := make([]byte, 128)
+	binary.BigEndian.PutUint32(h[0:], uint32(size))
+	binary.BigEndian.PutUint32(h[8:], 0x02100000) // version 2.1
+	copy(h[12:], "mntr")
+	copy(h[16:], "RGB ")
+	copy(h[20:], "XYZ ")
+	copy(h[36:], "acsp")
+	copy(h[68:], xyz(d50)[8:])
+
+	var ret bytes.Buffer
+	ret.Write(h)
+	ret.Write(table.Bytes())
+	ret.Write(data.Bytes())
+	return ret.Bytes(), nil
+}
+
+// s15Fixed16 encodes v as a signed 15.16 fixed point number.
+func s15Fixed16(v float64) uint32 {
+	return uint32(int32(math.Round(v * 65536)))
+}
+
+func xyz(v [3]float64) []byte {
+	b := make([]byte, 20)
+	copy(b, "XYZ ")
+	for i := range v {
+		binary.BigEndian.PutUint32(b[8+i*4:], s15Fixed16(v[i]))
+	}
+	return b
+}
+
+func text(s string) []byte {
+	b := make([]byte, 8, 8+len(s)+1)
+	copy(b, "text")
+	b = append(b, s...)
+	return append(b, 0)
+}
+
+// desc encodes a v2 textDescriptionType with empty unicode and script code
+// descriptions.
+func desc(s string) []byte {
+	var b bytes.Buffer
+	b.WriteString("desc")
+	b.Write(make([]byte, 4))
+	binary.Write(&b, binary.BigEndian, uint32(len(s)+1))
+	b.WriteString(s)
+	b.WriteByte(0)
+	b.Write(make([]byte, 4+4+2+1+67))
+	return b.Bytes()
+}
+
+// curve encodes a power law tone response, or the sRGB curve sampled into a
+// table when gamma is zero.
+func curve(gamma float64) []byte {
+	var b bytes.Buffer
+	b.WriteString("curv")
+	b.Write(make([]byte, 4))
+
+	switch {
+	case gamma == 1:
+		binary.Write(&b, binary.BigEndian, uint32(0))
+	case gamma > 0:
+		binary.Write(&b, binary.BigEndian, uint32(1))
+		binary.Write(&b, binary.BigEndian, uint16(math.Round(gamma*256)))
+	default:
+		const n = 1024
+		binary.Write(&b, binary.BigEndian, uint32(n))
+		for i := 0; i < n; i++ {
+			v := float64(i) / (n - 1)
+			if v <= 0.04045 {
+				v /= 12.92
+			} else {
+				v = math.Pow((v+0.055)/1.055, 2.4)
+			}
+			binary.Write(&b, binary.BigEndian, uint16(math.Round(v*65535)))
+		}
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,17 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package positive
+
+import "image"
+
+// Invert is a simple image invert.
+func Invert(m image.Image) image.Image {
+	return mapPixels(m, invertFunc, 0)
+}
+
+func invertFunc(r, g, b float32) (float32, float32, float32) {
+	return 1 - r, 1 - g, 1 - b
+}
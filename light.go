@@ -0,0 +1,75 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package positive
+
+import "math"
+
+// Illuminant is the color of light a film is balanced for, or was exposed
+// under.
+type Illuminant string
+
+// Illuminants
+const (
+	Daylight Illuminant = "daylight"
+	Tungsten Illuminant = "tungsten"
+)
+
+// color temperatures of the illuminants in kelvin, daylight if unspecified
+var illuminants = map[Illuminant]float64{
+	"":       5500,
+	Daylight: 5500,
+	Tungsten: 3200,
+}
+
+// representative red, green, and blue wavelengths of film dye sensitivity, in
+// meters
+var wavelengths = [3]float64{600e-9, 540e-9, 450e-9}
+
+// lightGains returns the exposure gains that correct film balanced for
+// illuminant film but exposed under light, such as tungsten balanced cine film
+// shot in daylight. Gains are the ratio of black body spectra at each
+// channel's wavelength, relative to green.
+func lightGains(film, light Illuminant) [3]float64 {
+	// Planck's law, without constant factors
+	planck := func(l, t float64) float64 {
+		const c2 = 1.4387769e-2 // hc/k, in meter kelvin
+		return 1 / (math.Pow(l, 5) * (math.Exp(c2/(l*t)) - 1))
+	}
+
+	tf, tl := illuminants[film], illuminants[light]
+	var k [3]float64
+	for i, l := range wavelengths {
+		k[i] = planck(l, tf) / planck(l, tl)
+	}
+	return [3]float64{k[0] / k[1], 1, k[2] / k[1]}
+}
+
+// lightFunc corrects the exposure of each channel by gains k, as part of pre.
+// Gamma corrected negative values are inversely proportional to exposure,
+// while encoded densities grow with its log by the film gamma g.
+func lightFunc(k [3]float64, g Gamma, density bool) pixelFunc {
+	// only relative exposure matters, so only ever increase it, moving
+	// values away from the film base rather than clipping them against it
+	n := math.Min(k[0], math.Min(k[1], k[2]))
+	for i := range k {
+		k[i] /= n
+	}
+
+	if density {
+		var d [3]float32
+		for i, gamma := range [3]float64{g.R, g.G, g.B} {
+			d[i] = float32(gamma * math.Log10(k[i]) / maxDensity)
+		}
+		return func(r, g, b float32) (float32, float32, float32) {
+			return r + d[0], g + d[1], b + d[2]
+		}
+	}
+
+	s := [3]float32{float32(1 / k[0]), float32(1 / k[1]), float32(1 / k[2])}
+	return func(r, g, b float32) (float32, float32, float32) {
+		return r * s[0], g * s[1], b * s[2]
+	}
+}
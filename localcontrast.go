@@ -0,0 +1,216 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package positive
+
+import (
+	"context"
+	"image"
+	"sync"
+)
+
+// DefaultLocalContrastClip and DefaultLocalContrastTiles are the clip limit
+// and number of tiles LocalContrast uses if Options.LocalContrastClip or
+// Options.LocalContrastTiles is 0.
+const (
+	DefaultLocalContrastClip  = 2.0
+	DefaultLocalContrastTiles = 8
+)
+
+// number of lightness bins of the histogram of each tile
+const localContrastBins = 256
+
+// LocalContrast enhances local contrast in m with contrast limited adaptive
+// histogram equalization (CLAHE) of its lightness: the image is split into
+// tiles, tiles along its longest side, and the lightness of each is spread
+// out to cover the full range, interpolating between neighboring tiles so
+// their edges don't show. clip limits the contrast gained, as a multiple of
+// the mean count of a histogram bin that no bin may exceed: 1 leaves the
+// image unchanged, and higher values allow more. The result is blended with
+// the original by amount, from 0 to 1. The channels are shifted equally, so
+// colors keep their hue. Rows are processed concurrently by up to threads
+// goroutines.
+func LocalContrast(m *image.RGBA64, amount, clip float64, tiles, threads int) *image.RGBA64 {
+	ret, _ := LocalContrastContext(context.Background(), m, amount, clip, tiles, threads)
+	return ret
+}
+
+// LocalContrastContext is LocalContrast, returning ctx.Err() if ctx is done
+// first.
+func LocalContrastContext(ctx context.Context, m *image.RGBA64, amount, clip float64, tiles, threads int) (*image.RGBA64, error) {
+	p, err := mapFloat(ctx, m, identity, threads)
+	if err != nil {
+		return nil, err
+	}
+	if p, err = localContrast(ctx, p, amount, clip, tiles, false, identity, threads); err != nil {
+		return nil, err
+	}
+	return mapPixelsContext(ctx, p, identity, threads)
+}
+
+// localContrast is LocalContrast on the floating point image p, passing the
+// result through f. Linear images, in density mode, are gamma encoded to find
+// their lightness.
+func localContrast(ctx context.Context, p *floatImage, amount, clip float64, tiles int, linear bool, f pixelFunc, threads int) (*floatImage, error) {
+	w, h := p.w, p.h
+	if w == 0 || h == 0 {
+		return p, nil
+	}
+
+	// square tiles, the last ones in each direction possibly cut short
+	s := (max(w, h) + tiles - 1) / tiles
+	tx, ty := (w+s-1)/s, (h+s-1)/s
+
+	var enc, dec *curve
+	if linear {
+		enc, dec = gammaCurve(1/displayGamma), gammaCurve(displayGamma)
+	}
+	// lightness of pixel i, clipped to [0,1] for the histograms
+	lightness := func(i int) float32 {
+		l := luminance(p.pix[i*3], p.pix[i*3+1], p.pix[i*3+2])
+		if linear {
+			l = enc.at(l)
+		}
+		return max(0, min(1, l))
+	}
+
+	hist := make([]int, tx*ty*localContrastBins)
+	var mu sync.Mutex
+	stripesContext(ctx, p.Bounds(), threads, func(stripe image.Rectangle) {
+		sh := make([]int, len(hist))
+		for y := stripe.Min.Y; y < stripe.Max.Y; y++ {
+			row := y / s * tx
+			for x := 0; x < w; x++ {
+				bin := min(int(lightness(y*w+x)*localContrastBins), localContrastBins-1)
+				sh[(row+x/s)*localContrastBins+bin]++
+			}
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		for i, n := range sh {
+			hist[i] += n
+		}
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// the equalizing curve of each tile, from its clipped histogram
+	const n = localContrastBins + 1
+	cdf := make([]float32, tx*ty*n)
+	for t := 0; t < tx*ty; t++ {
+		equalize(hist[t*localContrastBins:(t+1)*localContrastBins], clip, cdf[t*n:(t+1)*n])
+	}
+	at := func(t int, l float32) float32 {
+		c := cdf[t*n : (t+1)*n]
+		x := l * localContrastBins
+		i := min(int(x), localContrastBins-1)
+		return c[i] + (c[i+1]-c[i])*(x-float32(i))
+	}
+
+	ret := newFloatImage(w, h)
+	k := float32(amount)
+	stripesContext(ctx, ret.Bounds(), threads, func(stripe image.Rectangle) {
+		for y := stripe.Min.Y; y < stripe.Max.Y; y++ {
+			y0, y1, wy := tileNeighbors(y, s, ty)
+			for x := 0; x < w; x++ {
+				x0, x1, wx := tileNeighbors(x, s, tx)
+				i := y*w + x
+				l := lightness(i)
+
+				// interpolate between the curves of the four nearest tiles
+				top := at(y0*tx+x0, l)*(1-wx) + at(y0*tx+x1, l)*wx
+				bottom := at(y1*tx+x0, l)*(1-wx) + at(y1*tx+x1, l)*wx
+				e := top*(1-wy) + bottom*wy
+
+				d := k * (e - l)
+				if linear {
+					d = dec.at(l+d) - dec.at(l)
+				}
+				ret.pix[i*3], ret.pix[i*3+1], ret.pix[i*3+2] = f(p.pix[i*3]+d, p.pix[i*3+1]+d, p.pix[i*3+2]+d)
+			}
+		}
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// equalize sets cdf, of one more entry than the histogram h, to the
+// cumulative distribution of h with each bin clipped to clip times the mean
+// bin count, and the excess spread evenly over the bins below the limit.
+// This limits the slope of the equalizing curve, and so the contrast gained:
+// at a clip of 1 every bin ends up at the mean, and the curve is a straight
+// line.
+func equalize(h []int, clip float64, cdf []float32) {
+	var total float64
+	c := make([]float64, len(h))
+	for i, n := range h {
+		c[i] = float64(n)
+		total += c[i]
+	}
+	if total == 0 {
+		for i := range cdf {
+			cdf[i] = float32(i) / float32(len(h))
+		}
+		return
+	}
+
+	limit := clip * total / float64(len(h))
+	var excess float64
+	for i := range c {
+		if c[i] > limit {
+			excess += c[i] - limit
+			c[i] = limit
+		}
+	}
+	// each round fills at least one bin to the limit, or spreads all of it
+	for excess > total*1e-6 {
+		var below int
+		for _, v := range c {
+			if v < limit {
+				below++
+			}
+		}
+		if below == 0 {
+			break
+		}
+		share := excess / float64(below)
+		excess = 0
+		for i := range c {
+			if c[i] < limit {
+				c[i] += share
+				if c[i] > limit {
+					excess += c[i] - limit
+					c[i] = limit
+				}
+			}
+		}
+	}
+
+	var sum float64
+	cdf[0] = 0
+	for i := range c {
+		sum += c[i]
+		cdf[i+1] = float32(sum / total)
+	}
+}
+
+// tileNeighbors returns the tiles, of n of size s, whose centers are on
+// either side of v, and the weight of the second. Beyond the centers of the
+// first and last tiles, both are that tile.
+func tileNeighbors(v, s, n int) (int, int, float32) {
+	c := (float32(v)+0.5)/float32(s) - 0.5
+	if c <= 0 {
+		return 0, 0, 0
+	}
+	i := int(c)
+	if i >= n-1 {
+		return n - 1, n - 1, 0
+	}
+	return i, i + 1, c - float32(i)
+}
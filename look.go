@@ -0,0 +1,96 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package positive
+
+import "sort"
+
+// Look is a preset emulating the color rendering of a lab scanner, see
+// Options.Look.
+type Look string
+
+// Look presets
+const (
+	LookNone     Look = ""
+	LookNoritsu  Look = "noritsu"
+	LookFrontier Look = "frontier"
+	LookPakon    Look = "pakon"
+)
+
+// A look is a scanner's rendering of a positive: a color matrix, whose rows
+// sum to 1 so neutrals stay neutral, giving its saturation and hue shifts,
+// then per channel S-curve exponents, as the tone curve presets use, and per
+// channel black levels as a fraction of white, tinting the shadows.
+type look struct {
+	matrix Matrix
+	curve  [3]float64
+	black  [3]float64
+}
+
+var looks = map[Look]look{
+	LookNone: {matrix: Identity, curve: [3]float64{1, 1, 1}},
+
+	// contrasty and neutral, with deep blacks and rich reds
+	LookNoritsu: {
+		matrix: Matrix{
+			{1.18, -0.13, -0.05},
+			{-0.06, 1.12, -0.06},
+			{-0.03, -0.12, 1.15},
+		},
+		curve: [3]float64{1.45, 1.4, 1.4},
+	},
+
+	// soft and pastel, with strong greens and cool, slightly cyan shadows
+	LookFrontier: {
+		matrix: Matrix{
+			{1.08, -0.02, -0.06},
+			{-0.08, 1.16, -0.08},
+			{-0.04, -0.06, 1.10},
+		},
+		curve: [3]float64{1.2, 1.25, 1.2},
+		black: [3]float64{0.015, 0.025, 0.03},
+	},
+
+	// warm, with golden yellows and lifted, warm blacks
+	LookPakon: {
+		matrix: Matrix{
+			{1.12, -0.06, -0.06},
+			{-0.04, 1.06, -0.02},
+			{0, -0.08, 1.08},
+		},
+		curve: [3]float64{1.3, 1.3, 1.25},
+		black: [3]float64{0.02, 0.015, 0.01},
+	},
+}
+
+// Looks returns the names of the look presets, sorted.
+func Looks() []Look {
+	var ls []Look
+	for l := range looks {
+		if l != LookNone {
+			ls = append(ls, l)
+		}
+	}
+	sort.Slice(ls, func(i, j int) bool { return ls[i] < ls[j] })
+	return ls
+}
+
+// lookFunc returns the stage rendering a positive with look l, or nil if l
+// is LookNone.
+func lookFunc(l Look) pixelFunc {
+	if l == LookNone {
+		return nil
+	}
+	lk := looks[l]
+
+	var t [3]*curve
+	for c := range t {
+		e, black := lk.curve[c], lk.black[c]
+		t[c] = newCurve(curveSteps, func(v float64) float64 {
+			return black + sCurve(v, e)*(1-black)
+		})
+	}
+	return compose(lk.matrix.apply, curveFunc(t[0], t[1], t[2]))
+}
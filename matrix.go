@@ -0,0 +1,103 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package positive
+
+import "image"
+
+// A Matrix is a 3x3 color correction matrix. Each output channel is the dot
+// product of the corresponding row and the input r,g,b values.
+type Matrix [3][3]float64
+
+// Identity is the matrix that leaves colors unchanged.
+var Identity = Matrix{
+	{1, 0, 0},
+	{0, 1, 0},
+	{0, 0, 1},
+}
+
+// ApplyMatrix applies the color correction matrix x to every pixel of m.
+func ApplyMatrix(m image.Image, x Matrix) image.Image {
+	return mapPixels(m, x.apply, 0)
+}
+
+func (x Matrix) apply(r, g, b float32) (float32, float32, float32) {
+	fr, fg, fb := float64(r), float64(g), float64(b)
+	return float32(x[0][0]*fr + x[0][1]*fg + x[0][2]*fb),
+		float32(x[1][0]*fr + x[1][1]*fg + x[1][2]*fb),
+		float32(x[2][0]*fr + x[2][1]*fg + x[2][2]*fb)
+}
+
+// clip rounds v to a 16-bit value, clipping out of range values.
+func clip(v float64) uint32 {
+	if v < 0 {
+		return 0
+	} else if v > 0xffff {
+		return 0xffff
+	}
+	return uint32(v + 0.5)
+}
+
+// FitMatrix returns the least squares matrix mapping measured colors to their
+// reference values, such as the patches of a scanned IT8 or ColorChecker
+// target. Colors may be in any consistent scale.
+func FitMatrix(measured, reference [][3]float64) (Matrix, error) {
+	if len(measured) != len(reference) {
+		return Matrix{}, errorf(ErrInvalidOptions, "measured and reference colors differ in length")
+	}
+	if len(measured) < 3 {
+		return Matrix{}, errorf(ErrInvalidOptions, "at least three colors are required")
+	}
+
+	// solve the normal equations (AᵀA)x = Aᵀb for each output channel,
+	// where the rows of A are the measured colors
+	var ata Matrix
+	var atb [3][3]float64
+	for i, m := range measured {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				ata[j][k] += m[j] * m[k]
+				atb[k][j] += m[j] * reference[i][k]
+			}
+		}
+	}
+
+	inv, ok := ata.inverse()
+	if !ok {
+		return Matrix{}, errorf(ErrInvalidOptions, "measured colors are degenerate")
+	}
+
+	var ret Matrix
+	for c := 0; c < 3; c++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				ret[c][j] += inv[j][k] * atb[c][k]
+			}
+		}
+	}
+	return ret, nil
+}
+
+// inverse returns the inverse of x, if it exists.
+func (x Matrix) inverse() (Matrix, bool) {
+	det := x[0][0]*(x[1][1]*x[2][2]-x[1][2]*x[2][1]) -
+		x[0][1]*(x[1][0]*x[2][2]-x[1][2]*x[2][0]) +
+		x[0][2]*(x[1][0]*x[2][1]-x[1][1]*x[2][0])
+	if det == 0 {
+		return Matrix{}, false
+	}
+
+	var ret Matrix
+	ret[0][0] = (x[1][1]*x[2][2] - x[1][2]*x[2][1]) / det
+	ret[0][1] = (x[0][2]*x[2][1] - x[0][1]*x[2][2]) / det
+	ret[0][2] = (x[0][1]*x[1][2] - x[0][2]*x[1][1]) / det
+	ret[1][0] = (x[1][2]*x[2][0] - x[1][0]*x[2][2]) / det
+	ret[1][1] = (x[0][0]*x[2][2] - x[0][2]*x[2][0]) / det
+	ret[1][2] = (x[0][2]*x[1][0] - x[0][0]*x[1][2]) / det
+	ret[2][0] = (x[1][0]*x[2][1] - x[1][1]*x[2][0]) / det
+	ret[2][1] = (x[0][1]*x[2][0] - x[0][0]*x[2][1]) / det
+	ret[2][2] = (x[0][0]*x[1][1] - x[0][1]*x[1][0]) / det
+	return ret, true
+}
@@ -0,0 +1,314 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package positive
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math"
+	"sync"
+)
+
+// Levels are the per channel black and white points used by normalization.
+type Levels struct {
+	RMin uint32 `json:"rmin"`
+	GMin uint32 `json:"gmin"`
+	BMin uint32 `json:"bmin"`
+	RMax uint32 `json:"rmax"`
+	GMax uint32 `json:"gmax"`
+	BMax uint32 `json:"bmax"`
+}
+
+// FindLevels returns the normalization levels Process would use for m with
+// the given options, as seen after film mask removal and gamma correction,
+// and after dust removal and excluding transparent pixels if o.Dust or
+// o.Alpha is set. The border and thresholds in o are used even if
+// o.Normalize is not set.
+func FindLevels(m image.Image, o Options) (Levels, error) {
+	if err := o.validate(m.Bounds()); err != nil {
+		return Levels{}, err
+	}
+	ctx := context.Background()
+	m, _, o, err := o.prepare(ctx, m)
+	if err != nil {
+		return Levels{}, err
+	}
+	return o.findLevels(ctx, m, o.pre()), nil
+}
+
+// interior returns the region of an image with bounds r that normalization
+// levels are found in.
+func (o Options) interior(r image.Rectangle) image.Rectangle {
+	if !o.ROI.Empty() {
+		return o.ROI.Intersect(r)
+	}
+	if o.Margins != nil {
+		return o.Margins.interior(r)
+	}
+	return Margins{o.Border, o.Border, o.Border, o.Border}.interior(r)
+}
+
+// findLevels finds the levels of m after pre, linking channels if requested.
+func (o Options) findLevels(ctx context.Context, m image.Image, pre pixelFunc) Levels {
+	l := findLevels(ctx, m, pre, o.interior(m.Bounds()), o.Exclude, o.Upper, o.Lower, o.Threads)
+	if o.Linked {
+		l = l.link()
+	}
+	return l
+}
+
+// MergeLevels returns levels covering all of ls, the lowest black point and
+// highest white point of each channel, so that levels found in several frames
+// of a roll can be applied to all of them without clipping any.
+func MergeLevels(ls ...Levels) Levels {
+	if len(ls) == 0 {
+		return Levels{RMax: 0xffff, GMax: 0xffff, BMax: 0xffff}
+	}
+
+	l := ls[0]
+	for _, k := range ls[1:] {
+		if k.RMin < l.RMin {
+			l.RMin = k.RMin
+		}
+		if k.GMin < l.GMin {
+			l.GMin = k.GMin
+		}
+		if k.BMin < l.BMin {
+			l.BMin = k.BMin
+		}
+		if k.RMax > l.RMax {
+			l.RMax = k.RMax
+		}
+		if k.GMax > l.GMax {
+			l.GMax = k.GMax
+		}
+		if k.BMax > l.BMax {
+			l.BMax = k.BMax
+		}
+	}
+	return l
+}
+
+// Normalize performs level normalization. This is done by evaluating a
+// rectangle border percentage smaller than the source image (to account for
+// film edges if present). Per channel min/max values are determined and then
+// the entire output channel color space is scaled. tUpper and tLower are the
+// number of pixels allowed beyond the white and black points, which allows for
+// overcoming light/dark spots of dust, etc.
+func Normalize(m image.Image, border, tUpper, tLower int) image.Image {
+	interior := Margins{border, border, border, border}.interior(m.Bounds())
+	l := findLevels(context.Background(), m, nil, interior, nil, tUpper, tLower, 0)
+	return mapPixels(m, l.apply, 0)
+}
+
+// Margins are per side percentages of an image to ignore, such as where a
+// film holder intrudes into the scan.
+type Margins struct {
+	Top, Right, Bottom, Left int
+}
+
+// interior returns the part of r inside the margins.
+func (x Margins) interior(r image.Rectangle) image.Rectangle {
+	pct := func(n, p int) int {
+		return int(float64(n) * float64(p) / 100.0)
+	}
+
+	return image.Rect(
+		r.Min.X+pct(r.Dx(), x.Left),
+		r.Min.Y+pct(r.Dy(), x.Top),
+		r.Min.X+pct(r.Dx(), 100-x.Right),
+		r.Min.Y+pct(r.Dy(), 100-x.Bottom))
+}
+
+// findLevels determines the normalization levels of m within interior, as seen
+// after applying pre to each pixel, skipping pixels marked in the exclude
+// mask. pre and exclude may be nil. The levels are incomplete if ctx is done
+// first.
+func findLevels(ctx context.Context, m image.Image, pre pixelFunc, interior image.Rectangle, exclude image.Image, tUpper, tLower, threads int) Levels {
+	h := new([3]histogram)
+	countLevels(ctx, h, m, pre, interior, exclude, threads)
+	return histLevels(h, tUpper, tLower)
+}
+
+// countLevels adds the pixels of m within rect, as seen after pre, to the
+// per channel histograms h, like findLevels. The counts are incomplete if ctx
+// is done first.
+func countLevels(ctx context.Context, h *[3]histogram, m image.Image, pre pixelFunc, rect image.Rectangle, exclude image.Image, threads int) {
+	var mu sync.Mutex
+	stripesContext(ctx, rect, threads, func(stripe image.Rectangle) {
+		var srh, sgh, sbh histogram
+		scan(m, stripe, exclude, func(r, g, b uint32) {
+			if pre != nil {
+				fr, fg, fb := pre(unit(r), unit(g), unit(b))
+				r, g, b = quantize(fr), quantize(fg), quantize(fb)
+			}
+			srh[r]++
+			sgh[g]++
+			sbh[b]++
+		})
+
+		mu.Lock()
+		defer mu.Unlock()
+		h[0].add(&srh)
+		h[1].add(&sgh)
+		h[2].add(&sbh)
+	})
+}
+
+// scan is scanPixels, skipping pixels where the exclude mask is white. The mask
+// is aligned with the bounds of m.
+func scan(m image.Image, rect image.Rectangle, exclude image.Image, f func(r, g, b uint32)) {
+	if exclude == nil {
+		scanPixels(m, rect, f)
+		return
+	}
+
+	rect = rect.Intersect(m.Bounds())
+	d := exclude.Bounds().Min.Sub(m.Bounds().Min)
+	excluded := func(x, y int) bool {
+		v, _, _, _ := color.GrayModel.Convert(exclude.At(x+d.X, y+d.Y)).RGBA()
+		return v >= 0x8000
+	}
+
+	// scan each run of included pixels, keeping the fast paths of
+	// scanPixels
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; {
+			if excluded(x, y) {
+				x++
+				continue
+			}
+			x0 := x
+			for x < rect.Max.X && !excluded(x, y) {
+				x++
+			}
+			scanPixels(m, image.Rect(x0, y, x, y+1), f)
+		}
+	}
+}
+
+// A histogram counts the pixels of each 16-bit value of a channel.
+type histogram [65536]int
+
+func (h *histogram) add(o *histogram) {
+	for i, v := range o {
+		h[i] += v
+	}
+}
+
+// histLevels returns the min and max of the per channel histograms h,
+// allowing tLower and tUpper pixels beyond them.
+func histLevels(h *[3]histogram, tUpper, tLower int) Levels {
+	return Levels{
+		RMin: h[0].low(tLower),
+		GMin: h[1].low(tLower),
+		BMin: h[2].low(tLower),
+		RMax: h[0].high(tUpper),
+		GMax: h[1].high(tUpper),
+		BMax: h[2].high(tUpper),
+	}
+}
+
+// low returns the lowest value with more than n pixels at or below it, or
+// 0xffff if there is none.
+func (h *histogram) low(n int) uint32 {
+	var sum int
+	for i, v := range h {
+		sum += v
+		if sum > n {
+			return uint32(i)
+		}
+	}
+	return 0xffff
+}
+
+// high returns the highest value with more than n pixels at or above it, or 0
+// if there is none.
+func (h *histogram) high(n int) uint32 {
+	var sum int
+	for i := len(h) - 1; i >= 0; i-- {
+		sum += h[i]
+		if sum > n {
+			return uint32(i)
+		}
+	}
+	return 0
+}
+
+// link returns the levels with every channel set to the lowest black point and
+// highest white point of all channels.
+func (l Levels) link() Levels {
+	min, max := l.RMin, l.RMax
+	if l.GMin < min {
+		min = l.GMin
+	}
+	if l.BMin < min {
+		min = l.BMin
+	}
+	if l.GMax > max {
+		max = l.GMax
+	}
+	if l.BMax > max {
+		max = l.BMax
+	}
+	return Levels{RMin: min, GMin: min, BMin: min, RMax: max, GMax: max, BMax: max}
+}
+
+// apply scales a pixel to the levels, clipping values beyond them, which is
+// what the thresholds allow for.
+func (l Levels) apply(r, g, b float32) (float32, float32, float32) {
+	return stretch(r, l.RMin, l.RMax), stretch(g, l.GMin, l.GMax), stretch(b, l.BMin, l.BMax)
+}
+
+// rolloff returns a pixelFunc scaling pixels to the levels, compressing values
+// within knee of black and white rather than clipping them. See soft.
+func (l Levels) rolloff(knee float64) pixelFunc {
+	if knee <= 0 {
+		return l.apply
+	}
+	return curveFunc(stretchCurve(l.RMin, l.RMax, knee), stretchCurve(l.GMin, l.GMax, knee), stretchCurve(l.BMin, l.BMax, knee))
+}
+
+func stretchCurve(min, max uint32, knee float64) *curve {
+	if max <= min {
+		max = min + 1
+	}
+	return newCurve(curveSteps, func(v float64) float64 {
+		return soft((v*0xffff-float64(min))/float64(max-min), knee)
+	})
+}
+
+// soft maps v, nominally in [0,1], into that range. Values within knee of
+// either end are compressed smoothly, so values beyond the range approach
+// black and white gradually instead of flat-lining. With a knee of 0, v is
+// simply clipped.
+func soft(v, knee float64) float64 {
+	switch {
+	case knee <= 0:
+		return math.Max(0, math.Min(1, v))
+	case v > 1-knee:
+		v = 1 - knee + knee*(1-math.Exp((1-knee-v)/knee))
+	case v < knee:
+		v = knee - knee*(1-math.Exp((v-knee)/knee))
+	}
+	return v
+}
+
+// stretch scales v from the 16-bit levels [min,max] to [0,1], clipping out
+// of range values.
+func stretch(v float32, min, max uint32) float32 {
+	if max <= min {
+		max = min + 1
+	}
+	v = (v*0xffff - float32(min)) / float32(max-min)
+	if v < 0 {
+		return 0
+	} else if v > 1 {
+		return 1
+	}
+	return v
+}
@@ -0,0 +1,65 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package positive
+
+import "image"
+
+// Rotate rotates m clockwise by degrees, which must be a multiple of 90.
+func Rotate(m image.Image, degrees int) (*image.RGBA64, error) {
+	src := mapPixels(m, identity, 0)
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+
+	switch (degrees%360 + 360) % 360 {
+	case 0:
+		return src, nil
+	case 90:
+		return remap(src, h, w, func(x, y int) (int, int) {
+			return y, h - 1 - x
+		}), nil
+	case 180:
+		return remap(src, w, h, func(x, y int) (int, int) {
+			return w - 1 - x, h - 1 - y
+		}), nil
+	case 270:
+		return remap(src, h, w, func(x, y int) (int, int) {
+			return w - 1 - y, x
+		}), nil
+	}
+	return nil, errorf(ErrInvalidOptions, "rotation must be a multiple of 90 degrees, not %v", degrees)
+}
+
+// FlipHorizontal mirrors m left to right, such as to correct a negative
+// scanned emulsion side down.
+func FlipHorizontal(m image.Image) *image.RGBA64 {
+	src := mapPixels(m, identity, 0)
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	return remap(src, w, h, func(x, y int) (int, int) {
+		return w - 1 - x, y
+	})
+}
+
+// FlipVertical mirrors m top to bottom.
+func FlipVertical(m image.Image) *image.RGBA64 {
+	src := mapPixels(m, identity, 0)
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	return remap(src, w, h, func(x, y int) (int, int) {
+		return x, h - 1 - y
+	})
+}
+
+// remap returns a w by h image whose pixel x,y is pixel f(x,y) of m.
+func remap(m *image.RGBA64, w, h int, f func(x, y int) (int, int)) *image.RGBA64 {
+	ret := image.NewRGBA64(image.Rect(0, 0, w, h))
+	stripes(ret.Rect, 0, func(stripe image.Rectangle) {
+		for y := stripe.Min.Y; y < stripe.Max.Y; y++ {
+			for x := 0; x < w; x++ {
+				sx, sy := f(x, y)
+				copy(ret.Pix[y*ret.Stride+x*8:y*ret.Stride+x*8+8], m.Pix[sy*m.Stride+sx*8:])
+			}
+		}
+	})
+	return ret
+}
@@ -0,0 +1,194 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package positive
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+// origin is where the offset copies of test images start
+var origin = image.Pt(37, 21)
+
+// offset returns a copy of m, which starts at the origin, as a SubImage of a
+// larger image of the same type, starting at origin.
+func offset(m image.Image) image.Image {
+	b := m.Bounds().Add(origin)
+	big := image.Rect(0, 0, b.Max.X+11, b.Max.Y+7)
+	type subImager interface {
+		draw.Image
+		SubImage(image.Rectangle) image.Image
+	}
+	var c subImager
+	switch m.(type) {
+	case *image.NRGBA64:
+		c = image.NewNRGBA64(big)
+	case *image.Gray16:
+		c = image.NewGray16(big)
+	default:
+		c = image.NewRGBA64(big)
+	}
+	// fill around the copy, which must not leak into the results
+	draw.Draw(c, big, image.NewUniform(color.RGBA64{0x1234, 0xfedc, 0x0101, 0xffff}), image.Point{}, draw.Src)
+	draw.Draw(c, b, m, image.Point{}, draw.Src)
+	return c.SubImage(b)
+}
+
+// transparentCorner returns the color negative with its bottom right corner
+// transparent, as scanners mark invalid regions.
+func transparentCorner(w, h int) image.Image {
+	m := negative(w, h, gradient)
+	ret := image.NewNRGBA64(m.Bounds())
+	draw.Draw(ret, ret.Rect, m, image.Point{}, draw.Src)
+	for y := h * 2 / 3; y < h; y++ {
+		for x := w * 2 / 3; x < w; x++ {
+			ret.SetNRGBA64(x, y, color.NRGBA64{R: 0xffff, G: 0xffff, B: 0xffff})
+		}
+	}
+	return ret
+}
+
+// originCases are conversions of images starting at the origin, whose
+// regions are given for that origin.
+var originCases = []struct {
+	name string
+	m    func(w, h int) image.Image
+	o    func(o *Options)
+}{
+	{"default", colorNegative, func(o *Options) {}},
+	{"roi", colorNegative, func(o *Options) { o.ROI = image.Rect(10, 8, 40, 30) }},
+	{"neutral", colorNegative, func(o *Options) { o.Neutral = image.Rect(20, 20, 28, 28) }},
+	{"roi-neutral-dust", func(w, h int) image.Image { return specks(negative(w, h, gradient)) }, func(o *Options) {
+		o.ROI, o.Neutral, o.Dust = image.Rect(10, 8, 40, 30), image.Rect(20, 20, 28, 28), 0.2
+	}},
+	{"alpha", transparentCorner, func(o *Options) { o.Alpha = true }},
+	{"alpha-roi-neutral", transparentCorner, func(o *Options) {
+		o.Alpha, o.ROI, o.Neutral = true, image.Rect(4, 4, 50, 40), image.Rect(20, 20, 28, 28)
+	}},
+	{"alpha-dust", transparentCorner, func(o *Options) { o.Alpha, o.Dust = true, 0.2 }},
+	{"filters", colorNegative, func(o *Options) { o.Denoise, o.LocalContrast, o.Sharpen = 0.5, 0.5, 1 }},
+	{"bw", func(w, h int) image.Image { return grayWedge(w, h) }, func(o *Options) { o.ROI = image.Rect(10, 8, 40, 30) }},
+}
+
+// offsetOptions returns o with its regions moved to origin.
+func offsetOptions(o Options) Options {
+	if !o.ROI.Empty() {
+		o.ROI = o.ROI.Add(origin)
+	}
+	if !o.Neutral.Empty() {
+		o.Neutral = o.Neutral.Add(origin)
+	}
+	return o
+}
+
+// TestProcessOffset checks converting an image that doesn't start at the
+// origin gives the same pixels as a copy that does.
+func TestProcessOffset(t *testing.T) {
+	for _, c := range originCases {
+		t.Run(c.name, func(t *testing.T) {
+			m := c.m(64, 48)
+			o := goldenOptions(c.o)
+			want, err := Process(m, o)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Process(offset(m), offsetOptions(o))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Bounds().Min != (image.Point{}) {
+				t.Errorf("bounds %v, want them at the origin", got.Bounds())
+			}
+			if err := compareImages(got, want, 0); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// TestFindLevelsOffset checks the levels found in an image that doesn't
+// start at the origin are those of a copy that does.
+func TestFindLevelsOffset(t *testing.T) {
+	for _, c := range originCases {
+		t.Run(c.name, func(t *testing.T) {
+			m := c.m(64, 48)
+			o := goldenOptions(c.o)
+			want, err := FindLevels(m, o)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := FindLevels(offset(m), offsetOptions(o))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Errorf("levels %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+// TestDetectFramesOffset checks the frames of a strip that doesn't start at
+// the origin are found in its coordinates.
+func TestDetectFramesOffset(t *testing.T) {
+	strip := image.NewRGBA64(image.Rect(0, 0, 3*64, 48))
+	for i := 0; i < 3; i++ {
+		draw.Draw(strip, image.Rect(i*64, 0, (i+1)*64, 48), negative(64, 48, gradient), image.Point{}, draw.Src)
+	}
+	want := DetectFrames(strip)
+	if len(want) != 3 {
+		t.Fatalf("%v frames, want 3", len(want))
+	}
+
+	got := DetectFrames(offset(strip))
+	if len(got) != len(want) {
+		t.Fatalf("%v frames, want %v", len(got), len(want))
+	}
+	for i := range got {
+		if got[i] != want[i].Add(origin) {
+			t.Errorf("frame %v: %v, want %v", i, got[i], want[i].Add(origin))
+		}
+	}
+}
+
+// TestFiltersOffset checks the filters of an image that doesn't start at the
+// origin give the same pixels as a copy that does, starting at the origin.
+func TestFiltersOffset(t *testing.T) {
+	m := negative(64, 48, gradient)
+	for name, f := range map[string]func(m *image.RGBA64) *image.RGBA64{
+		"sharpen": func(m *image.RGBA64) *image.RGBA64 { return Sharpen(m, 1, DefaultSharpenRadius, 0, 1) },
+		"denoise": func(m *image.RGBA64) *image.RGBA64 { return Denoise(m, 0.5, 0.5, 1) },
+		"local-contrast": func(m *image.RGBA64) *image.RGBA64 {
+			return LocalContrast(m, 1, DefaultLocalContrastClip, DefaultLocalContrastTiles, 1)
+		},
+	} {
+		want := f(m)
+		got := f(offset(m).(*image.RGBA64))
+		if got.Bounds() != want.Bounds() {
+			t.Errorf("%v: bounds %v, want %v", name, got.Bounds(), want.Bounds())
+		}
+		if err := compareImages(got, want, 0); err != nil {
+			t.Errorf("%v: %v", name, err)
+		}
+	}
+}
+
+// TestDespeckleOffset checks despeckling an image that doesn't start at the
+// origin gives the same pixels as a copy that does.
+func TestDespeckleOffset(t *testing.T) {
+	for _, m := range []image.Image{specks(negative(64, 48, gradient)), transparentCorner(64, 48)} {
+		want := Despeckle(m, 0.2, DefaultDustRadius, 0)
+		got := Despeckle(offset(m), 0.2, DefaultDustRadius, 0)
+		if got.Bounds() != want.Bounds() {
+			t.Errorf("%T: bounds %v, want %v", m, got.Bounds(), want.Bounds())
+		}
+		if err := compareImages(got, want, 0); err != nil {
+			t.Errorf("%T: %v", m, err)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package positive
+
+// compose returns a pixelFunc applying each of fs in order. nil entries are
+// skipped.
+func compose(fs ...pixelFunc) pixelFunc {
+	var c []pixelFunc
+	for _, f := range fs {
+		if f != nil {
+			c = append(c, f)
+		}
+	}
+
+	return func(r, g, b float32) (float32, float32, float32) {
+		for _, f := range c {
+			r, g, b = f(r, g, b)
+		}
+		return r, g, b
+	}
+}
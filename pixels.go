@@ -0,0 +1,411 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package positive
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"runtime"
+	"sync"
+)
+
+// most rows in a stripe of a cancellable or metered operation
+const cancelRows = 64
+
+// A pixelFunc maps r,g,b values, from 0 for black to 1 for white, to new
+// values. Values are neither clipped nor rounded between stages, so a chain
+// of stages doesn't accumulate 16-bit rounding errors, and values one stage
+// pushes out of range can be brought back by the next. They are only
+// quantized when the result is stored.
+type pixelFunc func(r, g, b float32) (float32, float32, float32)
+
+// mapPixels returns a new opaque RGBA64 image with f applied to every pixel
+// of m. The returned image starts at the origin, whatever the bounds of m,
+// like the other images returned by the package. Rows are read with
+// readRow, and processed concurrently by up to threads goroutines.
+func mapPixels(m image.Image, f pixelFunc, threads int) *image.RGBA64 {
+	ret, _ := mapPixelsContext(context.Background(), m, f, threads)
+	return ret
+}
+
+// mapPixelsContext is mapPixels, returning ctx.Err() if ctx is done before
+// every row is processed.
+func mapPixelsContext(ctx context.Context, m image.Image, f pixelFunc, threads int) (*image.RGBA64, error) {
+	bounds := m.Bounds()
+	ret := image.NewRGBA64(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+
+	err := stripesContext(ctx, ret.Rect, threads, func(stripe image.Rectangle) {
+		row := make([]float32, bounds.Dx()*3)
+		for y := stripe.Min.Y; y < stripe.Max.Y; y++ {
+			readRow(m, bounds.Min.X, bounds.Min.Y+y, row)
+			d := ret.Pix[y*ret.Stride:]
+			for x := 0; x < bounds.Dx(); x++ {
+				r, g, b := f(row[x*3], row[x*3+1], row[x*3+2])
+				put16(d[x*8:], quantize(r))
+				put16(d[x*8+2:], quantize(g))
+				put16(d[x*8+4:], quantize(b))
+				put16(d[x*8+6:], 0xffff)
+			}
+		}
+	})
+
+	return ret, err
+}
+
+// A floatImage holds the unclipped, unquantized r,g,b values of an opaque
+// image starting at the origin, to carry the positive between stages of
+// Process that look at neighboring pixels, and so can't be fused into a
+// single pass, without rounding it to 16 bits in between.
+type floatImage struct {
+	w, h int
+	pix  []float32 // r,g,b of each pixel, row by row
+}
+
+func newFloatImage(w, h int) *floatImage {
+	return &floatImage{w: w, h: h, pix: make([]float32, w*h*3)}
+}
+
+func (p *floatImage) ColorModel() color.Model { return color.RGBA64Model }
+func (p *floatImage) Bounds() image.Rectangle { return image.Rect(0, 0, p.w, p.h) }
+
+func (p *floatImage) At(x, y int) color.Color {
+	if !image.Pt(x, y).In(p.Bounds()) {
+		return color.RGBA64{}
+	}
+	i := (y*p.w + x) * 3
+	return color.RGBA64{
+		R: uint16(quantize(p.pix[i])),
+		G: uint16(quantize(p.pix[i+1])),
+		B: uint16(quantize(p.pix[i+2])),
+		A: 0xffff,
+	}
+}
+
+// Opaque reports that p has no transparent pixels.
+func (p *floatImage) Opaque() bool { return true }
+
+// mapFloat is mapPixelsContext, keeping the result in floating point.
+func mapFloat(ctx context.Context, m image.Image, f pixelFunc, threads int) (*floatImage, error) {
+	bounds := m.Bounds()
+	ret := newFloatImage(bounds.Dx(), bounds.Dy())
+	w := ret.w * 3
+
+	err := stripesContext(ctx, ret.Bounds(), threads, func(stripe image.Rectangle) {
+		for y := stripe.Min.Y; y < stripe.Max.Y; y++ {
+			d := ret.pix[y*w : (y+1)*w]
+			readRow(m, bounds.Min.X, bounds.Min.Y+y, d)
+			for i := 0; i < w; i += 3 {
+				d[i], d[i+1], d[i+2] = f(d[i], d[i+1], d[i+2])
+			}
+		}
+	})
+	return ret, err
+}
+
+// readRow reads len(dst)/3 pixels of m starting at x,y into dst as r,g,b
+// values from 0 to 1. Floating point, 16 and 8-bit RGBA and NRGBA, and
+// grayscale sources are read directly from their pixel buffers, which is much
+// faster than going through At(). Non-premultiplied sources are
+// premultiplied, as At() would.
+func readRow(m image.Image, x, y int, dst []float32) {
+	const k16, k8 = 1.0 / 0xffff, 1.0 / 0xff
+	n := len(dst) / 3
+
+	switch src := m.(type) {
+	case *floatImage:
+		i := (y*src.w + x) * 3
+		copy(dst, src.pix[i:i+n*3])
+	case *image.RGBA64:
+		s := src.Pix[src.PixOffset(x, y):]
+		for i := 0; i < n; i++ {
+			dst[i*3] = float32(get16(s[i*8:])) * k16
+			dst[i*3+1] = float32(get16(s[i*8+2:])) * k16
+			dst[i*3+2] = float32(get16(s[i*8+4:])) * k16
+		}
+	case *image.NRGBA64:
+		s := src.Pix[src.PixOffset(x, y):]
+		for i := 0; i < n; i++ {
+			a := float32(get16(s[i*8+6:])) * k16 * k16
+			dst[i*3] = float32(get16(s[i*8:])) * a
+			dst[i*3+1] = float32(get16(s[i*8+2:])) * a
+			dst[i*3+2] = float32(get16(s[i*8+4:])) * a
+		}
+	case *image.RGBA:
+		s := src.Pix[src.PixOffset(x, y):]
+		for i := 0; i < n; i++ {
+			dst[i*3] = float32(s[i*4]) * k8
+			dst[i*3+1] = float32(s[i*4+1]) * k8
+			dst[i*3+2] = float32(s[i*4+2]) * k8
+		}
+	case *image.NRGBA:
+		s := src.Pix[src.PixOffset(x, y):]
+		for i := 0; i < n; i++ {
+			a := float32(s[i*4+3]) * k8 * k8
+			dst[i*3] = float32(s[i*4]) * a
+			dst[i*3+1] = float32(s[i*4+1]) * a
+			dst[i*3+2] = float32(s[i*4+2]) * a
+		}
+	case *image.Gray16:
+		s := src.Pix[src.PixOffset(x, y):]
+		for i := 0; i < n; i++ {
+			v := float32(get16(s[i*2:])) * k16
+			dst[i*3], dst[i*3+1], dst[i*3+2] = v, v, v
+		}
+	case *image.Gray:
+		s := src.Pix[src.PixOffset(x, y):]
+		for i := 0; i < n; i++ {
+			v := float32(s[i]) * k8
+			dst[i*3], dst[i*3+1], dst[i*3+2] = v, v, v
+		}
+	default:
+		for i := 0; i < n; i++ {
+			r, g, b, _ := m.At(x+i, y).RGBA()
+			dst[i*3] = float32(r) * k16
+			dst[i*3+1] = float32(g) * k16
+			dst[i*3+2] = float32(b) * k16
+		}
+	}
+}
+
+// unit scales the 16-bit value v to [0,1], as pixelFuncs take it.
+func unit(v uint32) float32 {
+	return float32(v) * (1.0 / 0xffff)
+}
+
+// quantize rounds v, from 0 for black to 1 for white, to a 16-bit value,
+// clipping out of range values. This is where pixelFunc values are stored.
+func quantize(v float32) uint32 {
+	return clip(float64(v) * 0xffff)
+}
+
+// isGray reports whether m is a grayscale image, which holds a single
+// channel.
+func isGray(m image.Image) bool {
+	switch m.(type) {
+	case *image.Gray, *image.Gray16:
+		return true
+	}
+	return false
+}
+
+// mapGray is mapPixelsContext for images of a single channel, returning a Gray16
+// image of the luminance of f applied to every pixel of m, which is a quarter
+// the size.
+func mapGray(ctx context.Context, m image.Image, f pixelFunc, threads int) (*image.Gray16, error) {
+	bounds := m.Bounds()
+	ret := image.NewGray16(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+
+	// the luminance of color.Gray16Model, of the quantized channels, so the
+	// result is the same as converting the color image
+	luma := func(r, g, b float32) uint32 {
+		return (19595*quantize(r) + 38470*quantize(g) + 7471*quantize(b) + 1<<15) >> 16
+	}
+
+	err := stripesContext(ctx, ret.Rect, threads, func(stripe image.Rectangle) {
+		row := make([]float32, bounds.Dx()*3)
+		for y := stripe.Min.Y; y < stripe.Max.Y; y++ {
+			readRow(m, bounds.Min.X, bounds.Min.Y+y, row)
+			d := ret.Pix[y*ret.Stride:]
+			for x := 0; x < bounds.Dx(); x++ {
+				put16(d[x*2:], luma(f(row[x*3], row[x*3+1], row[x*3+2])))
+			}
+		}
+	})
+	return ret, err
+}
+
+// scanPixels calls f with the 16-bit r,g,b values of every pixel of m within
+// rect, using the same fast paths as readRow. Callers wanting concurrency
+// scan separate stripes with their own accumulators.
+func scanPixels(m image.Image, rect image.Rectangle, f func(r, g, b uint32)) {
+	rect = rect.Intersect(m.Bounds())
+
+	switch src := m.(type) {
+	case *image.RGBA64:
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			s := src.Pix[src.PixOffset(rect.Min.X, y):src.PixOffset(rect.Max.X, y)]
+			for i := 0; i < len(s); i += 8 {
+				f(get16(s[i:]), get16(s[i+2:]), get16(s[i+4:]))
+			}
+		}
+	case *image.NRGBA64:
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			s := src.Pix[src.PixOffset(rect.Min.X, y):src.PixOffset(rect.Max.X, y)]
+			for i := 0; i < len(s); i += 8 {
+				a := get16(s[i+6:])
+				f(get16(s[i:])*a/0xffff, get16(s[i+2:])*a/0xffff, get16(s[i+4:])*a/0xffff)
+			}
+		}
+	case *image.RGBA:
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			s := src.Pix[src.PixOffset(rect.Min.X, y):src.PixOffset(rect.Max.X, y)]
+			for i := 0; i < len(s); i += 4 {
+				f(uint32(s[i])*0x101, uint32(s[i+1])*0x101, uint32(s[i+2])*0x101)
+			}
+		}
+	case *image.NRGBA:
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			s := src.Pix[src.PixOffset(rect.Min.X, y):src.PixOffset(rect.Max.X, y)]
+			for i := 0; i < len(s); i += 4 {
+				a := uint32(s[i+3])
+				f(uint32(s[i])*0x101*a/0xff, uint32(s[i+1])*0x101*a/0xff, uint32(s[i+2])*0x101*a/0xff)
+			}
+		}
+	default:
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				r, g, b, _ := m.At(x, y).RGBA()
+				f(r, g, b)
+			}
+		}
+	}
+}
+
+// stripes splits r into horizontal stripes and calls f on each of them using
+// up to threads goroutines. If threads is <= 0, GOMAXPROCS is used.
+func stripes(r image.Rectangle, threads int, f func(image.Rectangle)) {
+	stripesContext(context.Background(), r, threads, f)
+}
+
+// stripesContext is stripes, but stops starting stripes once ctx is done,
+// returning ctx.Err().
+func stripesContext(ctx context.Context, r image.Rectangle, threads int, f func(image.Rectangle)) error {
+	if threads <= 0 {
+		threads = runtime.GOMAXPROCS(0)
+	}
+	if threads > r.Dy() {
+		threads = r.Dy()
+	}
+	if threads < 1 {
+		return ctx.Err()
+	}
+	mt := meterFrom(ctx)
+	if threads == 1 && ctx.Done() == nil && mt == nil {
+		f(r)
+		return nil
+	}
+
+	// use several stripes per thread so uneven work still balances out, and
+	// keep them short enough to stop soon after cancellation and to report
+	// progress smoothly
+	h := (r.Dy() + threads*4 - 1) / (threads * 4)
+	if (ctx.Done() != nil || mt != nil) && h > cancelRows {
+		h = cancelRows
+	}
+	work := make(chan image.Rectangle)
+
+	var wg sync.WaitGroup
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for s := range work {
+				f(s)
+				mt.add(s.Dy())
+			}
+		}()
+	}
+
+dispatch:
+	for y := r.Min.Y; y < r.Max.Y; y += h {
+		s := r
+		s.Min.Y = y
+		if y+h < r.Max.Y {
+			s.Max.Y = y + h
+		}
+		select {
+		case work <- s:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(work)
+	wg.Wait()
+	return ctx.Err()
+}
+
+// get16 reads a big endian 16-bit value, as stored in 16-bit image buffers.
+func get16(b []byte) uint32 {
+	return uint32(b[0])<<8 | uint32(b[1])
+}
+
+// put16 writes v as a big endian 16-bit value.
+func put16(b []byte, v uint32) {
+	b[0] = uint8(v >> 8)
+	b[1] = uint8(v)
+}
+
+// A meter reports the progress of Process to Options.OnProgress, as the
+// fraction of the rows of every pass over the image that are done. It is
+// carried by the context passed to stripesContext, which counts the rows of
+// each finished stripe. The methods of a nil meter do nothing.
+type meter struct {
+	mu    sync.Mutex
+	f     func(stage string, fraction float64)
+	label string
+	total int
+	done  int
+}
+
+type meterKey struct{}
+
+// withMeter returns ctx carrying a meter of total rows reporting to f, or
+// ctx and nil if f is nil.
+func withMeter(ctx context.Context, f func(string, float64), total int) (context.Context, *meter) {
+	if f == nil {
+		return ctx, nil
+	}
+	mt := &meter{f: f, total: total}
+	return context.WithValue(ctx, meterKey{}, mt), mt
+}
+
+// meterFrom returns the meter carried by ctx, or nil.
+func meterFrom(ctx context.Context) *meter {
+	mt, _ := ctx.Value(meterKey{}).(*meter)
+	return mt
+}
+
+// stage starts the named stage.
+func (mt *meter) stage(label string) {
+	if mt == nil {
+		return
+	}
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	mt.label = label
+	mt.report()
+}
+
+// add counts n more rows done.
+func (mt *meter) add(n int) {
+	if mt == nil {
+		return
+	}
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	mt.done += n
+	mt.report()
+}
+
+// finish reports the last stage as done.
+func (mt *meter) finish() {
+	if mt == nil {
+		return
+	}
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	mt.done = mt.total
+	mt.report()
+}
+
+func (mt *meter) report() {
+	frac := 1.0
+	if mt.total > 0 && mt.done < mt.total {
+		frac = float64(mt.done) / float64(mt.total)
+	}
+	mt.f(mt.label, frac)
+}
@@ -0,0 +1,625 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+// Package positive converts scanned film negatives into positive images. It
+// provides film mask removal, film specific gamma correction, per channel
+// level normalization, and inversion. The individual stages are exported, and
+// Process runs them in the same order as the positive command line tool.
+//
+// Images may have any bounds, such as a SubImage of a larger scan. Regions
+// given in Options are in the coordinates of the image they apply to, and
+// the images returned start at the origin.
+package positive
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/djfritz/positive/icc"
+)
+
+// Options control the conversion performed by Process.
+type Options struct {
+	// Dust, if > 0, removes dust and scratches before any other stage, by
+	// replacing pixels darker than their surroundings by this fraction. See
+	// Despeckle. DustRadius is the radius of the surroundings, or
+	// DefaultDustRadius if 0.
+	Dust       float64
+	DustRadius int
+
+	// Base is the film mask color, usually obtained with Sample. If nil,
+	// the film mask is not removed.
+	Base color.Color
+
+	// Divide removes the film mask by dividing by Base, or equivalently
+	// subtracting the mask density, instead of adding its inverse. See
+	// DivideCast.
+	Divide bool
+
+	// Gamma is the film gamma profile to correct for.
+	Gamma Gamma
+
+	// Curves, if not nil, are measured characteristic curves of the film,
+	// in log exposure and density, corrected for instead of Gamma.
+	Curves *Curves
+
+	// Normalize enables per channel level normalization. Border is the
+	// percentage border to ignore when calculating normalization, and
+	// Upper and Lower are the number of pixels allowed beyond the white and
+	// black points, to ignore dust and other small outliers.
+	Normalize bool
+	Border    int
+	Upper     int
+	Lower     int
+
+	// Margins, if not nil, are per side border percentages used instead of
+	// Border. ROI, if not empty, is the region levels are found in instead.
+	Margins *Margins
+	ROI     image.Rectangle
+
+	// Exclude, if not nil, is a mask the size of the image whose white
+	// pixels are ignored when finding levels, such as sprocket holes, holder
+	// edges, or light leaks.
+	Exclude image.Image
+
+	// Alpha carries the alpha channel of the image through to the result,
+	// an *image.NRGBA64, for scans that mark masked or invalid regions with
+	// it. Colors are converted as if opaque, and pixels that are mostly
+	// transparent are ignored when finding levels unless Exclude is set.
+	// Otherwise the result is opaque.
+	Alpha bool
+
+	// Linked normalizes all channels with the same levels, the lowest black
+	// point and highest white point of any channel, so contrast is stretched
+	// without shifting the color balance.
+	Linked bool
+
+	// Levels, if not nil, are used for normalization instead of those found
+	// in the image, for example to apply the same levels to every frame of a
+	// roll. See FindLevels and MergeLevels.
+	Levels *Levels
+
+	// Rolloff, if > 0, is the fraction of the range at each end over which
+	// film mask removal and normalization compress values that would
+	// otherwise clip to black or white, so specular highlights roll off
+	// smoothly instead of posterizing. 0.05 is a gentle shoulder.
+	Rolloff float64
+
+	// Invert inverts the image after setting levels.
+	Invert bool
+
+	// Slide converts positive slide film: Base and Invert are ignored, but
+	// gamma correction, normalization, and later stages still apply.
+	Slide bool
+
+	// BW converts black and white film as a single luminance channel, using
+	// the mean of the gamma channels, which are all the same for profiles
+	// extracted from black and white datasheets. Toning, if set, colors the
+	// result.
+	BW     bool
+	Toning Toning
+
+	// FilmLight is the illuminant the film is balanced for, and Light the
+	// one it was exposed under, such as tungsten balanced cine film shot in
+	// daylight. Differences are corrected before normalization, so they
+	// matter most when levels are shared, as with Linked or Levels.
+	FilmLight Illuminant
+	Light     Illuminant
+
+	// Density converts the negative in log density space instead: the film
+	// mask density is subtracted, and the positive is recovered by undoing
+	// the film gamma, like optical printing. The result is a linear positive,
+	// so Divide and Invert are ignored, and normalization sets the density
+	// range printed from black to white.
+	Density bool
+
+	// Matrix is an optional color correction matrix applied last, after
+	// inversion, typically fitted to a target with FitMatrix.
+	Matrix *Matrix
+
+	// Neutral, if not empty, is a region of the image, such as a gray card,
+	// that is made neutral after conversion by scaling the channels.
+	Neutral image.Rectangle
+
+	// Balance is the automatic white balance applied after conversion, if
+	// Neutral is empty.
+	Balance Balance
+
+	// Temp and Tint, from -100 to 100, warm or cool the positive and shift
+	// it towards magenta or green.
+	Temp float64
+	Tint float64
+
+	// EV scales the exposure of the positive in photographic stops, in
+	// linear light.
+	EV float64
+
+	// Denoise and DenoiseChroma, from 0 to 1, reduce film grain in the
+	// luminance and color of the positive, before the tone curve. See
+	// Denoise.
+	Denoise       float64
+	DenoiseChroma float64
+
+	// LocalContrast, from 0 to 1, enhances local contrast in flat negatives
+	// that normalization can't rescue, such as expired or underdeveloped
+	// film, after noise reduction and before Highlights. LocalContrastClip
+	// limits the contrast gained, or DefaultLocalContrastClip if 0, and
+	// LocalContrastTiles is the number of tiles along the longest side, or
+	// DefaultLocalContrastTiles if 0. See LocalContrast.
+	LocalContrast      float64
+	LocalContrastClip  float64
+	LocalContrastTiles int
+
+	// Highlights and Shadows, from 0 to 1, recover detail in the brightest
+	// and darkest tones of the positive, such as dense skies or deep
+	// shadows crushed by normalization, by compressing them towards the
+	// middle of the tonal range while keeping black and white in place,
+	// before Midtone.
+	Highlights float64
+	Shadows    float64
+
+	// Midtone is a gamma adjustment that brightens (> 1) or darkens (< 1)
+	// the positive without moving its black and white points. 0 is the same
+	// as 1, no adjustment.
+	Midtone float64
+
+	// Tone is the tone curve applied last, and Contrast, from -1 to 1,
+	// lowers or raises its contrast. Straight normalized conversions are
+	// flat compared to lab scans.
+	Tone     Tone
+	Contrast float64
+
+	// Look, if set, emulates the color rendering of a lab scanner, after
+	// the tone curve. See Looks.
+	Look Look
+
+	// ColorSpace, if set, encodes the positive in that color space last,
+	// converting from the sRGB primaries and display gamma, or linear
+	// values in density mode, it otherwise has.
+	ColorSpace ColorSpace
+
+	// Sharpen, if > 0, is the amount of unsharp masking applied to the
+	// final positive, with a blur of SharpenRadius pixels, or
+	// DefaultSharpenRadius if 0, ignoring differences below
+	// SharpenThreshold. See Sharpen.
+	Sharpen          float64
+	SharpenRadius    float64
+	SharpenThreshold float64
+
+	// Threads limits the number of goroutines used to process a single
+	// image. If <= 0, GOMAXPROCS is used.
+	Threads int
+
+	// OnProgress, if not nil, is called by Process as it runs with the
+	// stage being run and the fraction of the whole conversion done, from 0
+	// to 1. It is called from the goroutines processing the image, but
+	// never concurrently.
+	OnProgress func(stage string, fraction float64)
+}
+
+// DefaultOptions returns the options used by the positive command line tool,
+// with no film mask removal and no gamma correction.
+func DefaultOptions() Options {
+	return Options{
+		Gamma:     Profiles["none"].Gamma,
+		Normalize: true,
+		Border:    10,
+		Upper:     10,
+		Lower:     10,
+		Invert:    true,
+	}
+}
+
+// Process converts m using the given options. Stages are applied in order:
+// film mask removal, gamma correction, normalization, inversion, color
+// correction, and adjustments to the positive such as white balance, in a
+// single pass producing one new image. See Options.Density for
+// the alternative log density pipeline. Grayscale images, *image.Gray and
+// *image.Gray16, are converted as black and white film with a single
+// channel, returning an *image.Gray16 unless Options.Toning colors it.
+func Process(m image.Image, o Options) (image.Image, error) {
+	return ProcessContext(context.Background(), m, o)
+}
+
+// ProcessContext is Process, but stops between stripes of rows once ctx is
+// done, returning ctx.Err(), so a long conversion can be cancelled.
+func ProcessContext(ctx context.Context, m image.Image, o Options) (image.Image, error) {
+	p, _, err := process(ctx, m, o)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// process converts m like ProcessContext, also returning the levels
+// normalized to, if any.
+func process(ctx context.Context, m image.Image, o Options) (image.Image, *Levels, error) {
+	if err := o.validate(m.Bounds()); err != nil {
+		return nil, nil, err
+	}
+
+	// a grayscale scan has a single channel, so it is black and white film,
+	// and is converted to grayscale unless toned
+	gray := isGray(m) && o.Toning == ToningNone
+	ctx, mt := withMeter(ctx, o.OnProgress, o.rows(m))
+
+	m, alpha, o, err := o.prepare(ctx, m)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// All stages operate on single pixels, so they are fused into one pass
+	// over the image, in floating point so values are only rounded and
+	// clipped once, when stored. Normalization levels depend on the mask
+	// removed and gamma corrected image, which are gathered in a preliminary
+	// scan that doesn't allocate an intermediate image.
+	pre := o.pre()
+
+	var levels *Levels
+	if o.Normalize {
+		levels = o.Levels
+		if levels == nil {
+			mt.stage("levels")
+			l := o.findLevels(ctx, m, pre)
+			if err := ctx.Err(); err != nil {
+				return nil, nil, err
+			}
+			levels = &l
+		}
+	}
+
+	normalized := levels
+
+	var matrix pixelFunc
+	if o.Matrix != nil {
+		matrix = o.Matrix.apply
+	}
+
+	var conv pixelFunc
+	if o.Density {
+		// the print always maps some density range to black and white
+		if levels == nil {
+			levels = &Levels{RMax: 0xffff, GMax: 0xffff, BMax: 0xffff}
+		}
+		conv = compose(pre, printFunc(*levels, o.gamma(), o.Rolloff), matrix)
+	} else {
+		var stretch pixelFunc
+		if levels != nil {
+			stretch = levels.rolloff(o.Rolloff)
+		}
+
+		var inv pixelFunc
+		if o.Invert && !o.Slide {
+			inv = invertFunc
+		}
+
+		conv = compose(pre, stretch, inv, matrix)
+	}
+
+	adjust, tonal := o.post(m, conv)
+	mt.stage("convert")
+
+	// noise reduction, local contrast, and sharpening look at neighboring
+	// pixels, so they split the pass, keeping the positive in floating
+	// point in between. Noise reduction comes before the tone curve
+	// exaggerates the grain, local contrast works on the cleaned up tones
+	// before the tonal stages shape them, and sharpening is last, so it
+	// isn't undone by other stages. The tonal stages are folded into the
+	// last of the first two that runs.
+	var src image.Image = m
+	f := compose(conv, adjust, tonal)
+	denoising := o.Denoise > 0 || o.DenoiseChroma > 0
+	if denoising || o.LocalContrast > 0 || o.Sharpen > 0 {
+		var p *floatImage
+		var err error
+		if denoising || o.LocalContrast > 0 {
+			if p, err = mapFloat(ctx, m, compose(conv, adjust), o.Threads); err != nil {
+				return nil, nil, err
+			}
+		} else if p, err = mapFloat(ctx, m, f, o.Threads); err != nil {
+			return nil, nil, err
+		}
+		if denoising {
+			after := tonal
+			if o.LocalContrast > 0 {
+				after = identity
+			}
+			mt.stage("denoise")
+			if p, err = denoise(ctx, p, o.Denoise, o.DenoiseChroma, after, o.Threads); err != nil {
+				return nil, nil, err
+			}
+		}
+		if o.LocalContrast > 0 {
+			clip, tiles := o.LocalContrastClip, o.LocalContrastTiles
+			if clip == 0 {
+				clip = DefaultLocalContrastClip
+			}
+			if tiles == 0 {
+				tiles = DefaultLocalContrastTiles
+			}
+			mt.stage("local contrast")
+			if p, err = localContrast(ctx, p, o.LocalContrast, clip, tiles, o.Density, tonal, o.Threads); err != nil {
+				return nil, nil, err
+			}
+		}
+		if o.Sharpen > 0 {
+			mt.stage("sharpen")
+			if err := sharpen(ctx, p, o.Sharpen, o.SharpenRadius, o.SharpenThreshold, o.Threads); err != nil {
+				return nil, nil, err
+			}
+		}
+		src, f = p, identity
+	}
+
+	if gray {
+		p, err := mapGray(ctx, src, f, o.Threads)
+		if err != nil {
+			return nil, nil, err
+		}
+		mt.finish()
+		return p, normalized, nil
+	}
+	p, err := mapPixelsContext(ctx, src, f, o.Threads)
+	if err != nil {
+		return nil, nil, err
+	}
+	if alpha != nil {
+		a, err := withAlpha(ctx, p, alpha, o.Threads)
+		if err != nil {
+			return nil, nil, err
+		}
+		mt.finish()
+		return a, normalized, nil
+	}
+	mt.finish()
+	return p, normalized, nil
+}
+
+// prepare returns m as the conversion stages see it, and o adjusted to it:
+// converted as if opaque, with the alpha channel split off and returned if
+// o.Alpha is set, and despeckled if o.Dust is set, so specks don't skew the
+// levels either. Grayscale scans are converted as black and white film.
+func (o Options) prepare(ctx context.Context, m image.Image) (image.Image, *image.Alpha16, Options, error) {
+	mt := meterFrom(ctx)
+	if isGray(m) {
+		o.BW = true
+	}
+
+	var alpha *image.Alpha16
+	if o.Alpha && hasAlpha(m) {
+		mt.stage("alpha")
+		min := m.Bounds().Min
+		c, a, err := splitAlpha(ctx, m, o.Threads)
+		if err != nil {
+			return nil, nil, o, err
+		}
+		m, alpha = c, a
+
+		// the opaque copy starts at the origin
+		o.ROI, o.Neutral = o.ROI.Sub(min), o.Neutral.Sub(min)
+		if o.Exclude == nil {
+			o.Exclude = transparent(a)
+		}
+	}
+
+	if o.Dust > 0 {
+		mt.stage("despeckle")
+		radius := o.DustRadius
+		if radius == 0 {
+			radius = DefaultDustRadius
+		}
+		min := m.Bounds().Min
+		var err error
+		if m, err = DespeckleContext(ctx, m, o.Dust, radius, o.Threads); err != nil {
+			return nil, nil, o, err
+		}
+
+		// the despeckled copy starts at the origin
+		o.ROI, o.Neutral = o.ROI.Sub(min), o.Neutral.Sub(min)
+	}
+	return m, alpha, o, nil
+}
+
+// rows returns the number of rows of all the passes Process makes over m,
+// to report its progress by.
+func (o Options) rows(m image.Image) int {
+	h := m.Bounds().Dy()
+	n := h
+	if o.Dust > 0 {
+		// copy and speck mask
+		n += 2 * h
+	}
+	if o.Normalize && o.Levels == nil {
+		n += o.interior(m.Bounds()).Dy()
+	}
+	if o.Denoise > 0 || o.DenoiseChroma > 0 || o.LocalContrast > 0 || o.Sharpen > 0 {
+		// the floating point copy
+		n += h
+	}
+	if o.Denoise > 0 || o.DenoiseChroma > 0 {
+		// splitting and recombining the planes
+		n += 2 * h
+		if o.Denoise > 0 {
+			n += h
+		}
+		if math.Ceil(o.DenoiseChroma*chromaRadius) > 0 {
+			// two passes for each color plane
+			n += 4 * h
+		}
+	}
+	if o.LocalContrast > 0 {
+		// the tile histograms and applying them
+		n += 2 * h
+	}
+	if o.Sharpen > 0 {
+		// luminance, two blur passes, and the mask
+		n += 4 * h
+	}
+	if o.Alpha && hasAlpha(m) {
+		// splitting off the alpha channel and putting it back
+		n += 2 * h
+	}
+	return n
+}
+
+func (o Options) validate(bounds image.Rectangle) error {
+	if o.Gamma.R <= 0 || o.Gamma.G <= 0 || o.Gamma.B <= 0 {
+		return errorf(ErrInvalidOptions, "gamma values must be positive")
+	}
+	if o.Dust < 0 || o.Dust >= 1 {
+		return errorf(ErrInvalidOptions, "dust threshold must be in the range [0,1)")
+	}
+	if o.DustRadius < 0 {
+		return errorf(ErrInvalidOptions, "dust radius must not be negative")
+	}
+	if o.Denoise < 0 || o.Denoise > 1 || o.DenoiseChroma < 0 || o.DenoiseChroma > 1 {
+		return errorf(ErrInvalidOptions, "denoise strengths must be in the range [0,1]")
+	}
+	if o.LocalContrast < 0 || o.LocalContrast > 1 {
+		return errorf(ErrInvalidOptions, "local contrast must be in the range [0,1]")
+	}
+	if o.LocalContrastClip != 0 && o.LocalContrastClip < 1 {
+		return errorf(ErrInvalidOptions, "local contrast clip limit must be at least 1")
+	}
+	if o.LocalContrastTiles < 0 {
+		return errorf(ErrInvalidOptions, "local contrast tiles must not be negative")
+	}
+	if o.Sharpen < 0 || o.SharpenRadius < 0 || o.SharpenThreshold < 0 {
+		return errorf(ErrInvalidOptions, "sharpening parameters must not be negative")
+	}
+	if o.Border < 0 || o.Border >= 50 {
+		return errorf(ErrInvalidOptions, "border must be in the range [0,50)")
+	}
+	if o.Rolloff < 0 || o.Rolloff >= 0.5 {
+		return errorf(ErrInvalidOptions, "rolloff must be in the range [0,0.5)")
+	}
+	if x := o.Margins; x != nil {
+		if x.Top < 0 || x.Right < 0 || x.Bottom < 0 || x.Left < 0 || x.Top+x.Bottom >= 100 || x.Left+x.Right >= 100 {
+			return errorf(ErrInvalidOptions, "margins must not be negative and must leave some of the image")
+		}
+	}
+	if !o.ROI.Empty() && o.ROI.Intersect(bounds).Empty() {
+		return errorf(ErrImageMismatch, "region of interest is outside the image")
+	}
+	if _, ok := illuminants[o.FilmLight]; !ok {
+		return errorf(ErrInvalidOptions, "unknown illuminant %q", o.FilmLight)
+	}
+	if _, ok := illuminants[o.Light]; !ok {
+		return errorf(ErrInvalidOptions, "unknown illuminant %q", o.Light)
+	}
+	if _, ok := tonings[o.Toning]; !ok {
+		return errorf(ErrInvalidOptions, "unknown toning %q", o.Toning)
+	}
+	if o.Curves != nil {
+		if err := o.Curves.validate(); err != nil {
+			return err
+		}
+		if o.Density {
+			return errorf(ErrInvalidOptions, "curves can't be used in density mode")
+		}
+	}
+	if o.Slide && o.Density {
+		return errorf(ErrInvalidOptions, "slide film can't be converted in density mode")
+	}
+	switch o.Balance {
+	case BalanceNone, BalanceGrayWorld, BalanceHighlights:
+	default:
+		return errorf(ErrInvalidOptions, "unknown white balance %q", o.Balance)
+	}
+	if _, ok := tones[o.Tone]; !ok {
+		return errorf(ErrInvalidOptions, "unknown tone curve %q", o.Tone)
+	}
+	if _, ok := looks[o.Look]; !ok {
+		return errorf(ErrInvalidOptions, "unknown look %q", o.Look)
+	}
+	if o.ColorSpace != ColorSpaceNone {
+		if _, err := icc.Primaries(string(o.ColorSpace)); err != nil {
+			return errorf(ErrInvalidOptions, "unknown color space %q", o.ColorSpace)
+		}
+	}
+	if o.Highlights < 0 || o.Highlights > 1 || o.Shadows < 0 || o.Shadows > 1 {
+		return errorf(ErrInvalidOptions, "highlights and shadows must be in the range [0,1]")
+	}
+	if o.Midtone < 0 {
+		return errorf(ErrInvalidOptions, "midtone must be positive")
+	}
+	if o.Contrast < -1 || o.Contrast > 1 {
+		return errorf(ErrInvalidOptions, "contrast must be in the range [-1,1]")
+	}
+	if o.Temp < -100 || o.Temp > 100 || o.Tint < -100 || o.Tint > 100 {
+		return errorf(ErrInvalidOptions, "temp and tint must be in the range [-100,100]")
+	}
+	if !o.Neutral.Empty() && o.Neutral.Intersect(bounds).Empty() {
+		return errorf(ErrImageMismatch, "neutral region is outside the image")
+	}
+	if o.Exclude != nil && o.Exclude.Bounds().Size() != bounds.Size() {
+		return errorf(ErrImageMismatch, "exclusion mask must be the same size as the image")
+	}
+	if o.Base != nil {
+		// such as a sample outside the image, or of the film holder
+		if r, g, b, _ := o.Base.RGBA(); r == 0 || g == 0 || b == 0 {
+			return errorf(ErrBadBaseSample, "film base sample has an empty channel")
+		}
+	}
+	return nil
+}
+
+// pre returns the stages applied before normalization: film mask removal and
+// gamma correction, or conversion to density.
+func (o Options) pre() pixelFunc {
+	var luma pixelFunc
+	if o.BW {
+		luma = lumaFunc
+	}
+
+	var light pixelFunc
+	if illuminants[o.FilmLight] != illuminants[o.Light] {
+		light = lightFunc(lightGains(o.FilmLight, o.Light), o.gamma(), o.Density)
+	}
+
+	if o.Density {
+		return compose(densityFunc(o.Base), luma, light)
+	}
+
+	var cast pixelFunc
+	if o.Base != nil && !o.Slide {
+		cast = castFunc(o.Base, o.Rolloff)
+		if o.Divide {
+			cast = divideFunc(o.Base)
+		}
+	}
+	g := o.gamma()
+	correct := gammaFunc(1/g.R, 1/g.G, 1/g.B)
+	if o.Curves != nil {
+		correct = curvesFunc(o.Curves)
+	}
+	return compose(cast, luma, correct, light)
+}
+
+// gamma returns the film gamma to correct for, a single value for all
+// channels in black and white mode.
+func (o Options) gamma() Gamma {
+	if !o.BW {
+		return o.Gamma
+	}
+	g := (o.Gamma.R + o.Gamma.G + o.Gamma.B) / 3
+	return Gamma{R: g, G: g, B: g}
+}
+
+// Rec. 709 luminance weights of each channel
+const lumaR, lumaG, lumaB = 0.2126, 0.7152, 0.0722
+
+// luminance returns the Rec. 709 luminance of a pixel.
+func luminance(r, g, b float32) float32 {
+	return lumaR*r + lumaG*g + lumaB*b
+}
+
+// lumaFunc replaces each channel with the Rec. 709 luminance of the pixel.
+func lumaFunc(r, g, b float32) (float32, float32, float32) {
+	y := luminance(r, g, b)
+	return y, y, y
+}
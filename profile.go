@@ -0,0 +1,269 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package positive
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Gamma is a per channel gamma correction profile for a film stock.
+type Gamma struct {
+	R float64 `json:"r"`
+	G float64 `json:"g"`
+	B float64 `json:"b"`
+}
+
+// Curves are per channel characteristic curves, as log exposure, density
+// pairs in increasing exposure. Uncalibrated curves from the gamma tool are in
+// fractions of the plot size instead.
+type Curves struct {
+	R [][2]float64 `json:"r"`
+	G [][2]float64 `json:"g"`
+	B [][2]float64 `json:"b"`
+}
+
+// A Profile is a named film gamma profile, as stored in profile files and
+// produced by the gamma tool and the step wedge calibration.
+type Profile struct {
+	Name string `json:"name"`
+	Gamma
+
+	// Source describes where the profile came from, such as the datasheet
+	// plot or wedge scan it was measured from.
+	Source string `json:"source,omitempty"`
+
+	// Notes are free form notes on the stock or profile.
+	Notes string `json:"notes,omitempty"`
+
+	// Fit is the coefficient of determination (r²) of each channel's
+	// gamma, if known.
+	Fit *Gamma `json:"fit,omitempty"`
+
+	// Curves are the full measured characteristic curves, if known.
+	Curves *Curves `json:"curves,omitempty"`
+
+	// Push are the gammas of the stock when pushed or pulled, if known.
+	Push []PushGamma `json:"push,omitempty"`
+
+	// Matrix is an optional color correction matrix for the stock, such as
+	// fitted by the target command, correcting dye crossover and scanner
+	// spectral response.
+	Matrix *Matrix `json:"matrix,omitempty"`
+
+	// Light is the illuminant the stock is balanced for, daylight if not
+	// set. Motion picture stocks are often tungsten balanced.
+	Light Illuminant `json:"light,omitempty"`
+}
+
+// A PushGamma is the gamma of a stock pushed by Stops, or pulled if negative.
+type PushGamma struct {
+	Stops float64 `json:"stops"`
+	Gamma
+}
+
+// pushContrast is the assumed growth in gamma per stop of push processing,
+// for profiles without tabulated push gammas.
+const pushContrast = 1.15
+
+// Pushed returns the gamma of the stock pushed by stops, or pulled if stops is
+// negative. Tabulated push gammas are interpolated, and beyond them, or
+// without any, gamma is assumed to grow by 15% per stop.
+func (p Profile) Pushed(stops float64) Gamma {
+	points := append([]PushGamma{{Gamma: p.Gamma}}, p.Push...)
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].Stops < points[j].Stops
+	})
+
+	scale := func(g Gamma, k float64) Gamma {
+		return Gamma{R: g.R * k, G: g.G * k, B: g.B * k}
+	}
+
+	first, last := points[0], points[len(points)-1]
+	switch {
+	case stops <= first.Stops:
+		return scale(first.Gamma, math.Pow(pushContrast, stops-first.Stops))
+	case stops >= last.Stops:
+		return scale(last.Gamma, math.Pow(pushContrast, stops-last.Stops))
+	}
+
+	for i := 1; i < len(points); i++ {
+		a, b := points[i-1], points[i]
+		if stops <= b.Stops {
+			t := (stops - a.Stops) / (b.Stops - a.Stops)
+			return Gamma{
+				R: a.R + t*(b.R-a.R),
+				G: a.G + t*(b.G-a.G),
+				B: a.B + t*(b.B-a.B),
+			}
+		}
+	}
+	return last.Gamma
+}
+
+// Gamma correction profiles. Values are generated by the included gamma tool.
+var Profiles = map[string]Profile{
+	"none": {
+		Name:  "none",
+		Notes: "No gamma correction, for scans that are already linear positives or for testing",
+		Gamma: Gamma{
+			R: 1.0,
+			G: 1.0,
+			B: 1.0,
+		},
+	},
+	"ektar100": {
+		Name:   "ektar100",
+		Source: "ektar100.png",
+		Notes:  "Kodak Ektar 100",
+		Gamma: Gamma{
+			R: 0.5733379896124348,
+			G: 0.5737822736392102,
+			B: 0.6624829032379945,
+		},
+	},
+	"portra160": {
+		Name:   "portra160",
+		Source: "portra160.png",
+		Notes:  "Kodak Portra 160",
+		Gamma: Gamma{
+			R: 0.5303095093187974,
+			G: 0.5424400871459694,
+			B: 0.6105737489503811,
+		},
+	},
+	"portra800": {
+		Name:   "portra800",
+		Source: "portra800.png",
+		Notes:  "Kodak Portra 800",
+		Gamma: Gamma{
+			R: 0.5228012326204643,
+			G: 0.536735995403697,
+			B: 0.6114420242779521,
+		},
+	},
+	"acros2": {
+		Name:   "acros2",
+		Source: "acros2.png",
+		Notes:  "Fujifilm Neopan Acros II, black and white",
+		Gamma: Gamma{
+			R: 0.39215561420017303,
+			G: 0.39215561420017303,
+			B: 0.39215561420017303,
+		},
+	},
+	"trix400": {
+		Name:   "trix400",
+		Source: "trix400.png",
+		Notes:  "Kodak Tri-X 400, black and white",
+		Gamma: Gamma{
+			R: 0.6124631002951977,
+			G: 0.6124631002951977,
+			B: 0.6124631002951977,
+		},
+	},
+}
+
+// LoadProfiles adds the gamma profiles in the given JSON file to Profiles,
+// replacing any existing profiles with the same name. The file holds either a
+// single profile or a list of profiles:
+//
+//	[
+//		{"name": "stock-a", "r": 0.6, "g": 0.6, "b": 0.65},
+//		{"name": "stock-b", "r": 0.55, "g": 0.6, "b": 0.7}
+//	]
+func LoadProfiles(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var profiles []Profile
+	if d := bytes.TrimSpace(data); len(d) > 0 && d[0] == '{' {
+		var p Profile
+		err = json.Unmarshal(d, &p)
+		profiles = append(profiles, p)
+	} else {
+		err = json.Unmarshal(d, &profiles)
+	}
+	if err != nil {
+		return errorf(ErrBadProfile, "%v: %v", path, err)
+	}
+
+	for _, p := range profiles {
+		if p.Name == "" {
+			return errorf(ErrBadProfile, "%v: profile without a name", path)
+		}
+		if p.R <= 0 || p.G <= 0 || p.B <= 0 {
+			return errorf(ErrBadProfile, "%v: profile %v: gamma values must be positive", path, p.Name)
+		}
+		for _, g := range p.Push {
+			if g.R <= 0 || g.G <= 0 || g.B <= 0 {
+				return errorf(ErrBadProfile, "%v: profile %v: push gamma values must be positive", path, p.Name)
+			}
+		}
+		if _, ok := illuminants[p.Light]; !ok {
+			return errorf(ErrBadProfile, "%v: profile %v: unknown light %q", path, p.Name, p.Light)
+		}
+	}
+	for _, p := range profiles {
+		Profiles[p.Name] = p
+	}
+	return nil
+}
+
+// Blend returns a profile approximating a stock between the given profiles,
+// with each gamma weighted by the corresponding weight. Weights are
+// normalized to sum to 1. Push gammas are blended at the stops of the first
+// profile's table, and the light is kept only if all profiles agree.
+func Blend(ps []Profile, weights []float64) (Profile, error) {
+	if len(ps) == 0 || len(ps) != len(weights) {
+		return Profile{}, errorf(ErrInvalidOptions, "blend needs one weight per profile")
+	}
+
+	var total float64
+	for _, w := range weights {
+		if w < 0 {
+			return Profile{}, errorf(ErrInvalidOptions, "blend weights must not be negative")
+		}
+		total += w
+	}
+	if total == 0 {
+		return Profile{}, errorf(ErrInvalidOptions, "blend weights must not all be zero")
+	}
+
+	var names []string
+	b := Profile{Light: ps[0].Light}
+	for i, p := range ps {
+		w := weights[i] / total
+		names = append(names, fmt.Sprintf("%v:%v", p.Name, weights[i]))
+		b.R += w * p.R
+		b.G += w * p.G
+		b.B += w * p.B
+		if p.Light != b.Light {
+			b.Light = ""
+		}
+	}
+	b.Name = strings.Join(names, ",")
+
+	for _, g := range ps[0].Push {
+		pg := PushGamma{Stops: g.Stops}
+		for i, p := range ps {
+			w := weights[i] / total
+			x := p.Pushed(g.Stops)
+			pg.R += w * x.R
+			pg.G += w * x.G
+			pg.B += w * x.B
+		}
+		b.Push = append(b.Push, pg)
+	}
+	return b, nil
+}
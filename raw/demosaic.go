@@ -0,0 +1,69 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package raw
+
+import (
+	"image"
+	"image/color"
+)
+
+// A pattern is a 2x2 color filter array layout, with 0, 1, and 2 for red,
+// green, and blue sites, indexed by [y%2][x%2].
+type pattern [2][2]uint32
+
+// filterPattern returns the 2x2 pattern described by the CFA tags, defaulting
+// to RGGB when the pattern is missing or not 2x2.
+func filterPattern(d ifd, p []uint32) pattern {
+	dim := d[tCFARepeatPattern]
+	if len(p) == 4 && (len(dim) == 0 || (len(dim) == 2 && dim[0] == 2 && dim[1] == 2)) {
+		return pattern{{p[0], p[1]}, {p[2], p[3]}}
+	}
+	return pattern{{0, 1}, {1, 2}}
+}
+
+// demosaic bilinearly interpolates the missing colors at every site of c.
+func demosaic(c *cfa, p pattern) image.Image {
+	ret := image.NewRGBA64(image.Rect(0, 0, c.w, c.h))
+
+	for y := 0; y < c.h; y++ {
+		for x := 0; x < c.w; x++ {
+			var sum [3]uint32
+			var n [3]uint32
+
+			// average each color over the 3x3 neighborhood, which
+			// always contains at least one site of every color
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					sx, sy := x+dx, y+dy
+					if sx < 0 || sy < 0 || sx >= c.w || sy >= c.h {
+						continue
+					}
+					k := p[sy%2][sx%2]
+					if k > 2 {
+						continue
+					}
+					sum[k] += uint32(c.pix[sy*c.w+sx])
+					n[k]++
+				}
+			}
+
+			// the site's own color is known exactly
+			var v [3]uint16
+			for k := range v {
+				if n[k] != 0 {
+					v[k] = uint16(sum[k] / n[k])
+				}
+			}
+			if k := p[y%2][x%2]; k <= 2 {
+				v[k] = c.pix[y*c.w+x]
+			}
+
+			ret.SetRGBA64(x, y, color.RGBA64{R: v[0], G: v[1], B: v[2], A: 0xffff})
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,327 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package raw
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// JPEG markers used by lossless JPEG
+const (
+	mSOF3 = 0xc3
+	mDHT  = 0xc4
+	mRST0 = 0xd0
+	mRST7 = 0xd7
+	mSOI  = 0xd8
+	mEOI  = 0xd9
+	mSOS  = 0xda
+	mDRI  = 0xdd
+)
+
+// An ljpeg is a decoded lossless JPEG (ITU T.81 process 14) image, as DNG
+// files compress raw data with, its components interleaved in each row.
+type ljpeg struct {
+	w, h, comps int
+	pix         []uint16
+}
+
+// A huffman is a Huffman table of lossless JPEG difference categories.
+type huffman struct {
+	// the largest code of each length, or -1, and the index in vals of the
+	// first code of each length, less that code
+	maxcode [17]int32
+	offset  [17]int32
+	vals    []byte
+}
+
+// decodeLJPEG decodes the lossless JPEG image in data, of at most max
+// samples.
+func decodeLJPEG(data []byte, max int) (*ljpeg, error) {
+	if len(data) < 2 || data[0] != 0xff || data[1] != mSOI {
+		return nil, errors.New("raw: not a lossless JPEG")
+	}
+
+	var (
+		img      *ljpeg
+		bits     int
+		tables   [4]*huffman
+		restart  int
+		pos      = 2
+		errShort = fmt.Errorf("raw: lossless JPEG: %w", io.ErrUnexpectedEOF)
+	)
+	for {
+		// markers may be preceded by any number of 0xff fill bytes
+		for pos < len(data) && data[pos] == 0xff && pos+1 < len(data) && data[pos+1] == 0xff {
+			pos++
+		}
+		if pos+4 > len(data) || data[pos] != 0xff {
+			return nil, errShort
+		}
+		marker := data[pos+1]
+		if marker == mEOI {
+			return nil, errors.New("raw: lossless JPEG without a scan")
+		}
+		n := int(data[pos+2])<<8 | int(data[pos+3])
+		if n < 2 || pos+2+n > len(data) {
+			return nil, errShort
+		}
+		seg := data[pos+4 : pos+2+n]
+		pos += 2 + n
+
+		switch {
+		case marker == mSOF3:
+			if len(seg) < 6 {
+				return nil, errShort
+			}
+			bits = int(seg[0])
+			h := int(seg[1])<<8 | int(seg[2])
+			w := int(seg[3])<<8 | int(seg[4])
+			comps := int(seg[5])
+			if bits < 2 || bits > 16 || w == 0 || h == 0 || comps < 1 || comps > 4 || len(seg) < 6+3*comps {
+				return nil, errors.New("raw: invalid lossless JPEG frame")
+			}
+			for i := 0; i < comps; i++ {
+				if seg[7+i*3] != 0x11 {
+					return nil, fmt.Errorf("%w: subsampled lossless JPEG", ErrUnsupported)
+				}
+			}
+			if w*h*comps > max {
+				return nil, errors.New("raw: lossless JPEG larger than its tile")
+			}
+			img = &ljpeg{w: w, h: h, comps: comps, pix: make([]uint16, w*h*comps)}
+		case marker == mDHT:
+			for len(seg) > 0 {
+				if len(seg) < 17 || seg[0]&0x0f > 3 {
+					return nil, errors.New("raw: invalid Huffman table")
+				}
+				var t huffman
+				var code, k int32
+				total := 0
+				for l := 1; l <= 16; l++ {
+					c := int32(seg[l])
+					t.offset[l] = k - code
+					t.maxcode[l] = -1
+					if c > 0 {
+						t.maxcode[l] = code + c - 1
+					}
+					code = (code + c) << 1
+					k += c
+					total += int(c)
+				}
+				if len(seg) < 17+total {
+					return nil, errShort
+				}
+				t.vals = seg[17 : 17+total]
+				tables[seg[0]&0x0f] = &t
+				seg = seg[17+total:]
+			}
+		case marker == mDRI:
+			if len(seg) < 2 {
+				return nil, errShort
+			}
+			restart = int(seg[0])<<8 | int(seg[1])
+		case marker == mSOS:
+			if img == nil {
+				return nil, errors.New("raw: lossless JPEG scan before its frame")
+			}
+			if len(seg) < 1 || int(seg[0]) != img.comps || len(seg) < 4+2*img.comps {
+				return nil, fmt.Errorf("%w: lossless JPEG scan of some components", ErrUnsupported)
+			}
+			hts := make([]*huffman, img.comps)
+			for i := range hts {
+				if hts[i] = tables[seg[2+i*2]>>4&3]; hts[i] == nil {
+					return nil, errors.New("raw: lossless JPEG scan without a Huffman table")
+				}
+			}
+			pred := int(seg[1+2*img.comps])
+			pt := uint(seg[3+2*img.comps] & 0x0f)
+			if pred < 1 || pred > 7 || int(pt) >= bits {
+				return nil, fmt.Errorf("raw: invalid lossless JPEG predictor %v or point transform %v", pred, pt)
+			}
+			if err := img.scan(data[pos:], hts, pred, bits, pt, restart); err != nil {
+				return nil, err
+			}
+			return img, nil
+		case marker >= 0xc0 && marker <= 0xcf && marker != mDHT:
+			return nil, fmt.Errorf("%w: JPEG process %#x", ErrUnsupported, marker)
+		}
+	}
+}
+
+// scan decodes the entropy coded samples of img from data, with the given
+// predictor, precision, point transform, and restart interval in pixels.
+func (img *ljpeg) scan(data []byte, hts []*huffman, pred, bits int, pt uint, restart int) error {
+	br := bitReader{data: data}
+	row := img.w * img.comps
+	initial := int32(1) << (bits - int(pt) - 1)
+	first := true // the first row after the start or a restart
+	var left int
+
+	for y := 0; y < img.h; y++ {
+		cur := img.pix[y*row : (y+1)*row]
+		var prev []uint16
+		if !first {
+			prev = img.pix[(y-1)*row : y*row]
+		}
+		for x := 0; x < img.w; x++ {
+			if restart > 0 && left == restart {
+				if err := br.restart(); err != nil {
+					return err
+				}
+				left, first, prev = 0, true, nil
+			}
+			left++
+
+			for c := 0; c < img.comps; c++ {
+				i := x*img.comps + c
+				var p int32
+				switch {
+				case x == 0 && first:
+					p = initial
+				case first:
+					p = int32(cur[i-img.comps])
+				case x == 0:
+					p = int32(prev[i])
+				default:
+					ra, rb, rc := int32(cur[i-img.comps]), int32(prev[i]), int32(prev[i-img.comps])
+					switch pred {
+					case 1:
+						p = ra
+					case 2:
+						p = rb
+					case 3:
+						p = rc
+					case 4:
+						p = ra + rb - rc
+					case 5:
+						p = ra + (rb-rc)>>1
+					case 6:
+						p = rb + (ra-rc)>>1
+					case 7:
+						p = (ra + rb) >> 1
+					}
+				}
+
+				s, err := br.decode(hts[c])
+				if err != nil {
+					return err
+				}
+				var d int32
+				switch {
+				case s == 16:
+					d = 32768
+				case s > 0:
+					d = int32(br.bits(uint(s)))
+					if d < 1<<(s-1) {
+						d -= 1<<s - 1
+					}
+				}
+				cur[i] = uint16(p + d)
+			}
+		}
+		first = false
+	}
+	if br.eof {
+		return fmt.Errorf("raw: lossless JPEG: %w", io.ErrUnexpectedEOF)
+	}
+
+	// scale back up from the point transform
+	if pt > 0 {
+		for i, v := range img.pix {
+			img.pix[i] = v << pt
+		}
+	}
+	return nil
+}
+
+// A bitReader reads the entropy coded data of a JPEG scan, most significant
+// bit first, removing the zero bytes stuffed after 0xff bytes. Past a marker
+// or the end of the data it reads zeros.
+type bitReader struct {
+	data []byte
+	pos  int
+
+	// the next n bits, in the high bits of acc
+	acc uint64
+	n   uint
+
+	// of the next n bits, those padding past the end of the data
+	padded uint
+
+	marker bool // at a marker
+	eof    bool // padding past the end of the data was read
+}
+
+// fill reads bytes into acc until it holds at least 57 bits.
+func (b *bitReader) fill() {
+	for b.n <= 56 {
+		var c byte
+		switch {
+		case b.marker:
+		case b.pos >= len(b.data):
+			b.padded += 8
+		case b.data[b.pos] != 0xff:
+			c = b.data[b.pos]
+			b.pos++
+		case b.pos+1 < len(b.data) && b.data[b.pos+1] == 0:
+			c = 0xff
+			b.pos += 2
+		default:
+			b.marker = true
+		}
+		b.acc |= uint64(c) << (56 - b.n)
+		b.n += 8
+	}
+}
+
+// bits reads n bits, up to 16.
+func (b *bitReader) bits(n uint) uint32 {
+	if b.n < n {
+		b.fill()
+	}
+	v := uint32(b.acc >> (64 - n))
+	b.acc <<= n
+	b.n -= n
+	if b.padded > b.n {
+		b.eof = true
+	}
+	return v
+}
+
+// decode reads a difference category coded with t.
+func (b *bitReader) decode(t *huffman) (int, error) {
+	if b.n < 16 {
+		b.fill()
+	}
+	var code int32
+	for l := 1; l <= 16; l++ {
+		code = code<<1 | int32(b.acc>>63)
+		b.acc <<= 1
+		b.n--
+		if code <= t.maxcode[l] {
+			if b.padded > b.n {
+				b.eof = true
+			}
+			return int(t.vals[code+t.offset[l]]), nil
+		}
+	}
+	return 0, errors.New("raw: invalid Huffman code in lossless JPEG")
+}
+
+// restart skips to the data after the next restart marker.
+func (b *bitReader) restart() error {
+	for b.pos+1 < len(b.data) && !(b.data[b.pos] == 0xff && b.data[b.pos+1] >= mRST0 && b.data[b.pos+1] <= mRST7) {
+		b.pos++
+	}
+	if b.pos+1 >= len(b.data) {
+		return fmt.Errorf("raw: lossless JPEG restart marker: %w", io.ErrUnexpectedEOF)
+	}
+	b.pos += 2
+	b.acc, b.n, b.padded, b.marker = 0, 0, 0, false
+	return nil
+}
@@ -0,0 +1,361 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+// Package raw decodes camera raw files for camera scanning workflows. Raw
+// files are TIFF based (DNG, NEF, ARW); the first full resolution color filter
+// array image found is scaled to its black and white levels and bilinearly
+// demosaiced into a linear 16-bit RGB image. No white balance or color matrix
+// is applied, as the negative conversion removes the film mask cast anyway.
+//
+// Raw data may be uncompressed, or lossless JPEG compressed as in DNG files,
+// in strips or tiles. The compressed formats of NEF, ARW, and CR2 files aren't
+// supported; Adobe DNG Converter turns those into DNG.
+//
+// DecodeRGBI reads scanner TIFFs carrying a fourth, infrared channel, as used
+// for infrared dust removal.
+package raw
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+)
+
+// TIFF tags used to locate and unpack the raw data.
+const (
+	tNewSubfileType   = 254
+	tImageWidth       = 256
+	tImageLength      = 257
+	tBitsPerSample    = 258
+	tCompression      = 259
+	tPhotometric      = 262
+	tStripOffsets     = 273
+	tSamplesPerPixel  = 277
+	tRowsPerStrip     = 278
+	tStripByteCounts  = 279
+	tPlanarConfig     = 284
+	tTileWidth        = 322
+	tTileLength       = 323
+	tTileOffsets      = 324
+	tTileByteCounts   = 325
+	tSubIFDs          = 330
+	tExtraSamples     = 338
+	tCFARepeatPattern = 33421
+	tCFAPattern       = 33422
+	tExifIFD          = 34665
+	tExifCFAPattern   = 41730
+	tBlackLevel       = 50714
+	tWhiteLevel       = 50717
+	photometricRGB    = 2
+	photometricCFA    = 32803
+	compressionNone   = 1
+	compressionLJPEG  = 7
+	maxIFDs           = 64
+)
+
+// ErrUnsupported is returned for raw files that are valid but use a layout or
+// compression this package cannot decode.
+var ErrUnsupported = errors.New("raw: unsupported raw format")
+
+// An ifd is a parsed TIFF image file directory, mapping tags to their values.
+type ifd map[uint16][]uint32
+
+func (d ifd) get(tag uint16, def uint32) uint32 {
+	if v, ok := d[tag]; ok && len(v) > 0 {
+		return v[0]
+	}
+	return def
+}
+
+// Decode reads a camera raw file from r.
+func Decode(r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	bo, err := byteOrder(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// walk every IFD reachable from the header, including sub and exif
+	// IFDs, looking for the largest color filter array image
+	var raw ifd
+	var pattern []uint32
+	seen := make(map[uint32]bool)
+	queue := []uint32{bo.Uint32(data[4:])}
+	for len(queue) > 0 && len(seen) < maxIFDs {
+		off := queue[0]
+		queue = queue[1:]
+		if off == 0 || seen[off] {
+			continue
+		}
+		seen[off] = true
+
+		d, next, err := parseIFD(data, bo, off)
+		if err != nil {
+			return nil, err
+		}
+		queue = append(queue, next)
+		queue = append(queue, d[tSubIFDs]...)
+		queue = append(queue, d[tExifIFD]...)
+
+		if p, ok := d[tExifCFAPattern]; ok {
+			pattern = p
+		}
+		if d.get(tPhotometric, 0) != photometricCFA || d.get(tNewSubfileType, 0) != 0 {
+			continue
+		}
+		if raw == nil || d.get(tImageWidth, 0) > raw.get(tImageWidth, 0) {
+			raw = d
+		}
+	}
+	if raw == nil {
+		return nil, fmt.Errorf("%w: no color filter array image", ErrUnsupported)
+	}
+	if p, ok := raw[tCFAPattern]; ok {
+		pattern = p
+	}
+
+	cfa, err := unpack(data, bo, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return demosaic(cfa, filterPattern(raw, pattern)), nil
+}
+
+// byteOrder returns the byte order of the TIFF file data.
+func byteOrder(data []byte) (binary.ByteOrder, error) {
+	if len(data) < 8 {
+		return nil, errors.New("raw: file too short")
+	}
+
+	switch string(data[:2]) {
+	case "II":
+		return binary.LittleEndian, nil
+	case "MM":
+		return binary.BigEndian, nil
+	}
+	return nil, errors.New("raw: not a TIFF based raw file")
+}
+
+// parseIFD parses the IFD at off, returning it and the offset of the next IFD.
+func parseIFD(data []byte, bo binary.ByteOrder, off uint32) (ifd, uint32, error) {
+	if int(off)+2 > len(data) {
+		return nil, 0, errors.New("raw: IFD offset out of range")
+	}
+	n := int(bo.Uint16(data[off:]))
+	end := int(off) + 2 + n*12
+	if end+4 > len(data) {
+		return nil, 0, errors.New("raw: IFD out of range")
+	}
+
+	d := make(ifd)
+	for i := 0; i < n; i++ {
+		e := data[int(off)+2+i*12:]
+		tag := bo.Uint16(e)
+		typ := bo.Uint16(e[2:])
+		count := bo.Uint32(e[4:])
+
+		var size uint32
+		switch typ {
+		case 1, 2, 6, 7: // byte, ascii, sbyte, undefined
+			size = 1
+		case 3, 8: // short, sshort
+			size = 2
+		case 4, 9, 13: // long, slong, ifd
+			size = 4
+		case 5, 10: // rational, srational, numerator only
+			size = 8
+		default:
+			continue
+		}
+		if count > 1<<24 {
+			continue
+		}
+
+		v := e[8:12]
+		if size*count > 4 {
+			o := bo.Uint32(e[8:])
+			if uint64(o)+uint64(size*count) > uint64(len(data)) {
+				continue
+			}
+			v = data[o : o+size*count]
+		}
+
+		vals := make([]uint32, count)
+		for j := range vals {
+			switch size {
+			case 1:
+				vals[j] = uint32(v[j])
+			case 2:
+				vals[j] = uint32(bo.Uint16(v[j*2:]))
+			case 4, 8:
+				vals[j] = bo.Uint32(v[j*int(size):])
+			}
+		}
+		d[tag] = vals
+	}
+
+	return d, bo.Uint32(data[end:]), nil
+}
+
+// A cfa is the unpacked, level scaled raw sensor data.
+type cfa struct {
+	w, h int
+	pix  []uint16
+}
+
+// unpack reads the raw samples described by d and scales them from the black
+// and white levels to the full 16-bit range.
+func unpack(data []byte, bo binary.ByteOrder, d ifd) (*cfa, error) {
+	compression := d.get(tCompression, compressionNone)
+	if compression != compressionNone && compression != compressionLJPEG {
+		return nil, fmt.Errorf("%w: compression %v", ErrUnsupported, compression)
+	}
+	if d.get(tSamplesPerPixel, 1) != 1 {
+		return nil, fmt.Errorf("%w: multiple samples per pixel", ErrUnsupported)
+	}
+
+	w := int(d.get(tImageWidth, 0))
+	h := int(d.get(tImageLength, 0))
+	bits := int(d.get(tBitsPerSample, 16))
+	if w <= 0 || h <= 0 || bits < 1 || bits > 16 {
+		return nil, errors.New("raw: invalid raw image dimensions")
+	}
+	black := d.get(tBlackLevel, 0)
+	white := d.get(tWhiteLevel, 1<<uint(bits)-1)
+	if white <= black {
+		return nil, errors.New("raw: invalid black and white levels")
+	}
+
+	// samples take at least a bit each, even compressed, so the dimensions
+	// can't be larger than the file holds
+	least := uint64(bits)
+	if compression == compressionLJPEG {
+		least = 1
+	}
+	if uint64(w)*uint64(h)*least > 8*uint64(len(data)) {
+		return nil, fmt.Errorf("raw: %vx%v image larger than the file: %w", w, h, io.ErrUnexpectedEOF)
+	}
+	bs, err := blocks(data, d, w, h)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &cfa{w: w, h: h, pix: make([]uint16, w*h)}
+	for _, b := range bs {
+		bw := b.r.Dx()
+		if compression == compressionLJPEG {
+			j, err := decodeLJPEG(b.data, 8*len(b.data))
+			if err != nil {
+				return nil, err
+			}
+			// the samples of each tile row follow each other, whatever
+			// the shape of the JPEG image
+			if len(j.pix) < bw*(min(b.r.Max.Y, h)-b.r.Min.Y) {
+				return nil, errors.New("raw: lossless JPEG smaller than its tile")
+			}
+			for i, v := range j.pix {
+				x, y := b.r.Min.X+i%bw, b.r.Min.Y+i/bw
+				if y >= h {
+					break
+				}
+				if x < w {
+					c.pix[y*w+x] = scale(uint32(v), black, white)
+				}
+			}
+			continue
+		}
+
+		// rows are padded to a whole byte
+		stride := (bw*bits + 7) / 8
+		for y := b.r.Min.Y; y < b.r.Max.Y && y < h; y++ {
+			r := y - b.r.Min.Y
+			if (r+1)*stride > len(b.data) {
+				return nil, fmt.Errorf("raw: truncated strip: %w", io.ErrUnexpectedEOF)
+			}
+			row := b.data[r*stride : (r+1)*stride]
+			for x := b.r.Min.X; x < b.r.Max.X && x < w; x++ {
+				i := x - b.r.Min.X
+				var v uint32
+				switch bits {
+				case 8:
+					v = uint32(row[i])
+				case 16:
+					v = uint32(bo.Uint16(row[i*2:]))
+				default:
+					v = readBits(row, i*bits, bits)
+				}
+				c.pix[y*w+x] = scale(v, black, white)
+			}
+		}
+	}
+
+	return c, nil
+}
+
+// A block is a strip or tile of the raw data, covering r of the image, which
+// it may extend past.
+type block struct {
+	r    image.Rectangle
+	data []byte
+}
+
+// blocks returns the strips or tiles of the w by h image described by d.
+func blocks(data []byte, d ifd, w, h int) ([]block, error) {
+	offsets, counts := d[tStripOffsets], d[tStripByteCounts]
+	bw, bh := w, min(int(d.get(tRowsPerStrip, uint32(h))), h)
+	if _, ok := d[tTileOffsets]; ok {
+		offsets, counts = d[tTileOffsets], d[tTileByteCounts]
+		bw, bh = int(d.get(tTileWidth, 0)), int(d.get(tTileLength, 0))
+	} else if offsets == nil {
+		return nil, errors.New("raw: no strip or tile offsets")
+	}
+	if bw <= 0 || bh <= 0 {
+		return nil, errors.New("raw: invalid strip or tile size")
+	}
+
+	across, down := (w+bw-1)/bw, (h+bh-1)/bh
+	if len(offsets) < across*down {
+		return nil, fmt.Errorf("raw: missing strips or tiles: %w", io.ErrUnexpectedEOF)
+	}
+	ret := make([]block, across*down)
+	for i := range ret {
+		off, end := uint64(offsets[i]), uint64(len(data))
+		if i < len(counts) {
+			end = off + uint64(counts[i])
+		}
+		if off >= end || end > uint64(len(data)) {
+			return nil, fmt.Errorf("raw: strip or tile out of range: %w", io.ErrUnexpectedEOF)
+		}
+		x, y := i%across*bw, i/across*bh
+		ret[i] = block{image.Rect(x, y, x+bw, y+bh), data[off:end]}
+	}
+	return ret, nil
+}
+
+// readBits reads n bits starting at bit offset off, most significant first.
+func readBits(b []byte, off, n int) uint32 {
+	var v uint32
+	for i := 0; i < n; i++ {
+		bit := off + i
+		v = v<<1 | uint32(b[bit/8]>>(7-uint(bit%8))&1)
+	}
+	return v
+}
+
+func scale(v, black, white uint32) uint16 {
+	if v <= black {
+		return 0
+	}
+	if v >= white {
+		return 0xffff
+	}
+	return uint16(uint64(v-black) * 0xffff / uint64(white-black))
+}
@@ -0,0 +1,327 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package raw
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/bits"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// encodeLJPEG encodes w by h pixels of comps interleaved samples, of the
+// given precision, as a lossless JPEG with the predictor pred and point
+// transform pt, restarting every restart pixels. Every difference category
+// is coded in 5 bits.
+func encodeLJPEG(pix []uint16, w, h, comps, prec, pred int, pt uint, restart int) []byte {
+	b := []byte{0xff, mSOI}
+	segment := func(marker byte, seg ...byte) {
+		b = append(b, 0xff, marker, byte((len(seg)+2)>>8), byte(len(seg)+2))
+		b = append(b, seg...)
+	}
+
+	sof := []byte{byte(prec), byte(h >> 8), byte(h), byte(w >> 8), byte(w), byte(comps)}
+	for c := 0; c < comps; c++ {
+		sof = append(sof, byte(c), 0x11, 0)
+	}
+	segment(mSOF3, sof...)
+	dht := make([]byte, 17, 34)
+	dht[5] = 17
+	for s := 0; s <= 16; s++ {
+		dht = append(dht, byte(s))
+	}
+	segment(mDHT, dht...)
+	if restart > 0 {
+		segment(mDRI, byte(restart>>8), byte(restart))
+	}
+	sos := []byte{byte(comps)}
+	for c := 0; c < comps; c++ {
+		sos = append(sos, byte(c), 0)
+	}
+	segment(mSOS, append(sos, byte(pred), 0, byte(pt))...)
+
+	var acc uint64
+	var n uint
+	put := func(v uint64, l uint) {
+		acc, n = acc<<l|v&(1<<l-1), n+l
+		for n >= 8 {
+			c := byte(acc >> (n - 8))
+			if b = append(b, c); c == 0xff {
+				b = append(b, 0)
+			}
+			n -= 8
+		}
+	}
+	flush := func() {
+		if n > 0 {
+			put(0xff, 8-n)
+		}
+	}
+
+	row := w * comps
+	v := func(i int) int32 { return int32(pix[i] >> pt) }
+	first, left, rst := true, 0, 0
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if restart > 0 && left == restart {
+				flush()
+				b = append(b, 0xff, mRST0+byte(rst%8))
+				left, first = 0, true
+				rst++
+			}
+			left++
+			for c := 0; c < comps; c++ {
+				i := y*row + x*comps + c
+				var p int32
+				switch {
+				case x == 0 && first:
+					p = 1 << (prec - int(pt) - 1)
+				case first:
+					p = v(i - comps)
+				case x == 0:
+					p = v(i - row)
+				default:
+					ra, rb, rc := v(i-comps), v(i-row), v(i-row-comps)
+					p = [8]int32{0, ra, rb, rc, ra + rb - rc, ra + (rb-rc)>>1, rb + (ra-rc)>>1, (ra + rb) >> 1}[pred]
+				}
+
+				// differences are modulo 2^16
+				d := int32(int16(uint16(v(i) - p)))
+				if d == -32768 {
+					d = 32768
+				}
+				s := bits.Len32(uint32(max(d, -d)))
+				put(uint64(s), 5)
+				if s > 0 && s < 16 {
+					if d < 0 {
+						d += 1<<s - 1
+					}
+					put(uint64(d), uint(s))
+				}
+			}
+		}
+		first = false
+	}
+	flush()
+	return append(b, 0xff, mEOI)
+}
+
+// TestLJPEG checks lossless JPEG images decode to the samples encoded.
+func TestLJPEG(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, c := range []struct {
+		w, h, comps, prec int
+		pt                uint
+		restart           int
+	}{
+		{13, 9, 1, 16, 0, 0},
+		{13, 9, 2, 14, 0, 0},
+		{7, 12, 3, 12, 2, 0},
+		{13, 9, 2, 16, 0, 13},
+		{13, 9, 1, 12, 0, 26},
+	} {
+		pix := make([]uint16, c.w*c.h*c.comps)
+		for i := range pix {
+			// a smooth ramp with noise, and some extremes
+			pix[i] = uint16(i*37+r.Intn(200)) & (1<<c.prec - 1) >> c.pt << c.pt
+		}
+		pix[0], pix[len(pix)/2] = 0, (1<<c.prec-1)>>c.pt<<c.pt
+		for pred := 1; pred <= 7; pred++ {
+			data := encodeLJPEG(pix, c.w, c.h, c.comps, c.prec, pred, c.pt, c.restart)
+			j, err := decodeLJPEG(data, len(pix))
+			if err != nil {
+				t.Fatalf("%+v, predictor %v: %v", c, pred, err)
+			}
+			if j.w != c.w || j.h != c.h || j.comps != c.comps || !equalSamples(j.pix, pix) {
+				t.Errorf("%+v, predictor %v: samples don't match", c, pred)
+			}
+
+			if _, err := decodeLJPEG(data[:len(data)*2/3], len(pix)); !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("%+v, predictor %v: truncated: %v, want %v", c, pred, err, io.ErrUnexpectedEOF)
+			}
+		}
+	}
+}
+
+func equalSamples(a, b []uint16) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// writeTIFF returns a little endian TIFF holding a single IFD of tags,
+// followed by blobs, whose offsets and sizes are set as the values of the
+// offsets and counts tags.
+func writeTIFF(tags ifd, blobs [][]byte, offsets, counts uint16) []byte {
+	tags[offsets] = make([]uint32, len(blobs))
+	tags[counts] = make([]uint32, len(blobs))
+	var keys []int
+	extra := 0
+	for k, v := range tags {
+		keys = append(keys, int(k))
+		if len(v) > 1 {
+			extra += 4 * len(v)
+		}
+	}
+	sort.Ints(keys)
+	pos := uint32(8 + 2 + 12*len(keys) + 4 + extra)
+	for i, blob := range blobs {
+		tags[offsets][i], tags[counts][i] = pos, uint32(len(blob))
+		pos += uint32(len(blob))
+	}
+
+	le := binary.LittleEndian
+	b := []byte("II*\x00\x08\x00\x00\x00")
+	b = le.AppendUint16(b, uint16(len(keys)))
+	var vals []byte
+	for _, k := range keys {
+		v := tags[uint16(k)]
+		b = le.AppendUint16(b, uint16(k))
+		b = le.AppendUint16(b, 4)
+		b = le.AppendUint32(b, uint32(len(v)))
+		if len(v) == 1 {
+			b = le.AppendUint32(b, v[0])
+			continue
+		}
+		b = le.AppendUint32(b, uint32(8+2+12*len(keys)+4+len(vals)))
+		for _, x := range v {
+			vals = le.AppendUint32(vals, x)
+		}
+	}
+	b = le.AppendUint32(b, 0)
+	b = append(b, vals...)
+	for _, blob := range blobs {
+		b = append(b, blob...)
+	}
+	return b
+}
+
+// rawTags returns the tags of a w by h color filter array image.
+func rawTags(w, h, bits int) ifd {
+	return ifd{
+		tNewSubfileType: {0},
+		tImageWidth:     {uint32(w)},
+		tImageLength:    {uint32(h)},
+		tBitsPerSample:  {uint32(bits)},
+		tPhotometric:    {photometricCFA},
+		tBlackLevel:     {256},
+		tWhiteLevel:     {1<<bits - 1},
+	}
+}
+
+// TestUnpack checks raw data in uncompressed strips and lossless JPEG tiles,
+// which extend past the image, unpack to the same samples.
+func TestUnpack(t *testing.T) {
+	const w, h, bits = 37, 29, 14
+	r := rand.New(rand.NewSource(1))
+	pix := make([]uint16, w*h)
+	for i := range pix {
+		pix[i] = uint16(r.Intn(1 << bits))
+	}
+	want := make([]uint16, w*h)
+	for i, v := range pix {
+		want[i] = scale(uint32(v), 256, 1<<bits-1)
+	}
+
+	// uncompressed strips of 10 rows
+	var strips [][]byte
+	for y := 0; y < h; y += 10 {
+		var s []byte
+		for _, v := range pix[y*w : min(y+10, h)*w] {
+			s = binary.LittleEndian.AppendUint16(s, v)
+		}
+		strips = append(strips, s)
+	}
+	d := rawTags(w, h, 16)
+	d[tWhiteLevel] = []uint32{1<<bits - 1}
+	d[tRowsPerStrip] = []uint32{10}
+	stripped := writeTIFF(d, strips, tStripOffsets, tStripByteCounts)
+
+	// 16 by 16 tiles, encoded as two interleaved components, as DNG
+	// files do, with random samples past the image
+	var tiles [][]byte
+	for ty := 0; ty < h; ty += 16 {
+		for tx := 0; tx < w; tx += 16 {
+			tile := make([]uint16, 16*16)
+			for i := range tile {
+				x, y := tx+i%16, ty+i/16
+				if x < w && y < h {
+					tile[i] = pix[y*w+x]
+				} else {
+					tile[i] = uint16(r.Intn(1 << bits))
+				}
+			}
+			tiles = append(tiles, encodeLJPEG(tile, 8, 16, 2, bits, 1, 0, 0))
+		}
+	}
+	d = rawTags(w, h, bits)
+	d[tCompression] = []uint32{compressionLJPEG}
+	d[tTileWidth], d[tTileLength] = []uint32{16}, []uint32{16}
+	tiled := writeTIFF(d, tiles, tTileOffsets, tTileByteCounts)
+
+	for name, data := range map[string][]byte{"strips": stripped, "tiles": tiled} {
+		d, _, err := parseIFD(data, binary.LittleEndian, binary.LittleEndian.Uint32(data[4:]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := unpack(data, binary.LittleEndian, d)
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		if c.w != w || c.h != h || !equalSamples(c.pix, want) {
+			t.Errorf("%v: samples don't match", name)
+		}
+
+		if _, err := Decode(bytes.NewReader(data)); err != nil {
+			t.Errorf("%v: %v", name, err)
+		}
+		if _, err := Decode(bytes.NewReader(data[:len(data)*3/4])); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("%v: truncated: %v, want %v", name, err, io.ErrUnexpectedEOF)
+		}
+	}
+}
+
+// TestTruncated checks files too short for their image are refused, before
+// their image is allocated, rather than zero filled.
+func TestTruncated(t *testing.T) {
+	d := rawTags(1<<20, 1<<20, 16)
+	huge := writeTIFF(d, [][]byte{make([]byte, 64)}, tStripOffsets, tStripByteCounts)
+	if _, err := Decode(bytes.NewReader(huge)); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("huge raw image: %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+
+	// an RGBI image of 4 rows, missing the last strip
+	rgbi := ifd{
+		tImageWidth:      {8},
+		tImageLength:     {4},
+		tBitsPerSample:   {8, 8, 8, 8},
+		tSamplesPerPixel: {4},
+		tPhotometric:     {photometricRGB},
+		tRowsPerStrip:    {2},
+	}
+	data := writeTIFF(rgbi, [][]byte{make([]byte, 2*8*4), make([]byte, 2*8*4)}, tStripOffsets, tStripByteCounts)
+	if _, _, err := DecodeRGBI(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	data = writeTIFF(rgbi, [][]byte{make([]byte, 2*8*4)}, tStripOffsets, tStripByteCounts)
+	if _, _, err := DecodeRGBI(bytes.NewReader(data)); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("missing strip: %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	data = writeTIFF(rgbi, [][]byte{make([]byte, 2*8*4), make([]byte, 8*4)}, tStripOffsets, tStripByteCounts)
+	if _, _, err := DecodeRGBI(bytes.NewReader(data)); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("short strip: %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package raw
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+)
+
+// ErrNoInfrared is returned by DecodeRGBI for TIFF files without an infrared
+// channel.
+var ErrNoInfrared = errors.New("raw: no infrared channel")
+
+// DecodeRGBI reads an uncompressed, 8 or 16-bit RGBI TIFF, as written by
+// scanner software such as VueScan and SilverFast, returning the color image
+// and its infrared channel separately.
+func DecodeRGBI(r io.Reader) (*image.RGBA64, *image.Gray16, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	bo, err := byteOrder(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	d, _, err := parseIFD(data, bo, bo.Uint32(data[4:]))
+	if err != nil {
+		return nil, nil, err
+	}
+	if d.get(tPhotometric, 0) != photometricRGB || d.get(tSamplesPerPixel, 1) != 4 {
+		return nil, nil, ErrNoInfrared
+	}
+	if e := d.get(tExtraSamples, 0); e == 1 || e == 2 {
+		// the fourth sample is alpha, not infrared
+		return nil, nil, ErrNoInfrared
+	}
+	if c := d.get(tCompression, compressionNone); c != compressionNone {
+		return nil, nil, fmt.Errorf("%w: compression %v", ErrUnsupported, c)
+	}
+	if d.get(tPlanarConfig, 1) != 1 {
+		return nil, nil, fmt.Errorf("%w: planar samples", ErrUnsupported)
+	}
+	if _, ok := d[tStripOffsets]; !ok {
+		return nil, nil, fmt.Errorf("%w: tiled image", ErrUnsupported)
+	}
+
+	w := int(d.get(tImageWidth, 0))
+	h := int(d.get(tImageLength, 0))
+	bits := int(d.get(tBitsPerSample, 8))
+	if w <= 0 || h <= 0 || (bits != 8 && bits != 16) {
+		return nil, nil, fmt.Errorf("%w: %v bits per sample", ErrUnsupported, bits)
+	}
+	stride := w * 4 * bits / 8
+	if uint64(stride)*uint64(h) > uint64(len(data)) {
+		return nil, nil, fmt.Errorf("raw: %vx%v image larger than the file: %w", w, h, io.ErrUnexpectedEOF)
+	}
+	bs, err := blocks(data, d, w, h)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m := image.NewRGBA64(image.Rect(0, 0, w, h))
+	ir := image.NewGray16(m.Rect)
+	sample := func(row []byte, i int) uint16 {
+		if bits == 8 {
+			return uint16(row[i]) * 0x101
+		}
+		return bo.Uint16(row[i*2:])
+	}
+
+	for _, b := range bs {
+		for y := b.r.Min.Y; y < b.r.Max.Y && y < h; y++ {
+			r := y - b.r.Min.Y
+			if (r+1)*stride > len(b.data) {
+				return nil, nil, fmt.Errorf("raw: truncated strip: %w", io.ErrUnexpectedEOF)
+			}
+			row := b.data[r*stride : (r+1)*stride]
+			for x := 0; x < w; x++ {
+				p := m.PixOffset(x, y)
+				for c := 0; c < 3; c++ {
+					v := sample(row, x*4+c)
+					m.Pix[p+c*2] = uint8(v >> 8)
+					m.Pix[p+c*2+1] = uint8(v)
+				}
+				m.Pix[p+6] = 0xff
+				m.Pix[p+7] = 0xff
+
+				v := sample(row, x*4+3)
+				q := ir.PixOffset(x, y)
+				ir.Pix[q] = uint8(v >> 8)
+				ir.Pix[q+1] = uint8(v)
+			}
+		}
+	}
+
+	return m, ir, nil
+}
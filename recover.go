@@ -0,0 +1,68 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package positive
+
+import "math"
+
+const (
+	// middle of the tonal range of the gamma encoded positive, which
+	// recovery leaves in place
+	recoveryPivot = 0.5
+
+	// strength of recovery at full amount, below 3 so the curve keeps
+	// increasing
+	recoveryStrength = 2
+)
+
+// recoveryFunc returns the stage recovering highlight and shadow detail by
+// amounts from 0 to 1, see Options.Highlights, or nil if both are 0.
+// Lightness, the luminance of the gamma encoded positive, is mapped through
+// recoveryCurve, and the channels are scaled by the same factor, so hues are
+// kept. Linear positives, in density mode, are gamma encoded for it, as
+// lightness is closer to perceptually even than linear light.
+func recoveryFunc(highlights, shadows float64, linear bool) pixelFunc {
+	if highlights == 0 && shadows == 0 {
+		return nil
+	}
+
+	gain := newCurve(curveSteps, func(y float64) float64 {
+		if y <= 0 || y >= 1 {
+			return 1
+		}
+		l := y
+		if linear {
+			l = math.Pow(y, 1/displayGamma)
+		}
+		k := recoveryCurve(l, highlights, shadows) / l
+		if linear {
+			k = math.Pow(k, displayGamma)
+		}
+		return k
+	})
+
+	return func(r, g, b float32) (float32, float32, float32) {
+		k := gain.at(0.2126*r + 0.7152*g + 0.0722*b)
+		return r * k, g * k, b * k
+	}
+}
+
+// recoveryCurve maps lightness l, compressing the tones between the pivot
+// and white by highlights, and between the pivot and black by shadows,
+// most strongly two thirds of the way from the pivot. Black, white, and the
+// pivot stay in place, and the curve is steepest at the ends, spreading out
+// the detail crowded there.
+func recoveryCurve(l, highlights, shadows float64) float64 {
+	// u is the distance from the pivot towards white or black, from 0 to 1
+	compress := func(u, amount float64) float64 {
+		return u - recoveryStrength*amount*u*u*(1-u)
+	}
+	if l > recoveryPivot {
+		u := (l - recoveryPivot) / (1 - recoveryPivot)
+		return recoveryPivot + (1-recoveryPivot)*compress(u, highlights)
+	}
+	u := (recoveryPivot - l) / recoveryPivot
+	return recoveryPivot - recoveryPivot*compress(u, shadows)
+}
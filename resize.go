@@ -0,0 +1,126 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package positive
+
+import (
+	"context"
+	"image"
+	"math"
+)
+
+// lanczos is the number of lobes of the Lanczos resampling filter
+const lanczos = 3
+
+// Resize resamples m to w by h pixels with a Lanczos filter, which keeps
+// detail sharp without aliasing when downsizing. Rows are processed
+// concurrently by up to threads goroutines.
+func Resize(m image.Image, w, h, threads int) *image.RGBA64 {
+	ret, _ := ResizeContext(context.Background(), m, w, h, threads)
+	return ret
+}
+
+// ResizeContext is Resize, returning ctx.Err() if ctx is done first.
+func ResizeContext(ctx context.Context, m image.Image, w, h, threads int) (*image.RGBA64, error) {
+	src, err := mapPixelsContext(ctx, m, identity, threads)
+	if err != nil {
+		return nil, err
+	}
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+
+	// horizontally into a w by sh image, then vertically
+	tmp := image.NewRGBA64(image.Rect(0, 0, w, sh))
+	xw := resampleWeights(sw, w)
+	err = stripesContext(ctx, tmp.Rect, threads, func(stripe image.Rectangle) {
+		for y := stripe.Min.Y; y < stripe.Max.Y; y++ {
+			for x := 0; x < w; x++ {
+				resample(tmp.Pix[y*tmp.Stride+x*8:], src.Pix[y*src.Stride:], 8, xw[x])
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ret := image.NewRGBA64(image.Rect(0, 0, w, h))
+	yw := resampleWeights(sh, h)
+	err = stripesContext(ctx, ret.Rect, threads, func(stripe image.Rectangle) {
+		for y := stripe.Min.Y; y < stripe.Max.Y; y++ {
+			for x := 0; x < w; x++ {
+				resample(ret.Pix[y*ret.Stride+x*8:], tmp.Pix[x*8:], tmp.Stride, yw[y])
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// weights are the filter taps of one output pixel, starting at source pixel
+// first.
+type weights struct {
+	first int
+	w     []float64
+}
+
+// resampleWeights returns the taps of each of n output pixels resampled from
+// size source pixels.
+func resampleWeights(size, n int) []weights {
+	scale := float64(size) / float64(n)
+
+	// widen the filter when downsizing, so every source pixel contributes
+	support := float64(lanczos)
+	if scale > 1 {
+		support *= scale
+	}
+
+	ret := make([]weights, n)
+	for i := range ret {
+		center := (float64(i)+0.5)*scale - 0.5
+		first := int(math.Ceil(center - support))
+		last := int(math.Floor(center + support))
+		if first < 0 {
+			first = 0
+		}
+		if last > size-1 {
+			last = size - 1
+		}
+
+		var sum float64
+		w := make([]float64, last-first+1)
+		for j := range w {
+			d := (float64(first+j) - center) / (support / lanczos)
+			w[j] = sinc(d) * sinc(d/lanczos)
+			sum += w[j]
+		}
+		for j := range w {
+			w[j] /= sum
+		}
+		ret[i] = weights{first, w}
+	}
+	return ret
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	x *= math.Pi
+	return math.Sin(x) / x
+}
+
+// resample writes to d the pixel filtered from the pixels of s, which are
+// step bytes apart, with the taps w.
+func resample(d, s []uint8, step int, w weights) {
+	for c := 0; c < 6; c += 2 {
+		var v float64
+		for j, k := range w.w {
+			v += float64(get16(s[(w.first+j)*step+c:])) * k
+		}
+		put16(d[c:], clip(v))
+	}
+	put16(d[6:], 0xffff)
+}
@@ -0,0 +1,130 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package positive
+
+import (
+	"context"
+	"image"
+	"image/color"
+)
+
+// Reverse converts the positive m to a negative, such as a digital
+// internegative for darkroom printing or to test conversions with, undoing
+// the stages of Process in reverse order. The positive is inverted unless
+// o.Slide is set, scaled into o.Levels if o.Normalize is set and they are
+// given, such as levels saved from converting a real negative of the stock,
+// passed through the characteristic curve of o.Gamma or o.Curves, and tinted
+// with the film mask o.Base, if not nil, by multiplying by it as the mask
+// filters light, which DivideCast undoes exactly. Other options, such as
+// adjustments to the positive, are ignored. Grayscale images return an
+// *image.Gray16, as with Process.
+func Reverse(m image.Image, o Options) (image.Image, error) {
+	return ReverseContext(context.Background(), m, o)
+}
+
+// ReverseContext is Reverse, returning ctx.Err() if ctx is done first.
+func ReverseContext(ctx context.Context, m image.Image, o Options) (image.Image, error) {
+	if err := o.validate(m.Bounds()); err != nil {
+		return nil, err
+	}
+	if o.Density {
+		return nil, errorf(ErrInvalidOptions, "density mode can't be reversed")
+	}
+
+	gray := isGray(m)
+	if gray {
+		o.BW = true
+	}
+	ctx, mt := withMeter(ctx, o.OnProgress, m.Bounds().Dy())
+	mt.stage("reverse")
+
+	var luma pixelFunc
+	if o.BW {
+		luma = lumaFunc
+	}
+
+	var inv pixelFunc
+	if o.Invert && !o.Slide {
+		inv = invertFunc
+	}
+
+	var unstretch pixelFunc
+	if o.Normalize && o.Levels != nil {
+		unstretch = o.Levels.unstretch
+	}
+
+	g := o.gamma()
+	curve := gammaFunc(g.R, g.G, g.B)
+	if o.Curves != nil {
+		c := curvesFunc(o.Curves)
+		curve = curveFunc(inverse(c, 0), inverse(c, 1), inverse(c, 2))
+	}
+
+	var mask pixelFunc
+	if o.Base != nil && !o.Slide {
+		mask = multiplyFunc(o.Base)
+	}
+
+	f := compose(luma, inv, unstretch, curve, mask)
+	if gray {
+		p, err := mapGray(ctx, m, f, o.Threads)
+		if err != nil {
+			return nil, err
+		}
+		mt.finish()
+		return p, nil
+	}
+	p, err := mapPixelsContext(ctx, m, f, o.Threads)
+	if err != nil {
+		return nil, err
+	}
+	mt.finish()
+	return p, nil
+}
+
+// unstretch scales pixels from the full range into the levels, undoing
+// normalization.
+func (l Levels) unstretch(r, g, b float32) (float32, float32, float32) {
+	s := func(v float32, min, max uint32) float32 {
+		return (float32(min) + v*float32(max-min)) / 0xffff
+	}
+	return s(r, l.RMin, l.RMax), s(g, l.GMin, l.GMax), s(b, l.BMin, l.BMax)
+}
+
+// inverse returns the inverse of channel c (0 for r, 1 for g, 2 for b) of the
+// monotonically increasing per channel pixelFunc f over [0,1]: for each value,
+// the input f maps to it, found by bisection. Values f doesn't reach map to
+// the nearest end of the range.
+func inverse(f pixelFunc, c int) *curve {
+	return newCurve(curveSteps, func(v float64) float64 {
+		lo, hi := float32(0), float32(1)
+		for i := 0; i < 24; i++ {
+			mid := (lo + hi) / 2
+			if float64(channel(f, mid, c)) < v {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+		return float64(lo+hi) / 2
+	})
+}
+
+// channel returns channel c of f applied to a gray pixel of value v.
+func channel(f pixelFunc, v float32, c int) float32 {
+	r, g, b := f(v, v, v)
+	return [3]float32{r, g, b}[c]
+}
+
+// multiplyFunc tints a negative with the film mask s, as DivideCast removes
+// it.
+func multiplyFunc(s color.Color) pixelFunc {
+	r, g, b, _ := s.RGBA()
+	fr, fg, fb := unit(r), unit(g), unit(b)
+	return func(dr, dg, db float32) (float32, float32, float32) {
+		return dr * fr, dg * fg, db * fb
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package positive
+
+import (
+	"image"
+	"image/color"
+	"sync"
+)
+
+// SampleTrim is the fraction of the darkest and brightest values discarded
+// from each channel when averaging a sample, so dust, scratches, or a sliver
+// of frame edge in the sample don't skew the film mask color.
+const SampleTrim = 0.1
+
+// Sample calculates the trimmed mean r,g,b colors of the given image,
+// typically a crop of unexposed film used as the film mask color.
+func Sample(m image.Image) color.Color {
+	return SampleRect(m, m.Bounds())
+}
+
+// SampleRect calculates the trimmed mean r,g,b colors of m within rect, such
+// as the sprocket area of a scan that includes the film border. See
+// SampleTrim.
+func SampleRect(m image.Image, rect image.Rectangle) color.Color {
+	rect = rect.Intersect(m.Bounds())
+	if rect.Empty() {
+		return color.RGBA64{A: 0xffff}
+	}
+
+	// per channel histograms, so outliers can be discarded by rank
+	var hr, hg, hb [65536]uint64
+
+	var mu sync.Mutex
+	stripes(rect, 0, func(stripe image.Rectangle) {
+		var sr, sg, sb [65536]uint32
+		scanPixels(m, stripe, func(dr, dg, db uint32) {
+			sr[dr]++
+			sg[dg]++
+			sb[db]++
+		})
+
+		mu.Lock()
+		for i := range sr {
+			hr[i] += uint64(sr[i])
+			hg[i] += uint64(sg[i])
+			hb[i] += uint64(sb[i])
+		}
+		mu.Unlock()
+	})
+
+	size := uint64(rect.Dx()) * uint64(rect.Dy())
+	return color.RGBA64{
+		R: trimmedMean(&hr, size),
+		G: trimmedMean(&hg, size),
+		B: trimmedMean(&hb, size),
+		A: 0xffff,
+	}
+}
+
+// trimmedMean returns the mean of the values in histogram h, which holds size
+// values, ignoring the lowest and highest SampleTrim fraction of them.
+func trimmedMean(h *[65536]uint64, size uint64) uint16 {
+	skip := uint64(float64(size) * SampleTrim)
+	keep := size - 2*skip
+
+	var sum, n uint64
+	for v, c := range h {
+		// drop counts still within the low trim
+		if skip > 0 {
+			if c <= skip {
+				skip -= c
+				continue
+			}
+			c -= skip
+			skip = 0
+		}
+		if c > keep-n {
+			c = keep - n
+		}
+		sum += uint64(v) * c
+		n += c
+		if n == keep {
+			break
+		}
+	}
+	return uint16(sum / n)
+}
+
+// RemoveCast removes (in negative color space, so adds the inverted sample)
+// the color cast determined by the provided mask sample.
+func RemoveCast(m image.Image, s color.Color) image.Image {
+	return mapPixels(m, castFunc(s, 0), 0)
+}
+
+// castFunc returns the RemoveCast stage, rolling off highlights within knee
+// of white instead of clipping them if knee > 0.
+func castFunc(s color.Color, knee float64) pixelFunc {
+	sr, sg, sb, _ := s.RGBA()
+
+	// the inverted sample, as a fraction of white
+	r, g, b := 1-unit(sr), 1-unit(sg), 1-unit(sb)
+
+	if knee > 0 {
+		add := func(o float32) *curve {
+			return newCurve(curveSteps, func(v float64) float64 {
+				return soft(v+float64(o), knee)
+			})
+		}
+		return curveFunc(add(r), add(g), add(b))
+	}
+
+	// adding the inverted mask compresses highlights into clipping
+	return func(dr, dg, db float32) (float32, float32, float32) {
+		return min(dr+r, 1), min(dg+g, 1), min(db+b, 1)
+	}
+}
+
+// DivideCast removes the color cast determined by the provided mask sample by
+// dividing each channel by the mask color. This is equivalent to subtracting
+// the mask density, which models the mask as a filter over the whole image
+// and, unlike RemoveCast, doesn't compress highlights into clipping.
+func DivideCast(m image.Image, s color.Color) image.Image {
+	return mapPixels(m, divideFunc(s), 0)
+}
+
+func divideFunc(s color.Color) pixelFunc {
+	r, g, b, _ := s.RGBA()
+
+	div := func(v float32, m uint32) float32 {
+		// the mask itself maps to white, so a zero channel can't be divided
+		// out
+		if m == 0 {
+			return 1
+		}
+		return v * 0xffff / float32(m)
+	}
+
+	return func(dr, dg, db float32) (float32, float32, float32) {
+		return div(dr, r), div(dg, g), div(db, b)
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package positive
+
+import (
+	"context"
+	"image"
+	"math"
+)
+
+// DefaultSharpenRadius is the blur radius Sharpen uses if its radius, or
+// Options.SharpenRadius, is 0.
+const DefaultSharpenRadius = 1.0
+
+// Sharpen applies an unsharp mask to the luminance of m, adding amount times
+// the difference between each pixel and a gaussian blur of the given radius
+// (standard deviation) in pixels, or DefaultSharpenRadius if 0, so color
+// edges aren't fringed. Differences
+// smaller than threshold, as a fraction of white, are left alone so grain and
+// smooth areas aren't sharpened. Rows are processed concurrently by up to
+// threads goroutines.
+func Sharpen(m *image.RGBA64, amount, radius, threshold float64, threads int) *image.RGBA64 {
+	ret, _ := SharpenContext(context.Background(), m, amount, radius, threshold, threads)
+	return ret
+}
+
+// SharpenContext is Sharpen, returning ctx.Err() if ctx is done first.
+func SharpenContext(ctx context.Context, m *image.RGBA64, amount, radius, threshold float64, threads int) (*image.RGBA64, error) {
+	p, err := mapFloat(ctx, m, identity, threads)
+	if err != nil {
+		return nil, err
+	}
+	if err := sharpen(ctx, p, amount, radius, threshold, threads); err != nil {
+		return nil, err
+	}
+	return mapPixelsContext(ctx, p, identity, threads)
+}
+
+// sharpen is Sharpen on the floating point image p, in place.
+func sharpen(ctx context.Context, p *floatImage, amount, radius, threshold float64, threads int) error {
+	w, h := p.w, p.h
+	if radius <= 0 {
+		radius = DefaultSharpenRadius
+	}
+
+	y := make([]float32, w*h)
+	stripesContext(ctx, p.Bounds(), threads, func(stripe image.Rectangle) {
+		for i := stripe.Min.Y * w; i < stripe.Max.Y*w; i++ {
+			y[i] = luminance(p.pix[i*3], p.pix[i*3+1], p.pix[i*3+2])
+		}
+	})
+	blur := gaussianBlur(ctx, y, w, h, radius, threads)
+
+	stripesContext(ctx, p.Bounds(), threads, func(stripe image.Rectangle) {
+		for i := stripe.Min.Y * w; i < stripe.Max.Y*w; i++ {
+			detail := y[i] - blur[i]
+			if math.Abs(float64(detail)) < threshold {
+				detail = 0
+			}
+			delta := float32(amount) * detail
+			p.pix[i*3] += delta
+			p.pix[i*3+1] += delta
+			p.pix[i*3+2] += delta
+		}
+	})
+	return ctx.Err()
+}
+
+// gaussianBlur blurs the w by h plane v with a gaussian of standard
+// deviation sigma, as separate horizontal and vertical passes. The result is
+// incomplete if ctx is done first.
+func gaussianBlur(ctx context.Context, v []float32, w, h int, sigma float64, threads int) []float32 {
+	radius := int(math.Ceil(sigma * 3))
+	kernel := make([]float32, 2*radius+1)
+	for i := range kernel {
+		d := float64(i - radius)
+		kernel[i] = float32(math.Exp(-d * d / (2 * sigma * sigma)))
+	}
+
+	// pass blurs src into dst along one axis, renormalizing the kernel at
+	// the edges of the image
+	pass := func(src, dst []float32, horizontal bool) {
+		stripesContext(ctx, image.Rect(0, 0, w, h), threads, func(stripe image.Rectangle) {
+			for y := stripe.Min.Y; y < stripe.Max.Y; y++ {
+				for x := 0; x < w; x++ {
+					var sum, weight float32
+					for k, kv := range kernel {
+						nx, ny := x, y
+						if horizontal {
+							nx += k - radius
+						} else {
+							ny += k - radius
+						}
+						if nx < 0 || nx >= w || ny < 0 || ny >= h {
+							continue
+						}
+						sum += src[ny*w+nx] * kv
+						weight += kv
+					}
+					dst[y*w+x] = sum / weight
+				}
+			}
+		})
+	}
+
+	tmp := make([]float32, len(v))
+	ret := make([]float32, len(v))
+	pass(v, tmp, true)
+	pass(tmp, ret, false)
+	return ret
+}
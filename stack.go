@@ -0,0 +1,80 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package positive
+
+import (
+	"context"
+	"image"
+)
+
+// Stacking is a method of combining several scans of the same frame, see
+// Stack.
+type Stacking string
+
+const (
+	// StackMean averages the scans, which reduces noise the most.
+	StackMean Stacking = "mean"
+
+	// StackMedian takes the median of the scans, which also rejects
+	// differences in only one of them, such as a hair that moved between
+	// scans, but needs at least three scans to differ from the mean.
+	StackMedian Stacking = "median"
+)
+
+// Stack combines ms, several scans of the same frame taken without moving
+// the film, into one image with less scanner noise, which is most visible in
+// the dense regions of a negative. Rows are processed concurrently by up to
+// threads goroutines.
+func Stack(ms []image.Image, s Stacking, threads int) (*image.RGBA64, error) {
+	return StackContext(context.Background(), ms, s, threads)
+}
+
+// StackContext is Stack, returning ctx.Err() if ctx is done first.
+func StackContext(ctx context.Context, ms []image.Image, s Stacking, threads int) (*image.RGBA64, error) {
+	if len(ms) == 0 {
+		return nil, errorf(ErrInvalidOptions, "no scans to stack")
+	}
+	if s != StackMean && s != StackMedian {
+		return nil, errorf(ErrInvalidOptions, "unknown stacking %q", s)
+	}
+
+	scans := make([]*image.RGBA64, len(ms))
+	for i, m := range ms {
+		if m.Bounds().Size() != ms[0].Bounds().Size() {
+			return nil, errorf(ErrImageMismatch, "scans to stack must be the same size")
+		}
+		var err error
+		if scans[i], err = mapPixelsContext(ctx, m, identity, threads); err != nil {
+			return nil, err
+		}
+	}
+
+	ret := image.NewRGBA64(scans[0].Rect)
+	err := stripesContext(ctx, ret.Rect, threads, func(stripe image.Rectangle) {
+		v := make([]uint16, len(scans))
+		for y := stripe.Min.Y; y < stripe.Max.Y; y++ {
+			for i := y * ret.Stride; i < (y+1)*ret.Stride; i += 8 {
+				for c := 0; c < 6; c += 2 {
+					var sum uint32
+					for j, m := range scans {
+						v[j] = uint16(get16(m.Pix[i+c:]))
+						sum += uint32(v[j])
+					}
+					if s == StackMedian {
+						put16(ret.Pix[i+c:], uint32(median(v)))
+					} else {
+						put16(ret.Pix[i+c:], (sum+uint32(len(v))/2)/uint32(len(v)))
+					}
+				}
+				put16(ret.Pix[i+6:], 0xffff)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
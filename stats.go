@@ -0,0 +1,144 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package positive
+
+import (
+	"context"
+	"image"
+	"sync"
+)
+
+// Stats are what converting an image found out about it, for callers to
+// report or check.
+type Stats struct {
+	// Levels are the per channel black and white points normalized to,
+	// whether found or given, or nil if the image wasn't normalized.
+	Levels *Levels `json:"levels,omitempty"`
+
+	// Base is the film mask color removed, in r,g,b order, whether
+	// sampled or estimated, or nil if none was.
+	Base *[3]uint16 `json:"base,omitempty"`
+
+	// Clip counts the pixels of the result clipped to black or white.
+	Clip Clip `json:"clip"`
+}
+
+// ProcessStats is ProcessContext, also returning the statistics of the
+// conversion. Counting the clipped pixels takes another pass over the
+// result.
+func ProcessStats(ctx context.Context, m image.Image, o Options) (image.Image, Stats, error) {
+	p, levels, err := process(ctx, m, o)
+	if err != nil {
+		return nil, Stats{}, err
+	}
+
+	s := Stats{Levels: levels, Clip: Clipping(p)}
+	if o.Base != nil {
+		r, g, b, _ := o.Base.RGBA()
+		s.Base = &[3]uint16{uint16(r), uint16(g), uint16(b)}
+	}
+	return p, s, nil
+}
+
+// NormalizeStats is Normalize, also returning the levels found and the
+// pixels clipped.
+func NormalizeStats(m image.Image, border, tUpper, tLower int) (image.Image, Stats) {
+	interior := Margins{border, border, border, border}.interior(m.Bounds())
+	l := findLevels(context.Background(), m, nil, interior, nil, tUpper, tLower, 0)
+	p := mapPixels(m, l.apply, 0)
+	return p, Stats{Levels: &l, Clip: Clipping(p)}
+}
+
+// Clip counts the pixels of an image clipped to pure black or white in each
+// channel, in r,g,b order.
+type Clip struct {
+	Pixels int    `json:"pixels"`
+	Black  [3]int `json:"black"`
+	White  [3]int `json:"white"`
+}
+
+// Clipping counts the clipped pixels of m, typically a converted positive, to
+// tell when normalization thresholds are discarding real image detail.
+func Clipping(m image.Image) Clip {
+	c := Clip{Pixels: m.Bounds().Dx() * m.Bounds().Dy()}
+
+	var mu sync.Mutex
+	stripes(m.Bounds(), 0, func(stripe image.Rectangle) {
+		var s Clip
+		scanPixels(m, stripe, func(r, g, b uint32) {
+			for i, v := range [3]uint32{r, g, b} {
+				switch v {
+				case 0:
+					s.Black[i]++
+				case 0xffff:
+					s.White[i]++
+				}
+			}
+		})
+
+		mu.Lock()
+		for i := range s.Black {
+			c.Black[i] += s.Black[i]
+			c.White[i] += s.White[i]
+		}
+		mu.Unlock()
+	})
+	return c
+}
+
+// BlackPercent returns the percentage of pixels clipped to black in each
+// channel.
+func (c Clip) BlackPercent() [3]float64 {
+	return c.percent(c.Black)
+}
+
+// WhitePercent returns the percentage of pixels clipped to white in each
+// channel.
+func (c Clip) WhitePercent() [3]float64 {
+	return c.percent(c.White)
+}
+
+func (c Clip) percent(n [3]int) [3]float64 {
+	var p [3]float64
+	if c.Pixels == 0 {
+		return p
+	}
+	for i := range n {
+		p[i] = 100 * float64(n[i]) / float64(c.Pixels)
+	}
+	return p
+}
+
+// Histogram returns the number of pixels of m in each of n equal width bins of
+// each channel, in r,g,b order.
+func Histogram(m image.Image, n int) [3][]int {
+	var h [3][]int
+	for i := range h {
+		h[i] = make([]int, n)
+	}
+
+	var mu sync.Mutex
+	stripes(m.Bounds(), 0, func(stripe image.Rectangle) {
+		var s [3][]int
+		for i := range s {
+			s[i] = make([]int, n)
+		}
+		scanPixels(m, stripe, func(r, g, b uint32) {
+			s[0][int(r)*n/0x10000]++
+			s[1][int(g)*n/0x10000]++
+			s[2][int(b)*n/0x10000]++
+		})
+
+		mu.Lock()
+		for i := range s {
+			for j, v := range s[i] {
+				h[i][j] += v
+			}
+		}
+		mu.Unlock()
+	})
+	return h
+}
@@ -0,0 +1,271 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package tiffmeta
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+// Structural tag IDs written by Encode.
+const (
+	imageWidth       = 256
+	imageLength      = 257
+	bitsPerSample    = 258
+	compression      = 259
+	photometric      = 262
+	stripOffsets     = 273
+	samplesPerPixel  = 277
+	rowsPerStrip     = 278
+	stripByteCounts  = 279
+	planarConfig     = 284
+	extraSamples     = 338
+	photometricGray  = 1
+	photometricRGB   = 2
+	compressionNone  = 1
+	headerSize       = 8
+	maxUncompressed  = 1<<32 - 1
+	planarContiguous = 1
+	alphaUnassoc     = 2
+)
+
+func short(id uint16, v ...uint16) Tag {
+	b := make([]byte, 2*len(v))
+	for i, s := range v {
+		binary.LittleEndian.PutUint16(b[i*2:], s)
+	}
+	return Tag{ID: id, Type: TypeShort, Count: uint32(len(v)), Value: b}
+}
+
+func long(id uint16, v uint32) Tag {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return Tag{ID: id, Type: TypeLong, Count: 1, Value: b}
+}
+
+// Encode writes m to w as an uncompressed 16-bit TIFF, with tags added to the
+// image's IFD. *image.Gray16 images are written as grayscale,
+// *image.NRGBA64 images as RGB with an unassociated alpha channel, and
+// everything else as RGB. The 8-bit *image.Gray, *image.NRGBA, and
+// *image.RGBA are written the same way as 8-bit TIFFs.
+func Encode(w io.Writer, m image.Image, tags []Tag) error {
+	spp, bits := 3, 16
+	switch m.(type) {
+	case *image.Gray16:
+		spp = 1
+	case *image.NRGBA64:
+		spp = 4
+	case *image.Gray:
+		spp, bits = 1, 8
+	case *image.NRGBA:
+		spp, bits = 4, 8
+	case *image.RGBA:
+		bits = 8
+	}
+	tw, err := newWriter(w, m.Bounds().Dx(), m.Bounds().Dy(), spp, bits, tags)
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteRows(m); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// A Writer writes an uncompressed TIFF a few rows at a time, such as
+// when converting an image too large to hold in memory.
+type Writer struct {
+	bw   *bufio.Writer
+	w, h int
+	spp  int
+	bits int
+	size uint64
+	ifd  uint64
+	all  []Tag
+	row  []byte
+	y    int
+}
+
+// NewWriter starts writing a w by h TIFF to wr, in grayscale if gray is
+// true, with tags added to the image's IFD like Encode. The rows are written
+// with WriteRows, and the IFD by Close.
+func NewWriter(wr io.Writer, w, h int, gray bool, tags []Tag) (*Writer, error) {
+	if gray {
+		return newWriter(wr, w, h, 1, 16, tags)
+	}
+	return newWriter(wr, w, h, 3, 16, tags)
+}
+
+// newWriter is NewWriter for spp samples per pixel, 1 for grayscale, 3 for
+// RGB, or 4 for RGB and alpha, of 8 or 16 bits.
+func newWriter(wr io.Writer, w, h, spp, bits int, tags []Tag) (*Writer, error) {
+	photo := uint16(photometricRGB)
+	if spp == 1 {
+		photo = photometricGray
+	}
+	bps := make([]uint16, spp)
+	for i := range bps {
+		bps[i] = uint16(bits)
+	}
+
+	size := uint64(w) * uint64(h) * uint64(spp) * uint64(bits/8)
+	if size > maxUncompressed {
+		return nil, errors.New("tiffmeta: image too large")
+	}
+
+	// the image data directly follows the header, and the IFD follows the
+	// image data
+	all := make([]Tag, 0, len(tags)+10)
+	for _, t := range tags {
+		switch t.ID {
+		case imageWidth, imageLength, bitsPerSample, compression, photometric,
+			stripOffsets, samplesPerPixel, rowsPerStrip, stripByteCounts, planarConfig,
+			extraSamples:
+			continue
+		}
+		all = append(all, t)
+	}
+	all = append(all,
+		long(imageWidth, uint32(w)),
+		long(imageLength, uint32(h)),
+		short(bitsPerSample, bps...),
+		short(compression, compressionNone),
+		short(photometric, photo),
+		long(stripOffsets, headerSize),
+		short(samplesPerPixel, uint16(spp)),
+		long(rowsPerStrip, uint32(h)),
+		long(stripByteCounts, uint32(size)),
+		short(planarConfig, planarContiguous))
+	if spp == 4 {
+		all = append(all, short(extraSamples, alphaUnassoc))
+	}
+	sortTags(all)
+
+	ifd := headerSize + size
+	if ifd%2 != 0 {
+		ifd++
+	}
+	if ifd > maxUncompressed {
+		return nil, errors.New("tiffmeta: image too large")
+	}
+
+	tw := &Writer{
+		bw:   bufio.NewWriter(wr),
+		w:    w,
+		h:    h,
+		spp:  spp,
+		bits: bits,
+		size: size,
+		ifd:  ifd,
+		all:  all,
+		row:  make([]byte, w*spp*bits/8),
+	}
+
+	var hdr [headerSize]byte
+	copy(hdr[:], "II*\x00")
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(ifd))
+	_, err := tw.bw.Write(hdr[:])
+	return tw, err
+}
+
+// WriteRows writes the rows of m as the next rows of the image. m must be as
+// wide as the image.
+func (tw *Writer) WriteRows(m image.Image) error {
+	b := m.Bounds()
+	if b.Dx() != tw.w || tw.y+b.Dy() > tw.h {
+		return errors.New("tiffmeta: rows don't fit the image")
+	}
+	gray, isGray := m.(*image.Gray16)
+
+	// pixel data, big endian samples are swapped to little endian, or
+	// reduced to their high byte for 8-bit images
+	le := binary.LittleEndian
+	var v [4]uint16
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			switch tw.spp {
+			case 1:
+				if isGray {
+					v[0] = gray.Gray16At(x, y).Y
+				} else {
+					v[0] = color.Gray16Model.Convert(m.At(x, y)).(color.Gray16).Y
+				}
+			case 4:
+				c := color.NRGBA64Model.Convert(m.At(x, y)).(color.NRGBA64)
+				v = [4]uint16{c.R, c.G, c.B, c.A}
+			default:
+				r, g, bl, _ := m.At(x, y).RGBA()
+				v = [4]uint16{uint16(r), uint16(g), uint16(bl)}
+			}
+
+			i := (x - b.Min.X) * tw.spp
+			for c := 0; c < tw.spp; c++ {
+				if tw.bits == 8 {
+					tw.row[i+c] = uint8(v[c] >> 8)
+				} else {
+					le.PutUint16(tw.row[(i+c)*2:], v[c])
+				}
+			}
+		}
+		if _, err := tw.bw.Write(tw.row); err != nil {
+			return err
+		}
+	}
+	tw.y += b.Dy()
+	return nil
+}
+
+// Close writes the IFD, once every row has been written, and flushes the
+// output. It doesn't close the underlying writer.
+func (tw *Writer) Close() error {
+	if tw.y != tw.h {
+		return fmt.Errorf("tiffmeta: %v of %v rows written", tw.y, tw.h)
+	}
+	bw := tw.bw
+	le := binary.LittleEndian
+	if tw.size%2 != 0 {
+		bw.WriteByte(0)
+	}
+
+	// IFD entries, followed by out of line values
+	extra := tw.ifd + 2 + uint64(len(tw.all))*12 + 4
+	var values []byte
+	var e [12]byte
+	var n [2]byte
+	le.PutUint16(n[:], uint16(len(tw.all)))
+	bw.Write(n[:])
+	for _, t := range tw.all {
+		le.PutUint16(e[0:], t.ID)
+		le.PutUint16(e[2:], t.Type)
+		le.PutUint32(e[4:], t.Count)
+		for i := 8; i < 12; i++ {
+			e[i] = 0
+		}
+		if len(t.Value) <= 4 {
+			copy(e[8:], t.Value)
+		} else {
+			off := extra + uint64(len(values))
+			if off > maxUncompressed {
+				return errors.New("tiffmeta: metadata too large")
+			}
+			le.PutUint32(e[8:], uint32(off))
+			values = append(values, t.Value...)
+			if len(values)%2 != 0 {
+				values = append(values, 0)
+			}
+		}
+		bw.Write(e[:])
+	}
+	bw.Write([]byte{0, 0, 0, 0})
+	bw.Write(values)
+
+	return bw.Flush()
+}
@@ -0,0 +1,177 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package tiffmeta
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+)
+
+// Structural tags read by Reader, in addition to those written by Encode.
+const (
+	sampleFormat   = 339
+	formatUnsigned = 1
+)
+
+// ErrUnsupported is returned by NewReader for TIFF files whose layout it
+// cannot read a few rows at a time.
+var ErrUnsupported = errors.New("tiffmeta: unsupported TIFF layout")
+
+// A Reader reads the rows of an uncompressed 8 or 16-bit RGB or grayscale
+// TIFF a few at a time, such as a drum scan too large to decode at once.
+// Extra samples, such as alpha or infrared, are skipped.
+type Reader struct {
+	r        io.ReaderAt
+	bo       binary.ByteOrder
+	w, h     int
+	spp      int
+	bits     int
+	gray     bool
+	perStrip int
+	offsets  []uint32
+	tags     []Tag
+}
+
+// NewReader returns a Reader of the first image of the TIFF file r.
+func NewReader(r io.ReaderAt) (*Reader, error) {
+	bo, off, err := header(r)
+	if err != nil {
+		return nil, err
+	}
+	all, err := readIFD(r, bo, off)
+	if err != nil {
+		return nil, err
+	}
+
+	d := make(map[uint16][]uint32)
+	var tags []Tag
+	for _, t := range all {
+		d[t.ID] = t.uints()
+		if descriptive[t.ID] {
+			tags = append(tags, t)
+		}
+	}
+	get := func(id uint16, def uint32) uint32 {
+		if v := d[id]; len(v) > 0 {
+			return v[0]
+		}
+		return def
+	}
+
+	rd := &Reader{
+		r:       r,
+		bo:      bo,
+		w:       int(get(imageWidth, 0)),
+		h:       int(get(imageLength, 0)),
+		spp:     int(get(samplesPerPixel, 1)),
+		bits:    int(get(bitsPerSample, 1)),
+		offsets: d[stripOffsets],
+		tags:    tags,
+	}
+	rd.perStrip = int(get(rowsPerStrip, uint32(rd.h)))
+
+	switch get(photometric, 0) {
+	case photometricRGB:
+		if rd.spp < 3 {
+			return nil, fmt.Errorf("%w: %v samples per pixel", ErrUnsupported, rd.spp)
+		}
+	case photometricGray:
+		rd.gray = true
+	default:
+		return nil, fmt.Errorf("%w: photometric interpretation %v", ErrUnsupported, get(photometric, 0))
+	}
+	if c := get(compression, compressionNone); c != compressionNone {
+		return nil, fmt.Errorf("%w: compression %v", ErrUnsupported, c)
+	}
+	if get(planarConfig, planarContiguous) != planarContiguous {
+		return nil, fmt.Errorf("%w: planar samples", ErrUnsupported)
+	}
+	if get(sampleFormat, formatUnsigned) != formatUnsigned {
+		return nil, fmt.Errorf("%w: samples aren't unsigned integers", ErrUnsupported)
+	}
+	if rd.bits != 8 && rd.bits != 16 {
+		return nil, fmt.Errorf("%w: %v bits per sample", ErrUnsupported, rd.bits)
+	}
+	if len(rd.offsets) == 0 {
+		return nil, fmt.Errorf("%w: tiled image", ErrUnsupported)
+	}
+	if rd.w <= 0 || rd.h <= 0 || rd.perStrip <= 0 || len(rd.offsets) < (rd.h+rd.perStrip-1)/rd.perStrip {
+		return nil, errors.New("tiffmeta: invalid image layout")
+	}
+	return rd, nil
+}
+
+// Size returns the width and height of the image.
+func (rd *Reader) Size() image.Point {
+	return image.Pt(rd.w, rd.h)
+}
+
+// Tags returns the descriptive tags of the image, like Read.
+func (rd *Reader) Tags() []Tag {
+	return rd.tags
+}
+
+// ReadRows reads the rows of the image starting at y into m, as many as m is
+// high, each to the row of m at the same offset from its top.
+func (rd *Reader) ReadRows(m *image.RGBA64, y int) error {
+	w, n := m.Rect.Dx(), m.Rect.Dy()
+	if w != rd.w || y < 0 || y+n > rd.h {
+		return errors.New("tiffmeta: rows out of range")
+	}
+
+	stride := rd.w * rd.spp * rd.bits / 8
+	row := make([]byte, stride)
+	sample := func(i int) uint16 {
+		if rd.bits == 8 {
+			return uint16(row[i]) * 0x101
+		}
+		return rd.bo.Uint16(row[i*2:])
+	}
+
+	for j := 0; j < n; j++ {
+		s := (y + j) / rd.perStrip
+		off := int64(rd.offsets[s]) + int64((y+j)%rd.perStrip)*int64(stride)
+		if _, err := rd.r.ReadAt(row, off); err != nil {
+			return fmt.Errorf("tiffmeta: row %v: %w", y+j, err)
+		}
+
+		d := m.Pix[j*m.Stride:]
+		for x := 0; x < w; x++ {
+			var r, g, b uint16
+			if rd.gray {
+				r = sample(x * rd.spp)
+				g, b = r, r
+			} else {
+				r, g, b = sample(x*rd.spp), sample(x*rd.spp+1), sample(x*rd.spp+2)
+			}
+			p := d[x*8:]
+			binary.BigEndian.PutUint16(p, r)
+			binary.BigEndian.PutUint16(p[2:], g)
+			binary.BigEndian.PutUint16(p[4:], b)
+			p[6], p[7] = 0xff, 0xff
+		}
+	}
+	return nil
+}
+
+// uints returns the values of a short or long tag.
+func (t Tag) uints() []uint32 {
+	var v []uint32
+	switch t.Type {
+	case TypeShort:
+		for i := 0; i+2 <= len(t.Value); i += 2 {
+			v = append(v, uint32(binary.LittleEndian.Uint16(t.Value[i:])))
+		}
+	case TypeLong:
+		for i := 0; i+4 <= len(t.Value); i += 4 {
+			v = append(v, binary.LittleEndian.Uint32(t.Value[i:]))
+		}
+	}
+	return v
+}
@@ -0,0 +1,220 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+// Package tiffmeta carries TIFF metadata tags from an input file to a
+// converted output. Read extracts the descriptive tags (scanner make and
+// model, resolution, date, etc.) from a TIFF file, and Encode writes an
+// uncompressed 16-bit TIFF with arbitrary additional tags, which the
+// golang.org/x/image/tiff encoder does not support. Reader and Writer read
+// and write uncompressed TIFFs a few rows at a time, for images too large to
+// hold in memory.
+package tiffmeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+)
+
+// Well known tag IDs.
+const (
+	ProcessingSoftware = 11
+	ImageDescription   = 270
+	Make               = 271
+	Model              = 272
+	XResolution        = 282
+	YResolution        = 283
+	ResolutionUnit     = 296
+	Software           = 305
+	DateTime           = 306
+	Artist             = 315
+	HostComputer       = 316
+	Copyright          = 33432
+	ICCProfile         = 34675
+)
+
+// Tags copied from an input file by Read. Structural tags (dimensions,
+// strips, compression, etc.) describe the input encoding and are rewritten by
+// Encode instead.
+var descriptive = map[uint16]bool{
+	ProcessingSoftware: true,
+	ImageDescription:   true,
+	Make:               true,
+	Model:              true,
+	XResolution:        true,
+	YResolution:        true,
+	ResolutionUnit:     true,
+	Software:           true,
+	DateTime:           true,
+	Artist:             true,
+	HostComputer:       true,
+	Copyright:          true,
+}
+
+// Field types.
+const (
+	TypeByte      = 1
+	TypeASCII     = 2
+	TypeShort     = 3
+	TypeLong      = 4
+	TypeRational  = 5
+	TypeUndefined = 7
+)
+
+var typeSize = map[uint16]uint32{
+	TypeByte:      1,
+	TypeASCII:     1,
+	TypeShort:     2,
+	TypeLong:      4,
+	TypeRational:  8,
+	TypeUndefined: 1,
+}
+
+// A Tag is a single TIFF field. Value holds Count values of Type, little
+// endian encoded.
+type Tag struct {
+	ID    uint16
+	Type  uint16
+	Count uint32
+	Value []byte
+}
+
+// Undefined returns an undefined (opaque bytes) tag holding v.
+func Undefined(id uint16, v []byte) Tag {
+	return Tag{ID: id, Type: TypeUndefined, Count: uint32(len(v)), Value: v}
+}
+
+// ASCII returns an ASCII tag holding s.
+func ASCII(id uint16, s string) Tag {
+	v := append([]byte(s), 0)
+	return Tag{ID: id, Type: TypeASCII, Count: uint32(len(v)), Value: v}
+}
+
+// Read returns the descriptive tags of the first image of the TIFF file read
+// from r. If r is an io.ReaderAt, such as an *os.File, only the header and
+// tags are read.
+func Read(r io.Reader) ([]Tag, error) {
+	ra, ok := r.(io.ReaderAt)
+	if !ok {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		ra = bytes.NewReader(data)
+	}
+
+	bo, off, err := header(ra)
+	if err != nil {
+		return nil, err
+	}
+	all, err := readIFD(ra, bo, off)
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []Tag
+	for _, t := range all {
+		if descriptive[t.ID] {
+			tags = append(tags, t)
+		}
+	}
+	return tags, nil
+}
+
+// header returns the byte order of the TIFF file r and the offset of its
+// first IFD.
+func header(r io.ReaderAt) (binary.ByteOrder, uint32, error) {
+	var hdr [8]byte
+	if _, err := r.ReadAt(hdr[:], 0); err != nil {
+		return nil, 0, errors.New("tiffmeta: file too short")
+	}
+
+	var bo binary.ByteOrder
+	switch string(hdr[:4]) {
+	case "II*\x00":
+		bo = binary.LittleEndian
+	case "MM\x00*":
+		bo = binary.BigEndian
+	default:
+		return nil, 0, errors.New("tiffmeta: not a TIFF file")
+	}
+	return bo, bo.Uint32(hdr[4:]), nil
+}
+
+// readIFD returns the tags of the IFD at off of the TIFF file r, skipping
+// those of unknown types or too large to be metadata.
+func readIFD(r io.ReaderAt, bo binary.ByteOrder, off uint32) ([]Tag, error) {
+	var n [2]byte
+	if _, err := r.ReadAt(n[:], int64(off)); err != nil {
+		return nil, errors.New("tiffmeta: IFD offset out of range")
+	}
+	entries := make([]byte, int(bo.Uint16(n[:]))*12)
+	if _, err := r.ReadAt(entries, int64(off)+2); err != nil {
+		return nil, errors.New("tiffmeta: IFD out of range")
+	}
+
+	var tags []Tag
+	for i := 0; i < len(entries); i += 12 {
+		e := entries[i:]
+		t := Tag{
+			ID:    bo.Uint16(e),
+			Type:  bo.Uint16(e[2:]),
+			Count: bo.Uint32(e[4:]),
+		}
+		size, ok := typeSize[t.Type]
+		if !ok || t.Count > 1<<20 {
+			continue
+		}
+
+		v := e[8:12]
+		if size*t.Count > 4 {
+			v = make([]byte, size*t.Count)
+			if _, err := r.ReadAt(v, int64(bo.Uint32(e[8:]))); err != nil {
+				continue
+			}
+		}
+		t.Value = toLittleEndian(v[:size*t.Count], bo, t.Type)
+		tags = append(tags, t)
+	}
+	return tags, nil
+}
+
+// toLittleEndian copies v, swapping the byte order of multi-byte values.
+func toLittleEndian(v []byte, bo binary.ByteOrder, typ uint16) []byte {
+	ret := make([]byte, len(v))
+	copy(ret, v)
+	if bo == binary.LittleEndian {
+		return ret
+	}
+
+	switch typ {
+	case TypeShort:
+		for i := 0; i+2 <= len(v); i += 2 {
+			binary.LittleEndian.PutUint16(ret[i:], bo.Uint16(v[i:]))
+		}
+	case TypeLong, TypeRational:
+		for i := 0; i+4 <= len(v); i += 4 {
+			binary.LittleEndian.PutUint32(ret[i:], bo.Uint32(v[i:]))
+		}
+	}
+	return ret
+}
+
+// Set returns tags with t added, replacing any existing tag with the same ID.
+func Set(tags []Tag, t Tag) []Tag {
+	for i := range tags {
+		if tags[i].ID == t.ID {
+			tags[i] = t
+			return tags
+		}
+	}
+	return append(tags, t)
+}
+
+func sortTags(tags []Tag) {
+	sort.Slice(tags, func(i, j int) bool { return tags[i].ID < tags[j].ID })
+}
@@ -0,0 +1,138 @@
+// Copyright 2023 David Fritz
+//
+// This software may be modified and distributed under the terms of the
+// BSD 2-clause license. See the LICENSE file for details.
+
+package positive
+
+import (
+	"context"
+	"image"
+)
+
+// DefaultTileRows is the number of rows ProcessTiled converts at a time if
+// rows is <= 0.
+const DefaultTileRows = 256
+
+// Rows is an image read a stripe of rows at a time, such as a scan too large
+// to decode at once. See ProcessTiled.
+type Rows interface {
+	// Size returns the width and height of the image.
+	Size() image.Point
+
+	// ReadRows reads the rows of the image starting at y into m, as many
+	// as m is high, each to the row of m at the same offset from its top.
+	ReadRows(m *image.RGBA64, y int) error
+}
+
+// ProcessTiled converts the image read from src like ProcessContext, but
+// rows rows at a time, so the memory used is bounded by the width of the
+// image instead of its size. A first pass over src finds the normalization
+// levels, unless o.Levels is set, and a second converts each stripe of rows
+// and passes it to write, in order from the top. Stages that look at the
+// whole image or at neighboring pixels can't be used: Dust, Denoise,
+// LocalContrast, Sharpen, Exclude, Neutral, and Balance. Neither can Alpha,
+// as src is read as opaque.
+func ProcessTiled(ctx context.Context, src Rows, o Options, rows int, write func(m image.Image) error) error {
+	size := src.Size()
+	bounds := image.Rectangle{Max: size}
+	if err := o.validate(bounds); err != nil {
+		return err
+	}
+	if err := o.tiled(); err != nil {
+		return err
+	}
+	if rows <= 0 {
+		rows = DefaultTileRows
+	}
+
+	// read returns the stripe of rows of src starting at y, with the bounds
+	// it has in the image
+	buf := image.NewRGBA64(image.Rect(0, 0, size.X, rows))
+	read := func(y int) (*image.RGBA64, error) {
+		n := rows
+		if y+n > size.Y {
+			n = size.Y - y
+		}
+		m := &image.RGBA64{
+			Pix:    buf.Pix[:n*buf.Stride],
+			Stride: buf.Stride,
+			Rect:   image.Rect(0, y, size.X, y+n),
+		}
+		return m, src.ReadRows(m, y)
+	}
+
+	interior := o.interior(bounds)
+	find := o.Normalize && o.Levels == nil
+	total := size.Y
+	if find {
+		total += interior.Dy()
+	}
+	ctx, mt := withMeter(ctx, o.OnProgress, total)
+
+	if find {
+		mt.stage("levels")
+		h := new([3]histogram)
+		pre := o.pre()
+		for y := interior.Min.Y; y < interior.Max.Y; y += rows {
+			m, err := read(y)
+			if err != nil {
+				return err
+			}
+			countLevels(ctx, h, m, pre, interior.Intersect(m.Rect), nil, o.Threads)
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		l := histLevels(h, o.Upper, o.Lower)
+		if o.Linked {
+			l = l.link()
+		}
+		o.Levels = &l
+	}
+
+	// each stripe is converted on its own with the levels of the whole
+	// image, so the region they were found in no longer matters
+	mt.stage("convert")
+	o.ROI, o.Margins, o.OnProgress = image.Rectangle{}, nil, nil
+	for y := 0; y < size.Y; y += rows {
+		m, err := read(y)
+		if err != nil {
+			return err
+		}
+		p, _, err := process(ctx, m, o)
+		if err != nil {
+			return err
+		}
+		if err := write(p); err != nil {
+			return err
+		}
+	}
+	mt.finish()
+	return nil
+}
+
+// tiled returns an error if o uses a stage ProcessTiled can't run a stripe
+// of rows at a time.
+func (o Options) tiled() error {
+	var stage string
+	switch {
+	case o.Dust > 0:
+		stage = "dust removal"
+	case o.Denoise > 0 || o.DenoiseChroma > 0:
+		stage = "noise reduction"
+	case o.LocalContrast > 0:
+		stage = "local contrast"
+	case o.Sharpen > 0:
+		stage = "sharpening"
+	case o.Exclude != nil:
+		stage = "an exclusion mask"
+	case !o.Neutral.Empty() || o.Balance != BalanceNone:
+		stage = "white balance from the image"
+	case o.Alpha:
+		stage = "the alpha channel"
+	default:
+		return nil
+	}
+	return errorf(ErrInvalidOptions, "%v can't be used with tiled processing", stage)
+}